@@ -0,0 +1,129 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClonedImageSpec identifies the image that was mirrored
+type ClonedImageSpec struct {
+	// Source is the upstream reference the image was cloned from
+	Source string `json:"source"`
+
+	// Destination is the reference the image was written to
+	Destination string `json:"destination"`
+}
+
+// WorkloadReference identifies a workload using a cloned image
+type WorkloadReference struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// ReplicaStatus records the replication of the image to an additional destination
+type ReplicaStatus struct {
+	// Destination is the reference the image is replicated to
+	Destination string `json:"destination"`
+
+	// DestinationDigest is the digest of the manifest last written to the replica
+	DestinationDigest string `json:"destinationDigest,omitempty"`
+
+	// LastSyncTime is when the image was last written to the replica
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+
+	// Error of the last failed replication, empty once the replica is in sync
+	Error string `json:"error,omitempty"`
+}
+
+// ClonedImageStatus records the provenance of the mirrored image
+type ClonedImageStatus struct {
+	// SourceDigest is the digest of the manifest read from the source
+	SourceDigest string `json:"sourceDigest,omitempty"`
+
+	// DestinationDigest is the digest of the manifest written to the destination
+	DestinationDigest string `json:"destinationDigest,omitempty"`
+
+	// MediaType of the written manifest
+	MediaType string `json:"mediaType,omitempty"`
+
+	// Size in bytes of the manifest, config and layers
+	Size int64 `json:"size,omitempty"`
+
+	// Platforms the image was built for e.g linux/amd64
+	Platforms []string `json:"platforms,omitempty"`
+
+	// FirstSyncTime is when the image was first written to the destination
+	FirstSyncTime *metav1.Time `json:"firstSyncTime,omitempty"`
+
+	// LastSyncTime is when the image was last written to the destination
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+
+	// Workloads that were rewritten to use the destination
+	Workloads []WorkloadReference `json:"workloads,omitempty"`
+
+	// Access to the source, public when it can be pulled anonymously and restricted otherwise
+	Access string `json:"access,omitempty"`
+
+	// AuthorizedNamespaces whose workloads were allowed to use the restricted source
+	AuthorizedNamespaces []string `json:"authorizedNamespaces,omitempty"`
+
+	// AuthorizedPullSecrets are the <namespace>/<name> of the pull secrets found able to pull the restricted source
+	AuthorizedPullSecrets []string `json:"authorizedPullSecrets,omitempty"`
+
+	// Replicas the image is copied to besides the destination
+	Replicas []ReplicaStatus `json:"replicas,omitempty"`
+
+	// Artifacts such as signatures, attestations and referrers copied alongside the image
+	Artifacts []string `json:"artifacts,omitempty"`
+
+	// ArtifactsError of the last failed copy of the artifacts, empty once they are copied
+	ArtifactsError string `json:"artifactsError,omitempty"`
+
+	// UnreferencedSince is when the garbage collector first found no workload using the destination
+	UnreferencedSince *metav1.Time `json:"unreferencedSince,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Source",type=string,JSONPath=`.spec.source`
+// +kubebuilder:printcolumn:name="Destination",type=string,JSONPath=`.spec.destination`
+// +kubebuilder:printcolumn:name="Last Sync",type=date,JSONPath=`.status.lastSyncTime`
+
+// ClonedImage records an image mirrored by the controller.
+// It is written by the controller only, so its status is part of the main resource
+type ClonedImage struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClonedImageSpec   `json:"spec,omitempty"`
+	Status ClonedImageStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ClonedImageList contains a list of ClonedImage
+type ClonedImageList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClonedImage `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClonedImage{}, &ClonedImageList{})
+}
@@ -0,0 +1,203 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	cachev1alpha1 "github.com/Tiemma/image-clone-controller/api/v1alpha1"
+	"github.com/Tiemma/image-clone-controller/pkg/annotations"
+	"github.com/Tiemma/image-clone-controller/pkg/docker"
+	"github.com/Tiemma/image-clone-controller/pkg/inventory"
+	"github.com/Tiemma/image-clone-controller/pkg/metrics"
+	"github.com/go-logr/logr"
+	"github.com/google/go-containerregistry/pkg/name"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Event reasons emitted on workloads using a cached image found broken by the auditor
+const (
+	reasonCachedImageRepaired = "CachedImageRepaired"
+	reasonCachedImageBroken   = "CachedImageBroken"
+)
+
+// Results reported by the audited images metric
+const (
+	auditHealthy     = "healthy"
+	auditRepaired    = "repaired"
+	auditBroken      = "broken"
+	auditUnreachable = "unreachable"
+)
+
+// auditOutcome is the result of auditing a cached image along with a description of what was wrong
+type auditOutcome struct {
+	result  string
+	message string
+}
+
+// Auditor periodically checks that every cached image used by a workload still
+// resolves to what was cloned, re-cloning the ones deleted or altered in the registry
+type Auditor struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+	Interval time.Duration
+}
+
+// Start audits cached images until ctx is done, implementing manager.Runnable
+func (a *Auditor) Start(ctx context.Context) error {
+	ticker := time.NewTicker(a.Interval)
+	defer ticker.Stop()
+
+	for {
+		a.auditAll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *Auditor) auditAll(ctx context.Context) {
+	workloads, err := listWorkloads(ctx, a.Client)
+	if err != nil {
+		a.Log.Error(err, "error occurred listing workloads")
+		return
+	}
+
+	// Workloads often share images, each one is only checked once per audit
+	outcomes := map[string]auditOutcome{}
+	for _, obj := range workloads {
+		template := podTemplateOf(obj)
+		originals, err := annotations.GetOriginalImages(obj)
+		if err != nil {
+			a.Log.Error(err, "error occurred reading original images", "namespace", obj.GetNamespace(), "name", obj.GetName())
+		}
+
+		for _, c := range podImages(&template.Spec) {
+			if !docker.IsCacheURL(c.image, obj.GetNamespace()) {
+				continue
+			}
+
+			outcome, audited := outcomes[c.image]
+			if !audited {
+				outcome = a.audit(ctx, c.image, originals[annotations.ContainerKey(c.field, c.name)], obj.GetNamespace())
+				outcomes[c.image] = outcome
+				metrics.UpdateAuditedImagesMetric(outcome.result)
+			}
+
+			switch outcome.result {
+			case auditRepaired:
+				a.Recorder.Event(obj, corev1.EventTypeNormal, reasonCachedImageRepaired, outcome.message)
+			case auditBroken:
+				a.Recorder.Event(obj, corev1.EventTypeWarning, reasonCachedImageBroken, outcome.message)
+			}
+		}
+	}
+}
+
+// audit checks a single cached image, re-cloning it from upstream when it is broken.
+// upstream is the image recorded on the workload of namespace and may be empty
+func (a *Auditor) audit(ctx context.Context, image, upstream, namespace string) auditOutcome {
+	log := a.Log.WithValues("image", image)
+
+	digest, found, err := docker.DestinationDigest(image)
+	if err != nil {
+		// Registry outages are handled by the fallback, nothing can be concluded about the image
+		log.Error(err, "error occurred auditing cached image")
+		return auditOutcome{result: auditUnreachable}
+	}
+
+	clonedImage := a.clonedImageOf(ctx, image)
+	problem := fmt.Sprintf("Cached image %s was deleted from the registry", image)
+	if found {
+		if clonedImage == nil || clonedImage.Status.DestinationDigest == "" || clonedImage.Status.DestinationDigest == digest {
+			return auditOutcome{result: auditHealthy}
+		}
+		problem = fmt.Sprintf("Cached image %s resolves to %s instead of the cloned %s", image, digest, clonedImage.Status.DestinationDigest)
+	}
+
+	// Repairs restore the image that was cloned, picking up upstream changes is left to the resync policy
+	sourceDigest := ""
+	if clonedImage != nil && clonedImage.Status.SourceDigest != "" {
+		upstream, sourceDigest = clonedImage.Spec.Source, clonedImage.Status.SourceDigest
+	}
+	if upstream == "" && clonedImage != nil {
+		upstream = clonedImage.Spec.Source
+	}
+	if upstream == "" {
+		log.Info("Cached image is broken and its upstream image is unknown")
+		return auditOutcome{result: auditBroken, message: fmt.Sprintf("%s and its upstream image is unknown", problem)}
+	}
+
+	if err := docker.Recopy(ctx, upstream, sourceDigest, image, workloadNamespaces(clonedImage, namespace), replicaDestinations(clonedImage)); err != nil {
+		log.Error(err, "error occurred repairing cached image")
+		return auditOutcome{result: auditBroken, message: fmt.Sprintf("%s and re-cloning %s failed: %s", problem, upstream, err)}
+	}
+	log.Info("Repaired cached image", "upstream", upstream)
+
+	return auditOutcome{result: auditRepaired, message: fmt.Sprintf("%s, re-cloned it from %s", problem, upstream)}
+}
+
+// clonedImageOf returns the ClonedImage recorded for a cached image, or nil when there is none
+func (a *Auditor) clonedImageOf(ctx context.Context, image string) *cachev1alpha1.ClonedImage {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return nil
+	}
+
+	clonedImage := &cachev1alpha1.ClonedImage{}
+	if err := a.Client.Get(ctx, types.NamespacedName{Name: inventory.Name(ref.Name())}, clonedImage); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			a.Log.Error(err, "error occurred getting cloned image", "image", image)
+		}
+		return nil
+	}
+
+	return clonedImage
+}
+
+// listWorkloads returns every workload of the kinds handled by the controller
+func listWorkloads(ctx context.Context, c client.Client) ([]client.Object, error) {
+	var workloads []client.Object
+
+	deployments := &appsv1.DeploymentList{}
+	if err := c.List(ctx, deployments); err != nil {
+		return nil, err
+	}
+	for i := range deployments.Items {
+		workloads = append(workloads, &deployments.Items[i])
+	}
+
+	daemonSets := &appsv1.DaemonSetList{}
+	if err := c.List(ctx, daemonSets); err != nil {
+		return nil, err
+	}
+	for i := range daemonSets.Items {
+		workloads = append(workloads, &daemonSets.Items[i])
+	}
+
+	return workloads, nil
+}
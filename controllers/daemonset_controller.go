@@ -18,68 +18,53 @@ package controllers
 
 import (
 	"context"
-	"github.com/Tiemma/image-clone-controller/pkg/metrics"
-	"time"
 
-	"github.com/Tiemma/image-clone-controller/pkg/docker"
-	"github.com/Tiemma/image-clone-controller/pkg/env"
 	"github.com/Tiemma/image-clone-controller/pkg/errors"
-	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
-	"k8s.io/apimachinery/pkg/runtime"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 )
 
+const daemonSetKind = "DaemonSet"
+
 // DaemonSetReconciler reconciles a DaemonSet object
 type DaemonSetReconciler struct {
-	client.Client
-	Log               logr.Logger
-	Scheme            *runtime.Scheme
-	KubeServerVersion string
-	RetryDelay        time.Duration
+	WorkloadReconciler
 }
 
 // +kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=apps,resources=daemonsets/status,verbs=get;update;patch
 
 func (r *DaemonSetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = r.Log.WithValues("daemonSet", req.NamespacedName)
+	log := r.Log.WithValues("daemonSet", req.NamespacedName)
 
 	daemonSet := &appsv1.DaemonSet{}
 
 	if err := r.Client.Get(ctx, req.NamespacedName, daemonSet); err != nil {
-		metrics.UpdateFailedImageClonesMetric(daemonSet.Name, daemonSet.Namespace, daemonSet.Kind, "", errors.SpecGet)
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
 
-		return ctrl.Result{
-			RequeueAfter: r.RetryDelay,
-		}, errors.ErrorGettingResource("DaemonSet", err)
+		requested := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: req.Name, Namespace: req.Namespace}}
+		return r.handleErr(log, daemonSetKind, requested, errors.ErrorGettingResource(daemonSetKind, err))
 	}
 
-	if env.IsSkippableNamespace(daemonSet.Kind, daemonSet.Namespace) {
-		return ctrl.Result{}, nil
-	}
+	return r.reconcileWorkload(ctx, log, daemonSetKind, daemonSet, &daemonSet.Spec.Template)
+}
 
-	image, errType := docker.MustCacheAndModifyPodImage(&daemonSet.Spec.Template.Spec, r.KubeServerVersion)
-	if errType != "" {
-		metrics.UpdateFailedImageClonesMetric(daemonSet.Name, daemonSet.Namespace, daemonSet.Kind, image, errType)
-		return ctrl.Result{
-			RequeueAfter: r.RetryDelay,
-		}, errors.ErrorCloningImage(image, errType)
-	}
+func (r *DaemonSetReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	blder := ctrl.NewControllerManagedBy(mgr).
+		For(&appsv1.DaemonSet{}, builder.WithPredicates(workloadPredicates()))
 
-	if err := r.Client.Update(ctx, daemonSet); err != nil {
-		metrics.UpdateFailedImageClonesMetric(daemonSet.Name, daemonSet.Namespace, daemonSet.Kind, "", errors.SpecUpdate)
-		return ctrl.Result{
-			RequeueAfter: r.RetryDelay,
-		}, errors.ErrorUpdatingResource(daemonSet.Name, daemonSet.Namespace, daemonSet.Kind, err)
+	src, eventHandler, err := r.watchRegistryHealth(mgr, &appsv1.DaemonSetList{})
+	if err != nil {
+		return err
+	}
+	if src != nil {
+		blder = blder.Watches(src, eventHandler)
 	}
 
-	return ctrl.Result{}, nil
-}
-
-func (r *DaemonSetReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&appsv1.DaemonSet{}).
-		Complete(r)
+	return blder.Complete(r)
 }
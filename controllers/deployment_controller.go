@@ -18,67 +18,52 @@ package controllers
 
 import (
 	"context"
-	"github.com/Tiemma/image-clone-controller/pkg/docker"
-	"github.com/Tiemma/image-clone-controller/pkg/env"
 	"github.com/Tiemma/image-clone-controller/pkg/errors"
-	"github.com/Tiemma/image-clone-controller/pkg/metrics"
-	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
-	"k8s.io/apimachinery/pkg/runtime"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/client"
-	"time"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 )
 
+const deploymentKind = "Deployment"
+
 // DeploymentReconciler reconciles a Deployment object
 type DeploymentReconciler struct {
-	client.Client
-	Log               logr.Logger
-	Scheme            *runtime.Scheme
-	KubeServerVersion string
-	RetryDelay        time.Duration
+	WorkloadReconciler
 }
 
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=apps,resources=deployments/status,verbs=get;update;patch
 
 func (r *DeploymentReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = r.Log.WithValues("deployment", req.NamespacedName)
+	log := r.Log.WithValues("deployment", req.NamespacedName)
 
 	deployment := &appsv1.Deployment{}
 
 	if err := r.Client.Get(ctx, req.NamespacedName, deployment); err != nil {
-		metrics.UpdateFailedImageClonesMetric(deployment.Name, deployment.Namespace, deployment.Kind, "", errors.SpecGet)
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
 
-		return ctrl.Result{
-			RequeueAfter: r.RetryDelay,
-		}, errors.ErrorGettingResource("Deployment", err)
+		requested := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: req.Name, Namespace: req.Namespace}}
+		return r.handleErr(log, deploymentKind, requested, errors.ErrorGettingResource(deploymentKind, err))
 	}
 
-	if env.IsSkippableNamespace(deployment.Kind, deployment.Namespace) {
-		return ctrl.Result{}, nil
-	}
+	return r.reconcileWorkload(ctx, log, deploymentKind, deployment, &deployment.Spec.Template)
+}
 
-	image, errType := docker.MustCacheAndModifyPodImage(&deployment.Spec.Template.Spec, r.KubeServerVersion)
-	if errType != "" {
-		metrics.UpdateFailedImageClonesMetric(deployment.Name, deployment.Namespace, deployment.Kind, image, errType)
-		return ctrl.Result{
-			RequeueAfter: r.RetryDelay,
-		}, errors.ErrorCloningImage(image, errType)
-	}
+func (r *DeploymentReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	blder := ctrl.NewControllerManagedBy(mgr).
+		For(&appsv1.Deployment{}, builder.WithPredicates(workloadPredicates()))
 
-	if err := r.Client.Update(ctx, deployment); err != nil {
-		metrics.UpdateFailedImageClonesMetric(deployment.Name, deployment.Namespace, deployment.Kind, "", errors.SpecUpdate)
-		return ctrl.Result{
-			RequeueAfter: r.RetryDelay,
-		}, errors.ErrorUpdatingResource(deployment.Name, deployment.Namespace, deployment.Kind, err)
+	src, eventHandler, err := r.watchRegistryHealth(mgr, &appsv1.DeploymentList{})
+	if err != nil {
+		return err
+	}
+	if src != nil {
+		blder = blder.Watches(src, eventHandler)
 	}
 
-	return ctrl.Result{}, nil
-}
-
-func (r *DeploymentReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&appsv1.Deployment{}).
-		Complete(r)
+	return blder.Complete(r)
 }
@@ -0,0 +1,175 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/Tiemma/image-clone-controller/pkg/annotations"
+	"github.com/Tiemma/image-clone-controller/pkg/docker"
+	"github.com/Tiemma/image-clone-controller/pkg/errors"
+	"github.com/Tiemma/image-clone-controller/pkg/metrics"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// Directions reported when workloads switch registries
+const (
+	directionUpstream = "upstream"
+	directionCache    = "cache"
+)
+
+// registryFallback handles workloads while a destination registry they use is unhealthy
+// and once it recovers. It returns false when the reconcile should carry on as usual
+func (r *WorkloadReconciler) registryFallback(ctx context.Context, log logr.Logger, kind string, obj client.Object, template *corev1.PodTemplateSpec) (ctrl.Result, bool) {
+	if r.Health == nil {
+		return ctrl.Result{}, false
+	}
+
+	inFallback := annotations.Has(obj, annotations.FallbackImages)
+	healthy := r.registriesHealthy(obj, template)
+	switch {
+	case !healthy && inFallback:
+		return r.skip(obj, kind, skipReasonRegistryUnhealthy, "Destination registry is unhealthy, keeping upstream images"), true
+	case !healthy && annotations.Has(obj, annotations.OriginalImages):
+		return r.switchRegistry(ctx, log, kind, obj, directionUpstream, func() ([]patchOp, error) {
+			return fallbackPatchOps(obj, template)
+		}), true
+	case healthy && inFallback:
+		return r.switchRegistry(ctx, log, kind, obj, directionCache, func() ([]patchOp, error) {
+			return restorePatchOps(obj, template)
+		}), true
+	}
+
+	return ctrl.Result{}, false
+}
+
+// registriesHealthy reports whether the registries hosting the cached images of obj are healthy.
+// While switched upstream the cached images are the ones recorded in the fallback annotation
+func (r *WorkloadReconciler) registriesHealthy(obj client.Object, template *corev1.PodTemplateSpec) bool {
+	var images []string
+	if annotations.Has(obj, annotations.FallbackImages) {
+		cached, err := annotations.GetFallbackImages(obj)
+		if err != nil {
+			// A corrupted annotation is removed once restoring fails on it
+			return true
+		}
+		for _, image := range cached {
+			images = append(images, image)
+		}
+	} else {
+		for _, c := range podImages(&template.Spec) {
+			images = append(images, c.image)
+		}
+	}
+
+	for _, image := range images {
+		if docker.IsCacheURL(image, obj.GetNamespace()) && !r.Health.HealthyFor(image) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// switchRegistry patches obj with the ops of buildOps, only reporting the switch when something changed
+func (r *WorkloadReconciler) switchRegistry(ctx context.Context, log logr.Logger, kind string, obj client.Object, direction string, buildOps func() ([]patchOp, error)) ctrl.Result {
+	patched := false
+	err := r.patchWorkload(ctx, obj, func() ([]patchOp, error) {
+		ops, err := buildOps()
+		patched = len(ops) > 0
+		return ops, err
+	})
+	if err != nil {
+		res, _ := r.handleErr(log, kind, obj, errors.ErrorUpdatingResource(obj.GetName(), obj.GetNamespace(), kind, err))
+		return res
+	}
+	if !patched {
+		return ctrl.Result{}
+	}
+
+	metrics.UpdateRegistrySwitchesMetric(kind, direction)
+	if direction == directionUpstream {
+		r.Recorder.Event(obj, corev1.EventTypeWarning, reasonRegistryFallback,
+			"Destination registry is unhealthy, switched cached images back to their upstream image")
+	} else {
+		r.Recorder.Event(obj, corev1.EventTypeNormal, reasonRegistryRecovered,
+			"Destination registry recovered, switched images back to the cache")
+	}
+	log.Info("Switched workload images", "to", direction)
+
+	return ctrl.Result{}
+}
+
+// enqueueOnHealthChange sends every workload in list with rewritten images to events
+// whenever the health of a destination registry changes
+func (r *WorkloadReconciler) enqueueOnHealthChange(list client.ObjectList, events chan<- event.GenericEvent) manager.RunnableFunc {
+	changes := r.Health.Subscribe()
+	return func(ctx context.Context) error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-changes:
+			}
+
+			if err := r.Client.List(ctx, list); err != nil {
+				r.Log.Error(err, "error occurred listing workloads after registry health change")
+				continue
+			}
+
+			items, err := meta.ExtractList(list)
+			if err != nil {
+				return err
+			}
+			for _, item := range items {
+				obj, ok := item.(client.Object)
+				if !ok || !annotations.Has(obj, annotations.OriginalImages) {
+					continue
+				}
+
+				select {
+				case events <- event.GenericEvent{Object: obj}:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// watchRegistryHealth returns a source of reconcile requests for the workloads in list
+// affected by registry health changes, or nil when fallback is disabled
+func (r *WorkloadReconciler) watchRegistryHealth(mgr ctrl.Manager, list client.ObjectList) (source.Source, handler.EventHandler, error) {
+	if r.Health == nil {
+		return nil, nil, nil
+	}
+
+	events := make(chan event.GenericEvent)
+	if err := mgr.Add(r.enqueueOnHealthChange(list, events)); err != nil {
+		return nil, nil, err
+	}
+
+	return &source.Channel{Source: events}, &handler.EnqueueRequestForObject{}, nil
+}
@@ -0,0 +1,304 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	cachev1alpha1 "github.com/Tiemma/image-clone-controller/api/v1alpha1"
+	"github.com/Tiemma/image-clone-controller/pkg/annotations"
+	"github.com/Tiemma/image-clone-controller/pkg/docker"
+	"github.com/Tiemma/image-clone-controller/pkg/metrics"
+	"github.com/go-logr/logr"
+	"github.com/google/go-containerregistry/pkg/name"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Actions reported by the garbage collected images metric
+const (
+	gcActionDeleted     = "deleted"
+	gcActionWouldDelete = "would_delete"
+	gcActionFailed      = "failed"
+)
+
+// GarbageCollector periodically deletes the cached images no workload uses anymore.
+// Images are tracked through their ClonedImage, which records since when they are unused
+type GarbageCollector struct {
+	client.Client
+	// Reader lists pods and the other objects running images straight from the API server,
+	// so the manager does not cache every pod of the cluster
+	Reader   client.Reader
+	Log      logr.Logger
+	Interval time.Duration
+	// GracePeriod an image must stay unused before it is deleted
+	GracePeriod time.Duration
+	// KeepLast images of every repository are never deleted, most recently synced first
+	KeepLast int
+	// DryRun only reports what would be deleted
+	DryRun bool
+}
+
+// Start collects garbage until ctx is done, implementing manager.Runnable
+func (g *GarbageCollector) Start(ctx context.Context) error {
+	ticker := time.NewTicker(g.Interval)
+	defer ticker.Stop()
+
+	for {
+		g.collect(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (g *GarbageCollector) collect(ctx context.Context) {
+	now := time.Now()
+	referenced, err := g.referencedImages(ctx)
+	if err != nil {
+		g.Log.Error(err, "error occurred listing workloads")
+		return
+	}
+
+	clonedImages := &cachev1alpha1.ClonedImageList{}
+	if err := g.Client.List(ctx, clonedImages); err != nil {
+		g.Log.Error(err, "error occurred listing cloned images")
+		return
+	}
+
+	for i := range clonedImages.Items {
+		if err := g.trackReferences(ctx, &clonedImages.Items[i], referenced, now); err != nil {
+			g.Log.Error(err, "error occurred tracking references", "clonedImage", clonedImages.Items[i].Name)
+		}
+	}
+
+	garbage := collectable(clonedImages.Items, now, g.GracePeriod, g.KeepLast)
+	for _, clonedImage := range garbage {
+		log := g.Log.WithValues("destination", clonedImage.Spec.Destination, "digest", clonedImage.Status.DestinationDigest,
+			"unreferencedSince", clonedImage.Status.UnreferencedSince.Time.String())
+		if g.DryRun {
+			log.Info("Would delete unused cached image")
+			metrics.UpdateGarbageCollectedImagesMetric(gcActionWouldDelete)
+			continue
+		}
+
+		if err := docker.DeleteImage(ctx, clonedImage.Spec.Destination, clonedImage.Status.DestinationDigest); err != nil {
+			log.Error(err, "error occurred deleting unused cached image")
+			metrics.UpdateGarbageCollectedImagesMetric(gcActionFailed)
+			continue
+		}
+		for _, replica := range clonedImage.Status.Replicas {
+			if replica.DestinationDigest == "" {
+				continue
+			}
+			if err := docker.DeleteImage(ctx, replica.Destination, replica.DestinationDigest); err != nil {
+				log.Error(err, "error occurred deleting unused replica", "replica", replica.Destination)
+			}
+		}
+		if err := g.Client.Delete(ctx, clonedImage); client.IgnoreNotFound(err) != nil {
+			log.Error(err, "error occurred deleting cloned image")
+		}
+		log.Info("Deleted unused cached image")
+		metrics.UpdateGarbageCollectedImagesMetric(gcActionDeleted)
+	}
+
+	if g.DryRun {
+		g.Log.Info("Garbage collection dry run done", "wouldDelete", len(garbage), "clonedImages", len(clonedImages.Items))
+	}
+}
+
+// referencedImages returns the cached images used by any workload, including the ones
+// remembered while workloads temporarily run their upstream images
+func (g *GarbageCollector) referencedImages(ctx context.Context) (map[string]bool, error) {
+	workloads, err := listWorkloads(ctx, g.Client)
+	if err != nil {
+		return nil, err
+	}
+
+	referenced := map[string]bool{}
+	reference := func(image string) {
+		if ref, err := name.ParseReference(image); err == nil {
+			referenced[ref.Name()] = true
+		}
+	}
+	podSpecs, err := g.runningPodSpecs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, podSpec := range podSpecs {
+		for _, c := range podImages(podSpec) {
+			reference(c.image)
+		}
+	}
+	for _, obj := range workloads {
+		for _, c := range podImages(&podTemplateOf(obj).Spec) {
+			reference(c.image)
+		}
+
+		fallbackImages, err := annotations.GetFallbackImages(obj)
+		if err != nil {
+			return nil, err
+		}
+		for _, image := range fallbackImages {
+			reference(image)
+		}
+	}
+
+	return referenced, nil
+}
+
+// +kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=list
+// +kubebuilder:rbac:groups=batch,resources=cronjobs;jobs,verbs=list
+
+// runningPodSpecs returns the pod specs images may still be pulled for besides the ones of workloads:
+// pods of previous revisions, ReplicaSets kept for rollbacks, StatefulSets, Jobs and CronJobs.
+// Kinds the cluster does not serve are skipped
+func (g *GarbageCollector) runningPodSpecs(ctx context.Context) ([]*corev1.PodSpec, error) {
+	var podSpecs []*corev1.PodSpec
+
+	pods := &corev1.PodList{}
+	if err := g.listServed(ctx, pods); err != nil {
+		return nil, err
+	}
+	for i := range pods.Items {
+		podSpecs = append(podSpecs, &pods.Items[i].Spec)
+	}
+
+	replicaSets := &appsv1.ReplicaSetList{}
+	if err := g.listServed(ctx, replicaSets); err != nil {
+		return nil, err
+	}
+	for i := range replicaSets.Items {
+		podSpecs = append(podSpecs, &replicaSets.Items[i].Spec.Template.Spec)
+	}
+
+	statefulSets := &appsv1.StatefulSetList{}
+	if err := g.listServed(ctx, statefulSets); err != nil {
+		return nil, err
+	}
+	for i := range statefulSets.Items {
+		podSpecs = append(podSpecs, &statefulSets.Items[i].Spec.Template.Spec)
+	}
+
+	jobs := &batchv1.JobList{}
+	if err := g.listServed(ctx, jobs); err != nil {
+		return nil, err
+	}
+	for i := range jobs.Items {
+		podSpecs = append(podSpecs, &jobs.Items[i].Spec.Template.Spec)
+	}
+
+	cronJobs := &batchv1beta1.CronJobList{}
+	if err := g.listServed(ctx, cronJobs); err != nil {
+		return nil, err
+	}
+	for i := range cronJobs.Items {
+		podSpecs = append(podSpecs, &cronJobs.Items[i].Spec.JobTemplate.Spec.Template.Spec)
+	}
+
+	return podSpecs, nil
+}
+
+// listServed lists every object of list, leaving it empty when the cluster does not serve its kind
+func (g *GarbageCollector) listServed(ctx context.Context, list client.ObjectList) error {
+	if err := g.Reader.List(ctx, list); err != nil && !meta.IsNoMatchError(err) {
+		return err
+	}
+
+	return nil
+}
+
+// trackReferences records since when clonedImage is unused, clearing it once used again
+func (g *GarbageCollector) trackReferences(ctx context.Context, clonedImage *cachev1alpha1.ClonedImage, referenced map[string]bool, now time.Time) error {
+	// Workloads rewritten to the nearest replica keep the whole image in use
+	inUse := referenced[clonedImage.Spec.Destination]
+	for _, replica := range clonedImage.Status.Replicas {
+		inUse = inUse || referenced[replica.Destination]
+	}
+	switch {
+	case inUse && clonedImage.Status.UnreferencedSince != nil:
+		clonedImage.Status.UnreferencedSince = nil
+	case !inUse && clonedImage.Status.UnreferencedSince == nil:
+		since := metav1.NewTime(now)
+		clonedImage.Status.UnreferencedSince = &since
+	default:
+		return nil
+	}
+
+	return g.Client.Update(ctx, clonedImage)
+}
+
+// collectable returns the cloned images unused for longer than the grace period,
+// sparing the keepLast most recently synced images of each repository and
+// manifests still shared with an image that is kept
+func collectable(clonedImages []cachev1alpha1.ClonedImage, now time.Time, gracePeriod time.Duration, keepLast int) []*cachev1alpha1.ClonedImage {
+	repositories := map[string][]*cachev1alpha1.ClonedImage{}
+	for i := range clonedImages {
+		clonedImage := &clonedImages[i]
+		ref, err := name.ParseReference(clonedImage.Spec.Destination)
+		if err != nil || clonedImage.Status.DestinationDigest == "" {
+			continue
+		}
+		repository := ref.Context().Name()
+		repositories[repository] = append(repositories[repository], clonedImage)
+	}
+
+	var garbage []*cachev1alpha1.ClonedImage
+	for _, images := range repositories {
+		sort.Slice(images, func(i, j int) bool {
+			return lastSync(images[i]).After(lastSync(images[j]))
+		})
+
+		var candidates []*cachev1alpha1.ClonedImage
+		kept := map[string]bool{}
+		for idx, clonedImage := range images {
+			since := clonedImage.Status.UnreferencedSince
+			if idx < keepLast || since == nil || now.Sub(since.Time) < gracePeriod {
+				kept[clonedImage.Status.DestinationDigest] = true
+				continue
+			}
+			candidates = append(candidates, clonedImage)
+		}
+
+		// Deleting a manifest by digest removes every tag pointing at it
+		for _, clonedImage := range candidates {
+			if !kept[clonedImage.Status.DestinationDigest] {
+				garbage = append(garbage, clonedImage)
+			}
+		}
+	}
+
+	return garbage
+}
+
+func lastSync(clonedImage *cachev1alpha1.ClonedImage) time.Time {
+	if clonedImage.Status.LastSyncTime == nil {
+		return time.Time{}
+	}
+
+	return clonedImage.Status.LastSyncTime.Time
+}
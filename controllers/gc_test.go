@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	cachev1alpha1 "github.com/Tiemma/image-clone-controller/api/v1alpha1"
+	"github.com/google/go-containerregistry/pkg/name"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCollectable(t *testing.T) {
+	now := time.Now()
+	at := func(ago time.Duration) *metav1.Time {
+		t := metav1.NewTime(now.Add(-ago))
+		return &t
+	}
+	clonedImage := func(name, destination, digest string, lastSync, unreferenced *metav1.Time) cachev1alpha1.ClonedImage {
+		return cachev1alpha1.ClonedImage{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       cachev1alpha1.ClonedImageSpec{Destination: destination},
+			Status: cachev1alpha1.ClonedImageStatus{
+				DestinationDigest: digest,
+				LastSyncTime:      lastSync,
+				UnreferencedSince: unreferenced,
+			},
+		}
+	}
+
+	clonedImages := []cachev1alpha1.ClonedImage{
+		// Newest image of its repository, kept by keepLast
+		clonedImage("nginx-3", "docker.io/kube456/nginx:3", "sha256:3", at(time.Hour), at(48*time.Hour)),
+		clonedImage("nginx-2", "docker.io/kube456/nginx:2", "sha256:2", at(2*time.Hour), at(48*time.Hour)),
+		// Unused for less than the grace period
+		clonedImage("nginx-1", "docker.io/kube456/nginx:1", "sha256:1", at(3*time.Hour), at(time.Hour)),
+		// Still used
+		clonedImage("redis-2", "docker.io/kube456/redis:2", "sha256:r2", at(time.Hour), nil),
+		clonedImage("redis-1", "docker.io/kube456/redis:1", "sha256:r1", at(2*time.Hour), at(48*time.Hour)),
+		// Shares its manifest with a used tag
+		clonedImage("redis-alias", "docker.io/kube456/redis:alias", "sha256:r2", at(3*time.Hour), at(48*time.Hour)),
+	}
+
+	garbage := collectable(clonedImages, now, 24*time.Hour, 1)
+	var names []string
+	for _, clonedImage := range garbage {
+		names = append(names, clonedImage.Name)
+	}
+	sort.Strings(names)
+
+	expected := []string{"nginx-2", "redis-1"}
+	if len(names) != len(expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+		return
+	}
+	for idx := range expected {
+		if names[idx] != expected[idx] {
+			t.Errorf("expected %v, got %v", expected, names)
+		}
+	}
+}
+
+func TestReferencedImages(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app-1"}}
+	pod.Spec.Containers = []corev1.Container{{Name: "app", Image: "docker.io/kube456/nginx:1"}}
+	// Rollback history of a Deployment now running nginx:3
+	replicaSet := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app-2"}}
+	replicaSet.Spec.Template.Spec.Containers = []corev1.Container{{Name: "app", Image: "docker.io/kube456/nginx:2"}}
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"}}
+	deployment.Spec.Template.Spec.Containers = []corev1.Container{{Name: "app", Image: "docker.io/kube456/nginx:3"}}
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "migrate"}}
+	job.Spec.Template.Spec.Containers = []corev1.Container{{Name: "migrate", Image: "docker.io/kube456/migrate:1"}}
+
+	c := fake.NewFakeClientWithScheme(clientgoscheme.Scheme, pod, replicaSet, deployment, job)
+	g := &GarbageCollector{Client: c, Reader: c}
+	referenced, err := g.referencedImages(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, image := range []string{"docker.io/kube456/nginx:1", "docker.io/kube456/nginx:2", "docker.io/kube456/nginx:3", "docker.io/kube456/migrate:1"} {
+		if ref, _ := name.ParseReference(image); !referenced[ref.Name()] {
+			t.Errorf("expected %s to be referenced", image)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Tiemma/image-clone-controller/pkg/annotations"
+	"github.com/Tiemma/image-clone-controller/pkg/docker"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// fieldManager identifies the controller in the managedFields of the workloads it patches
+const fieldManager = "image-clone-controller"
+
+const (
+	containersField          = "containers"
+	initContainersField      = "initContainers"
+	ephemeralContainersField = "ephemeralContainers"
+)
+
+type patchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// containerImage locates the image of a single container within a pod spec
+type containerImage struct {
+	field string
+	index int
+	name  string
+	image string
+}
+
+func (c containerImage) path() string {
+	return fmt.Sprintf("/spec/template/spec/%s/%d", c.field, c.index)
+}
+
+// imageRewrite records a container image the controller wants to replace
+type imageRewrite struct {
+	field     string
+	container string
+	from      string
+	to        string
+}
+
+func podImages(spec *corev1.PodSpec) []containerImage {
+	var images []containerImage
+	for idx, c := range spec.Containers {
+		images = append(images, containerImage{field: containersField, index: idx, name: c.Name, image: c.Image})
+	}
+	for idx, ic := range spec.InitContainers {
+		images = append(images, containerImage{field: initContainersField, index: idx, name: ic.Name, image: ic.Image})
+	}
+	for idx, ec := range spec.EphemeralContainers {
+		images = append(images, containerImage{field: ephemeralContainersField, index: idx, name: ec.Name, image: ec.Image})
+	}
+
+	return images
+}
+
+// imageRewrites lists the images changed between two revisions of the same pod spec
+func imageRewrites(original, updated *corev1.PodSpec) []imageRewrite {
+	var rewrites []imageRewrite
+	updatedImages := podImages(updated)
+	for idx, c := range podImages(original) {
+		if c.image != updatedImages[idx].image {
+			rewrites = append(rewrites, imageRewrite{
+				field:     c.field,
+				container: c.name,
+				from:      c.image,
+				to:        updatedImages[idx].image,
+			})
+		}
+	}
+
+	return rewrites
+}
+
+// imagePatchOps replaces the images of spec that still match a rewrite and returns the rewrites applied.
+// Each replacement is guarded by test operations so a concurrent change to the
+// container list or its image fails the patch instead of being overwritten
+func imagePatchOps(spec *corev1.PodSpec, rewrites []imageRewrite) ([]patchOp, []imageRewrite) {
+	var ops []patchOp
+	var applied []imageRewrite
+	for _, c := range podImages(spec) {
+		for _, rw := range rewrites {
+			if rw.field != c.field || rw.container != c.name || rw.from != c.image {
+				continue
+			}
+
+			ops = append(ops,
+				patchOp{Op: "test", Path: c.path() + "/name", Value: c.name},
+				patchOp{Op: "test", Path: c.path() + "/image", Value: c.image},
+				patchOp{Op: "replace", Path: c.path() + "/image", Value: rw.to},
+			)
+			applied = append(applied, rw)
+		}
+	}
+
+	return ops, applied
+}
+
+func annotationPath(key string) string {
+	return "/metadata/annotations/" + annotations.EscapeJSONPointer(key)
+}
+
+// setAnnotationOp adds or replaces an annotation, creating the annotations map if obj has none
+func setAnnotationOp(obj client.Object, key, value string) patchOp {
+	if obj.GetAnnotations() == nil {
+		return patchOp{Op: "add", Path: "/metadata/annotations", Value: map[string]string{key: value}}
+	}
+
+	return patchOp{Op: "add", Path: annotationPath(key), Value: value}
+}
+
+// recordOriginalImagesOp merges the upstream images of rewrites into the original images annotation
+func recordOriginalImagesOp(obj client.Object, rewrites []imageRewrite) (patchOp, error) {
+	originals, err := annotations.GetOriginalImages(obj)
+	if err != nil {
+		// A corrupted annotation cannot be merged with, start over from the current rewrites
+		originals = map[string]string{}
+	}
+	for _, rw := range rewrites {
+		originals[annotations.ContainerKey(rw.field, rw.container)] = rw.from
+	}
+
+	value, err := json.Marshal(originals)
+	if err != nil {
+		return patchOp{}, err
+	}
+
+	return setAnnotationOp(obj, annotations.OriginalImages, string(value)), nil
+}
+
+// isRevert reports whether rewrites put back an upstream image recorded on obj, meaning
+// something restored a previous rewrite. Image bumps bring new images and do not count
+func isRevert(obj client.Object, rewrites []imageRewrite) bool {
+	originals, err := annotations.GetOriginalImages(obj)
+	if err != nil {
+		return false
+	}
+	for _, rw := range rewrites {
+		if original, ok := originals[annotations.ContainerKey(rw.field, rw.container)]; ok && original == rw.from {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rewritePatchOps rewrites images and records the upstream images they replace
+func rewritePatchOps(obj client.Object, template *corev1.PodTemplateSpec, rewrites []imageRewrite) ([]patchOp, error) {
+	ops, applied := imagePatchOps(&template.Spec, rewrites)
+	if len(applied) == 0 {
+		return nil, nil
+	}
+
+	op, err := recordOriginalImagesOp(obj, applied)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(ops, op), nil
+}
+
+// revertRewrites lists the cached images of obj that can be restored to their recorded upstream image.
+// Containers whose image was changed to anything but a cached image since are left alone
+func revertRewrites(obj client.Object, template *corev1.PodTemplateSpec) ([]imageRewrite, error) {
+	originals, err := annotations.GetOriginalImages(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	var rewrites []imageRewrite
+	for _, c := range podImages(&template.Spec) {
+		original, ok := originals[annotations.ContainerKey(c.field, c.name)]
+		if !ok || original == c.image || !docker.IsCacheURL(c.image, obj.GetNamespace()) {
+			continue
+		}
+
+		rewrites = append(rewrites, imageRewrite{field: c.field, container: c.name, from: c.image, to: original})
+	}
+
+	return rewrites, nil
+}
+
+// revertPatchOps restores the upstream images of obj and removes the annotations recording the rewrite
+func revertPatchOps(obj client.Object, template *corev1.PodTemplateSpec) ([]patchOp, error) {
+	if !annotations.Has(obj, annotations.OriginalImages) {
+		return nil, nil
+	}
+
+	rewrites, err := revertRewrites(obj, template)
+	if err != nil {
+		return nil, err
+	}
+
+	ops, _ := imagePatchOps(&template.Spec, rewrites)
+	ops = append(ops, patchOp{Op: "remove", Path: annotationPath(annotations.OriginalImages)})
+	if annotations.Has(obj, annotations.FallbackImages) {
+		ops = append(ops, patchOp{Op: "remove", Path: annotationPath(annotations.FallbackImages)})
+	}
+
+	return ops, nil
+}
+
+// fallbackPatchOps switches the cached images of obj to their upstream image,
+// remembering the cached ones so they can be restored once the registry recovers
+func fallbackPatchOps(obj client.Object, template *corev1.PodTemplateSpec) ([]patchOp, error) {
+	if annotations.Has(obj, annotations.FallbackImages) {
+		return nil, nil
+	}
+
+	rewrites, err := revertRewrites(obj, template)
+	if err != nil || len(rewrites) == 0 {
+		return nil, err
+	}
+
+	ops, applied := imagePatchOps(&template.Spec, rewrites)
+	cached := map[string]string{}
+	for _, rw := range applied {
+		cached[annotations.ContainerKey(rw.field, rw.container)] = rw.from
+	}
+
+	value, err := json.Marshal(cached)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(ops, setAnnotationOp(obj, annotations.FallbackImages, string(value))), nil
+}
+
+// restorePatchOps moves the containers switched upstream during an outage back to their cached image
+func restorePatchOps(obj client.Object, template *corev1.PodTemplateSpec) ([]patchOp, error) {
+	if !annotations.Has(obj, annotations.FallbackImages) {
+		return nil, nil
+	}
+
+	cached, err := annotations.GetFallbackImages(obj)
+	if err != nil {
+		return nil, err
+	}
+	originals, err := annotations.GetOriginalImages(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	var rewrites []imageRewrite
+	for _, c := range podImages(&template.Spec) {
+		key := annotations.ContainerKey(c.field, c.name)
+		if image, ok := cached[key]; ok && originals[key] == c.image {
+			rewrites = append(rewrites, imageRewrite{field: c.field, container: c.name, from: c.image, to: image})
+		}
+	}
+
+	ops, _ := imagePatchOps(&template.Spec, rewrites)
+	return append(ops, patchOp{Op: "remove", Path: annotationPath(annotations.FallbackImages)}), nil
+}
+
+// lastSpecWriter returns the field manager, other than the controller,
+// that most recently modified the spec of obj
+func lastSpecWriter(obj client.Object) string {
+	writer := "unknown"
+	var latest time.Time
+	for _, entry := range obj.GetManagedFields() {
+		if entry.Manager == fieldManager || entry.FieldsV1 == nil || !strings.Contains(string(entry.FieldsV1.Raw), `"f:spec"`) {
+			continue
+		}
+		if entry.Time != nil && entry.Time.Time.After(latest) {
+			writer, latest = entry.Manager, entry.Time.Time
+		}
+	}
+
+	return writer
+}
+
+// testFailedMessage starts the error of a failed JSON patch test operation
+const testFailedMessage = "testing value"
+
+// isPatchConflict matches conflicts as well as failed test operations. The API server reports the
+// latter as invalid requests without field causes, the patch error being dropped or kept as an
+// unexpected response cause. Patches breaking the schema list their invalid fields and are not retried
+func isPatchConflict(err error) bool {
+	if apierrors.IsConflict(err) {
+		return true
+	}
+
+	status, ok := err.(apierrors.APIStatus)
+	if !ok || !apierrors.IsInvalid(err) {
+		return false
+	}
+	details := status.Status().Details
+	if details == nil || len(details.Causes) == 0 {
+		return true
+	}
+	for _, cause := range details.Causes {
+		if cause.Type != metav1.CauseTypeUnexpectedServerResponse || !strings.HasPrefix(cause.Message, testFailedMessage) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// patchWorkload applies the JSON patch returned by buildOps to obj. On conflicts
+// obj is re-read and the patch rebuilt from its latest state before retrying
+func (r *WorkloadReconciler) patchWorkload(ctx context.Context, obj client.Object, buildOps func() ([]patchOp, error)) error {
+	reread := false
+	return retry.OnError(retry.DefaultRetry, isPatchConflict, func() error {
+		if reread {
+			if err := r.Client.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+				return err
+			}
+		}
+		reread = true
+
+		ops, err := buildOps()
+		if err != nil || len(ops) == 0 {
+			return err
+		}
+
+		data, err := json.Marshal(ops)
+		if err != nil {
+			return err
+		}
+
+		return r.Client.Patch(ctx, obj, client.RawPatch(types.JSONPatchType, data), client.FieldOwner(fieldManager))
+	})
+}
@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/Tiemma/image-clone-controller/pkg/annotations"
+	"github.com/Tiemma/image-clone-controller/pkg/docker"
+	"github.com/Tiemma/image-clone-controller/pkg/routing"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestMain(m *testing.M) {
+	docker.SetRoutes(routing.Default("docker.io/kube456"))
+
+	// Run tests
+	code := m.Run()
+
+	os.Exit(code)
+}
+
+func TestImagePatchOps(t *testing.T) {
+	original := &corev1.PodSpec{
+		Containers:     []corev1.Container{{Name: "app", Image: "nginx:1.21"}, {Name: "sidecar", Image: "busybox"}},
+		InitContainers: []corev1.Container{{Name: "init", Image: "alpine"}},
+	}
+	updated := original.DeepCopy()
+	updated.Containers[0].Image = "docker.io/kube456/nginx:1.21"
+	updated.InitContainers[0].Image = "docker.io/kube456/alpine:latest"
+
+	rewrites := imageRewrites(original, updated)
+	if len(rewrites) != 2 {
+		t.Errorf("expected 2 rewrites, got %d", len(rewrites))
+	}
+
+	// A container inserted before the rewritten one must not shift the patch onto the wrong container
+	current := original.DeepCopy()
+	current.Containers = append([]corev1.Container{{Name: "new", Image: "redis"}}, current.Containers...)
+
+	ops, _ := imagePatchOps(current, rewrites)
+	data, err := json.Marshal(ops)
+	if err != nil {
+		t.Errorf("error occured marshalling patch: %s", err)
+	}
+
+	expected := `[{"op":"test","path":"/spec/template/spec/containers/1/name","value":"app"},` +
+		`{"op":"test","path":"/spec/template/spec/containers/1/image","value":"nginx:1.21"},` +
+		`{"op":"replace","path":"/spec/template/spec/containers/1/image","value":"docker.io/kube456/nginx:1.21"},` +
+		`{"op":"test","path":"/spec/template/spec/initContainers/0/name","value":"init"},` +
+		`{"op":"test","path":"/spec/template/spec/initContainers/0/image","value":"alpine"},` +
+		`{"op":"replace","path":"/spec/template/spec/initContainers/0/image","value":"docker.io/kube456/alpine:latest"}]`
+	if string(data) != expected {
+		t.Errorf("expected %s, got %s", expected, data)
+	}
+
+	// Images changed by someone else since are left alone
+	current.Containers[1].Image = "nginx:1.22"
+	if ops, applied := imagePatchOps(current, rewrites); len(ops) != 3 || len(applied) != 1 {
+		t.Errorf("expected only the init container to be patched, got %v", ops)
+	}
+}
+
+func TestRevertPatchOps(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			annotations.OriginalImages: `{"containers/app":"nginx:1.21"}`,
+		}},
+	}
+	deployment.Spec.Template.Spec.Containers = []corev1.Container{
+		{Name: "app", Image: "docker.io/kube456/nginx:1.21"},
+		{Name: "sidecar", Image: "docker.io/kube456/busybox:latest"},
+	}
+
+	ops, err := revertPatchOps(deployment, &deployment.Spec.Template)
+	if err != nil {
+		t.Errorf("error occured building revert patch: %s", err)
+	}
+
+	data, _ := json.Marshal(ops)
+	expected := `[{"op":"test","path":"/spec/template/spec/containers/0/name","value":"app"},` +
+		`{"op":"test","path":"/spec/template/spec/containers/0/image","value":"docker.io/kube456/nginx:1.21"},` +
+		`{"op":"replace","path":"/spec/template/spec/containers/0/image","value":"nginx:1.21"},` +
+		`{"op":"remove","path":"/metadata/annotations/image-clone-controller.bakman.build~1original-images"}]`
+	if string(data) != expected {
+		t.Errorf("expected %s, got %s", expected, data)
+	}
+}
+
+func TestRecordOriginalImagesOp(t *testing.T) {
+	rewrites := []imageRewrite{{field: containersField, container: "app", from: "nginx:1.21", to: "docker.io/kube456/nginx:1.21"}}
+
+	op, err := recordOriginalImagesOp(&appsv1.Deployment{}, rewrites)
+	if err != nil {
+		t.Errorf("error occured recording original images: %s", err)
+	}
+	data, _ := json.Marshal(op)
+	expected := `{"op":"add","path":"/metadata/annotations","value":{"image-clone-controller.bakman.build/original-images":"{\"containers/app\":\"nginx:1.21\"}"}}`
+	if string(data) != expected {
+		t.Errorf("expected %s, got %s", expected, data)
+	}
+
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		annotations.OriginalImages: `{"initContainers/setup":"busybox"}`,
+	}}}
+	op, _ = recordOriginalImagesOp(deployment, rewrites)
+	data, _ = json.Marshal(op)
+	expected = `{"op":"add","path":"/metadata/annotations/image-clone-controller.bakman.build~1original-images",` +
+		`"value":"{\"containers/app\":\"nginx:1.21\",\"initContainers/setup\":\"busybox\"}"}`
+	if string(data) != expected {
+		t.Errorf("expected %s, got %s", expected, data)
+	}
+}
+
+func TestIsPatchConflict(t *testing.T) {
+	tests := map[error]bool{
+		apierrors.NewConflict(schema.GroupResource{Resource: "deployments"}, "app", errors.New("modified")):                                                                         true,
+		apierrors.NewGenericServerResponse(http.StatusUnprocessableEntity, "", schema.GroupResource{}, "", "testing value /spec/template/spec/containers/0/image failed", 0, false): true,
+		apierrors.NewGenericServerResponse(http.StatusUnprocessableEntity, "", schema.GroupResource{}, "", "testing value /spec/template/spec/containers/0/image failed", 0, true):  true,
+		apierrors.NewInvalid(schema.GroupKind{Kind: "Deployment"}, "app", field.ErrorList{field.Invalid(field.NewPath("spec", "replicas"), -1, "must be positive")}):                false,
+	}
+
+	for err, expected := range tests {
+		if res := isPatchConflict(err); res != expected {
+			t.Errorf("expected isPatchConflict to be %v for %s, got %v", expected, err, res)
+		}
+	}
+}
+
+func TestIsRevert(t *testing.T) {
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		annotations.OriginalImages: `{"containers/app":"nginx:1.21"}`,
+	}}}
+
+	reverted := []imageRewrite{{field: containersField, container: "app", from: "nginx:1.21", to: "docker.io/kube456/nginx:1.21"}}
+	if !isRevert(deployment, reverted) {
+		t.Errorf("expected restoring the recorded upstream image to be a revert")
+	}
+	bumped := []imageRewrite{{field: containersField, container: "app", from: "nginx:1.22", to: "docker.io/kube456/nginx:1.22"}}
+	if isRevert(deployment, bumped) {
+		t.Errorf("expected an image bump not to be a revert")
+	}
+	if isRevert(&appsv1.Deployment{}, reverted) {
+		t.Errorf("expected first rewrites not to be reverts")
+	}
+}
@@ -0,0 +1,268 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Tiemma/image-clone-controller/pkg/annotations"
+	"github.com/Tiemma/image-clone-controller/pkg/docker"
+	"github.com/Tiemma/image-clone-controller/pkg/env"
+	"github.com/Tiemma/image-clone-controller/pkg/metrics"
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	reasonImagePullFailed = "ImagePullFailed"
+
+	replicaSetKind = "ReplicaSet"
+
+	// pendingPodsSelector limits the pods watched to the ones still starting, failed pulls keeping pods pending
+	pendingPodsSelector = "status.phase=Pending"
+)
+
+// Likely causes of a failed pull reported by the pull failures metric
+const (
+	pullCauseMissingImage  = "missing_image"
+	pullCauseUnauthorized  = "unauthorized"
+	pullCauseMissingSecret = "missing_pull_secret"
+	pullCauseUnreachable   = "registry_unreachable"
+	pullCauseUnknown       = "unknown"
+)
+
+var (
+	pullFailureReasons = map[string]bool{"ErrImagePull": true, "ImagePullBackOff": true}
+
+	// Fragments of the kubelet pull error messages pointing at each cause
+	pullCauseMessages = []struct {
+		cause     string
+		fragments []string
+	}{
+		{cause: pullCauseUnauthorized, fragments: []string{"unauthorized", "authentication required", "denied", "401", "403"}},
+		{cause: pullCauseMissingImage, fragments: []string{"not found", "manifest unknown", "404"}},
+		{cause: pullCauseUnreachable, fragments: []string{"dial tcp", "i/o timeout", "no such host", "connection refused", "tls handshake timeout", "no route to host"}},
+	}
+)
+
+// PodReconciler watches pods failing to pull images the controller rewrote,
+// reporting the likely cause on their workload and optionally reverting it
+type PodReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+	// RevertOnFailure requests a revert of workloads whose pods cannot pull their cached images
+	RevertOnFailure bool
+
+	// pods holds the pending pods only, the manager cache would hold every pod of the cluster
+	pods toolscache.SharedIndexInformer
+}
+
+// pullFailure is a container of a pod failing to pull its cached image
+type pullFailure struct {
+	field     string
+	container string
+	image     string
+	message   string
+}
+
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=apps,resources=replicasets,verbs=get;list;watch
+
+func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("pod", req.NamespacedName)
+
+	obj, exists, err := r.pods.GetStore().GetByKey(req.NamespacedName.String())
+	if err != nil || !exists {
+		// Pods leave the store once they are no longer pending
+		return ctrl.Result{}, err
+	}
+	pod := obj.(*corev1.Pod)
+
+	failures := pullFailures(pod)
+	if len(failures) == 0 || env.IsSkippableNamespace("Pod", pod.Namespace) {
+		return ctrl.Result{}, nil
+	}
+
+	workload, kind, err := r.workloadOf(ctx, pod)
+	if err != nil || workload == nil {
+		return ctrl.Result{}, err
+	}
+
+	originals, err := annotations.GetOriginalImages(workload)
+	if err != nil {
+		log.Error(err, "error occurred reading original images")
+	}
+
+	var rewritten []string
+	for _, failure := range failures {
+		upstream, ok := originals[annotations.ContainerKey(failure.field, failure.container)]
+		if !ok {
+			// Only images the controller rewrote are its concern
+			continue
+		}
+
+		cause := diagnosePullFailure(failure.message, len(pod.Spec.ImagePullSecrets) > 0, cachedImageExists(failure.image))
+		metrics.UpdatePullFailuresMetric(kind, cause)
+		r.Recorder.Event(workload, corev1.EventTypeWarning, reasonImagePullFailed, fmt.Sprintf(
+			"Pod %s cannot pull cached image %s of container %s (upstream %s), likely cause: %s",
+			pod.Name, failure.image, failure.container, upstream, cause))
+		log.Info("Pod cannot pull cached image", "image", failure.image, "cause", cause)
+		rewritten = append(rewritten, failure.image)
+	}
+
+	if len(rewritten) == 0 || !r.RevertOnFailure || annotations.IsRevertRequested(workload) {
+		return ctrl.Result{}, nil
+	}
+
+	patch := client.MergeFrom(workload.DeepCopyObject().(client.Object))
+	workloadAnnotations := workload.GetAnnotations()
+	if workloadAnnotations == nil {
+		workloadAnnotations = map[string]string{}
+	}
+	workloadAnnotations[annotations.Revert] = "true"
+	workload.SetAnnotations(workloadAnnotations)
+	if err := r.Client.Patch(ctx, workload, patch, client.FieldOwner(fieldManager)); err != nil {
+		return ctrl.Result{}, err
+	}
+	log.Info("Requested a revert of the workload to its upstream images", "kind", kind, "name", workload.GetName())
+
+	return ctrl.Result{}, nil
+}
+
+// workloadOf returns the Deployment or DaemonSet owning pod, or nil when it has none
+func (r *PodReconciler) workloadOf(ctx context.Context, pod *corev1.Pod) (client.Object, string, error) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return nil, "", nil
+	}
+
+	if owner.Kind == replicaSetKind {
+		replicaSet := &appsv1.ReplicaSet{}
+		if err := r.Client.Get(ctx, types.NamespacedName{Namespace: pod.Namespace, Name: owner.Name}, replicaSet); err != nil {
+			return nil, "", client.IgnoreNotFound(err)
+		}
+		if owner = metav1.GetControllerOf(replicaSet); owner == nil {
+			return nil, "", nil
+		}
+	}
+
+	workload, ok := newWorkload(owner.Kind)
+	if !ok {
+		return nil, "", nil
+	}
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: pod.Namespace, Name: owner.Name}, workload); err != nil {
+		return nil, "", client.IgnoreNotFound(err)
+	}
+
+	return workload, owner.Kind, nil
+}
+
+// pullFailures lists the containers of pod failing to pull an image of the cache
+func pullFailures(pod *corev1.Pod) []pullFailure {
+	var failures []pullFailure
+	collect := func(field string, statuses []corev1.ContainerStatus) {
+		for _, status := range statuses {
+			waiting := status.State.Waiting
+			if waiting == nil || !pullFailureReasons[waiting.Reason] || !docker.IsCacheURL(status.Image, pod.Namespace) {
+				continue
+			}
+			failures = append(failures, pullFailure{field: field, container: status.Name, image: status.Image, message: waiting.Message})
+		}
+	}
+
+	collect(containersField, pod.Status.ContainerStatuses)
+	collect(initContainersField, pod.Status.InitContainerStatuses)
+	collect(ephemeralContainersField, pod.Status.EphemeralContainerStatuses)
+
+	return failures
+}
+
+// cachedImageExists reports whether the controller itself can still find image,
+// assuming it does when the registry cannot be reached
+func cachedImageExists(image string) bool {
+	_, found, err := docker.DestinationDigest(image)
+	return found || err != nil
+}
+
+// diagnosePullFailure guesses why a node could not pull a cached image from the kubelet message,
+// whether the pod has pull secrets and whether the image can be found by the controller
+func diagnosePullFailure(message string, hasPullSecrets, imageExists bool) string {
+	if !imageExists {
+		return pullCauseMissingImage
+	}
+
+	message = strings.ToLower(message)
+	for _, candidate := range pullCauseMessages {
+		for _, fragment := range candidate.fragments {
+			if !strings.Contains(message, fragment) {
+				continue
+			}
+			if candidate.cause == pullCauseUnauthorized && !hasPullSecrets {
+				return pullCauseMissingSecret
+			}
+			return candidate.cause
+		}
+	}
+
+	return pullCauseUnknown
+}
+
+// hasPullFailure passes pods with a container failing to pull an image of the cache
+func hasPullFailure(obj client.Object) bool {
+	pod, ok := obj.(*corev1.Pod)
+	return ok && len(pullFailures(pod)) > 0
+}
+
+func (r *PodReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
+	pendingPods := toolscache.NewFilteredListWatchFromClient(clientset.CoreV1().RESTClient(), "pods", metav1.NamespaceAll,
+		func(options *metav1.ListOptions) {
+			options.FieldSelector = pendingPodsSelector
+		})
+	r.pods = toolscache.NewSharedIndexInformer(pendingPods, &corev1.Pod{}, 0, toolscache.Indexers{})
+	if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		r.pods.Run(ctx.Done())
+		return nil
+	})); err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("pod").
+		Watches(&source.Informer{Informer: r.pods}, &handler.EnqueueRequestForObject{},
+			builder.WithPredicates(predicate.NewPredicateFuncs(hasPullFailure))).
+		Complete(r)
+}
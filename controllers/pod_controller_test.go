@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestDiagnosePullFailure(t *testing.T) {
+	tests := []struct {
+		message        string
+		hasPullSecrets bool
+		imageExists    bool
+		expected       string
+	}{
+		{message: "manifest unknown", imageExists: false, expected: pullCauseMissingImage},
+		{message: "pull access denied, authorization failed", hasPullSecrets: true, imageExists: true, expected: pullCauseUnauthorized},
+		{message: "401 Unauthorized", imageExists: true, expected: pullCauseMissingSecret},
+		{message: "dial tcp 10.0.0.1:443: i/o timeout", imageExists: true, expected: pullCauseUnreachable},
+		{message: "Back-off pulling image", imageExists: true, expected: pullCauseUnknown},
+	}
+
+	for _, test := range tests {
+		if cause := diagnosePullFailure(test.message, test.hasPullSecrets, test.imageExists); cause != test.expected {
+			t.Errorf("expected %s for %q, got %s", test.expected, test.message, cause)
+		}
+	}
+}
+
+func TestPullFailures(t *testing.T) {
+	waiting := func(reason string) corev1.ContainerState {
+		return corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason, Message: "denied"}}
+	}
+	pod := &corev1.Pod{Status: corev1.PodStatus{
+		ContainerStatuses: []corev1.ContainerStatus{
+			{Name: "app", Image: "docker.io/kube456/app:1", State: waiting("ImagePullBackOff")},
+			{Name: "sidecar", Image: "docker.io/kube456/sidecar:1", State: waiting("CrashLoopBackOff")},
+		},
+		InitContainerStatuses: []corev1.ContainerStatus{
+			{Name: "setup", Image: "docker.io/kube456/setup:1", State: waiting("ErrImagePull")},
+		},
+	}}
+
+	failures := pullFailures(pod)
+	if len(failures) != 2 {
+		t.Errorf("expected 2 pull failures, got %v", failures)
+		return
+	}
+	if failures[0].field != containersField || failures[0].container != "app" {
+		t.Errorf("expected containers/app, got %s/%s", failures[0].field, failures[0].container)
+	}
+	if failures[1].field != initContainersField || failures[1].container != "setup" {
+		t.Errorf("expected initContainers/setup, got %s/%s", failures[1].field, failures[1].container)
+	}
+	if !hasPullFailure(pod) {
+		t.Errorf("expected pod to pass the pull failure predicate")
+	}
+}
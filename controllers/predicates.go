@@ -0,0 +1,80 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// podTemplateOf returns the pod template of the workload kinds the controller manages
+func podTemplateOf(obj client.Object) *corev1.PodTemplateSpec {
+	switch workload := obj.(type) {
+	case *appsv1.Deployment:
+		return &workload.Spec.Template
+	case *appsv1.DaemonSet:
+		return &workload.Spec.Template
+	default:
+		return nil
+	}
+}
+
+// newWorkload returns an empty object of a workload kind handled by the controller
+func newWorkload(kind string) (client.Object, bool) {
+	switch kind {
+	case deploymentKind:
+		return &appsv1.Deployment{}, true
+	case daemonSetKind:
+		return &appsv1.DaemonSet{}, true
+	default:
+		return nil, false
+	}
+}
+
+// imagesChangedPredicate passes updates that modify any image of the pod template
+type imagesChangedPredicate struct {
+	predicate.Funcs
+}
+
+func (imagesChangedPredicate) Update(e event.UpdateEvent) bool {
+	oldTemplate, newTemplate := podTemplateOf(e.ObjectOld), podTemplateOf(e.ObjectNew)
+	if oldTemplate == nil || newTemplate == nil {
+		return false
+	}
+
+	oldImages, newImages := podImages(&oldTemplate.Spec), podImages(&newTemplate.Spec)
+	if len(oldImages) != len(newImages) {
+		return true
+	}
+	for idx := range oldImages {
+		if oldImages[idx] != newImages[idx] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// workloadPredicates filters out status and label only updates, which make up
+// most workload events and can never require an image to be cloned.
+// Annotation changes are kept as they can request a revert
+func workloadPredicates() predicate.Predicate {
+	return predicate.Or(predicate.GenerationChangedPredicate{}, imagesChangedPredicate{}, predicate.AnnotationChangedPredicate{})
+}
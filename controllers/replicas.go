@@ -0,0 +1,125 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	cachev1alpha1 "github.com/Tiemma/image-clone-controller/api/v1alpha1"
+	"github.com/Tiemma/image-clone-controller/pkg/backoff"
+	"github.com/Tiemma/image-clone-controller/pkg/docker"
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// replicaAttempt tracks the retries of a lagging replica
+type replicaAttempt struct {
+	count int
+	next  time.Time
+}
+
+// ReplicaSyncer periodically copies cached images to the replicas that failed or missed
+// their last write. Every replica backs off on its own so one unreachable registry
+// does not hold back the others
+type ReplicaSyncer struct {
+	client.Client
+	Log      logr.Logger
+	Interval time.Duration
+	Policy   backoff.Policy
+
+	attempts map[string]replicaAttempt
+}
+
+// Start syncs lagging replicas until ctx is done, implementing manager.Runnable
+func (r *ReplicaSyncer) Start(ctx context.Context) error {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		r.syncAll(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *ReplicaSyncer) syncAll(ctx context.Context, now time.Time) {
+	if r.attempts == nil {
+		r.attempts = map[string]replicaAttempt{}
+	}
+
+	list := &cachev1alpha1.ClonedImageList{}
+	if err := r.List(ctx, list); err != nil {
+		r.Log.Error(err, "error occurred listing cloned images")
+		return
+	}
+
+	for idx := range list.Items {
+		clonedImage := &list.Items[idx]
+		lagging := laggingReplicas(clonedImage)
+		for _, replica := range lagging {
+			attempt := r.attempts[replica]
+			if now.Before(attempt.next) {
+				continue
+			}
+
+			log := r.Log.WithValues("image", clonedImage.Spec.Destination, "replica", replica)
+			if err := docker.Replicate(ctx, clonedImage.Spec.Destination, replica); err != nil {
+				attempt.count++
+				attempt.next = now.Add(r.Policy.Delay(attempt.count))
+				r.attempts[replica] = attempt
+				log.Error(err, "error occurred syncing replica", "retryIn", attempt.next.Sub(now).String())
+				continue
+			}
+
+			log.Info("Synced lagging replica")
+			delete(r.attempts, replica)
+		}
+	}
+}
+
+// laggingReplicas returns the replicas recorded for clonedImage that were never written, failed their
+// last write or hold another image than the primary destination. Replicas are the ones recorded when the
+// image was cloned rather than the ones of its route, which does not know the folders of tenants
+func laggingReplicas(clonedImage *cachev1alpha1.ClonedImage) []string {
+	var lagging []string
+	for _, status := range clonedImage.Status.Replicas {
+		if status.Error != "" || status.DestinationDigest != clonedImage.Status.DestinationDigest {
+			lagging = append(lagging, status.Destination)
+		}
+	}
+
+	return lagging
+}
+
+// replicaDestinations returns every replica recorded for clonedImage, none when it is unknown
+func replicaDestinations(clonedImage *cachev1alpha1.ClonedImage) []string {
+	if clonedImage == nil {
+		return nil
+	}
+
+	destinations := make([]string, 0, len(clonedImage.Status.Replicas))
+	for _, status := range clonedImage.Status.Replicas {
+		destinations = append(destinations, status.Destination)
+	}
+
+	return destinations
+}
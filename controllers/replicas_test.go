@@ -0,0 +1,32 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	cachev1alpha1 "github.com/Tiemma/image-clone-controller/api/v1alpha1"
+)
+
+func TestLaggingReplicas(t *testing.T) {
+	clonedImage := &cachev1alpha1.ClonedImage{
+		Status: cachev1alpha1.ClonedImageStatus{
+			DestinationDigest: "sha256:2",
+			Replicas: []cachev1alpha1.ReplicaStatus{
+				{Destination: "eu.example.com/kube456/team-a/nginx:1.21", DestinationDigest: "sha256:2"},
+				{Destination: "us.example.com/kube456/team-a/nginx:1.21", DestinationDigest: "sha256:1"},
+				{Destination: "ap.example.com/kube456/team-a/nginx:1.21", DestinationDigest: "sha256:2", Error: "unreachable"},
+				// Recorded when cloned but never written
+				{Destination: "sa.example.com/kube456/team-a/nginx:1.21"},
+			},
+		},
+	}
+
+	expected := []string{
+		"us.example.com/kube456/team-a/nginx:1.21",
+		"ap.example.com/kube456/team-a/nginx:1.21",
+		"sa.example.com/kube456/team-a/nginx:1.21",
+	}
+	if res := laggingReplicas(clonedImage); !reflect.DeepEqual(res, expected) {
+		t.Errorf("expected %v to be lagging, got %v", expected, res)
+	}
+}
@@ -0,0 +1,167 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	cachev1alpha1 "github.com/Tiemma/image-clone-controller/api/v1alpha1"
+	"github.com/Tiemma/image-clone-controller/pkg/docker"
+	"github.com/Tiemma/image-clone-controller/pkg/metrics"
+	"github.com/Tiemma/image-clone-controller/pkg/resync"
+	"github.com/go-logr/logr"
+	"github.com/google/go-containerregistry/pkg/name"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Event reasons emitted on the workloads using a drifted image
+const (
+	reasonUpstreamDrift = "UpstreamDrift"
+	reasonResynced      = "Resynced"
+)
+
+// Resyncer periodically compares the upstream digest of every cloned image with
+// the digest of its cached copy, re-cloning or reporting the ones that moved
+type Resyncer struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+	Schedule *resync.Schedule
+}
+
+// Start resyncs due images until ctx is done, implementing manager.Runnable
+func (r *Resyncer) Start(ctx context.Context) error {
+	ticker := time.NewTicker(r.Schedule.Interval())
+	defer ticker.Stop()
+
+	for {
+		r.resyncAll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Resyncer) resyncAll(ctx context.Context) {
+	clonedImages := &cachev1alpha1.ClonedImageList{}
+	if err := r.Client.List(ctx, clonedImages); err != nil {
+		r.Log.Error(err, "error occurred listing cloned images")
+		return
+	}
+
+	for i := range clonedImages.Items {
+		clonedImage := &clonedImages.Items[i]
+		if rule, ok := r.Schedule.Due(clonedImage.Spec.Source); ok {
+			r.resync(ctx, clonedImage, rule)
+		}
+	}
+}
+
+func (r *Resyncer) resync(ctx context.Context, clonedImage *cachev1alpha1.ClonedImage, rule resync.Rule) {
+	source, destination := clonedImage.Spec.Source, clonedImage.Spec.Destination
+	log := r.Log.WithValues("source", source, "destination", destination)
+
+	upstream, err := docker.UpstreamDigest(source)
+	if err != nil {
+		log.Error(err, "error occurred resolving upstream digest")
+		return
+	}
+	// Like upstream, the source digest is the index of multi platform images and not one of their platforms
+	cached := clonedImage.Status.SourceDigest
+	if upstream == cached {
+		return
+	}
+
+	metrics.UpdateUpstreamDriftMetric(registryOf(source), string(rule.Policy))
+	log.Info("Upstream image moved", "cached", cached, "upstream", upstream, "policy", rule.Policy)
+
+	if rule.Policy == resync.PolicyReport {
+		r.eventWorkloads(ctx, clonedImage, corev1.EventTypeWarning, reasonUpstreamDrift, fmt.Sprintf(
+			"Upstream image %s moved from %s to %s, cached copy %s is stale",
+			source, cached, upstream, destination))
+		return
+	}
+
+	if err := docker.Recopy(ctx, source, "", destination, workloadNamespaces(clonedImage), replicaDestinations(clonedImage)); err != nil {
+		log.Error(err, "error occurred re-cloning drifted image")
+		r.eventWorkloads(ctx, clonedImage, corev1.EventTypeWarning, reasonUpstreamDrift, fmt.Sprintf(
+			"Upstream image %s moved to %s but re-cloning it failed: %s", source, upstream, err))
+		return
+	}
+	r.eventWorkloads(ctx, clonedImage, corev1.EventTypeNormal, reasonResynced, fmt.Sprintf(
+		"Upstream image %s moved to %s, re-cloned it to %s", source, upstream, destination))
+}
+
+// workloadNamespaces returns the namespaces of the workloads using clonedImage along with namespaces,
+// whose routes decide the signature policy the source is checked against
+func workloadNamespaces(clonedImage *cachev1alpha1.ClonedImage, namespaces ...string) []string {
+	var res []string
+	seen := map[string]bool{}
+	add := func(namespace string) {
+		if !seen[namespace] {
+			seen[namespace] = true
+			res = append(res, namespace)
+		}
+	}
+	if clonedImage != nil {
+		for _, workload := range clonedImage.Status.Workloads {
+			add(workload.Namespace)
+		}
+	}
+	for _, namespace := range namespaces {
+		add(namespace)
+	}
+
+	return res
+}
+
+// eventWorkloads emits an Event on every workload still using clonedImage
+func (r *Resyncer) eventWorkloads(ctx context.Context, clonedImage *cachev1alpha1.ClonedImage, eventType, reason, message string) {
+	for _, ref := range clonedImage.Status.Workloads {
+		obj, ok := newWorkload(ref.Kind)
+		if !ok {
+			continue
+		}
+
+		if err := r.Client.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, obj); err != nil {
+			// The workload may be gone while the cloned image is still around
+			if client.IgnoreNotFound(err) != nil {
+				r.Log.Error(err, "error occurred getting workload", "kind", ref.Kind, "namespace", ref.Namespace, "name", ref.Name)
+			}
+			continue
+		}
+		r.Recorder.Event(obj, eventType, reason, message)
+	}
+}
+
+// registryOf returns the registry hosting image, or an empty string if it cannot be parsed
+func registryOf(image string) string {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return ""
+	}
+
+	return ref.Context().RegistryStr()
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	cachev1alpha1 "github.com/Tiemma/image-clone-controller/api/v1alpha1"
+	"github.com/Tiemma/image-clone-controller/pkg/resync"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestResyncMultiPlatformImage(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	source := strings.TrimPrefix(server.URL, "http://") + "/library/nginx:1.21"
+
+	index, _ := random.Index(64, 1, 2)
+	ref, _ := name.ParseReference(source)
+	if err := remote.WriteIndex(ref, index); err != nil {
+		t.Fatal(err)
+	}
+	indexDigest, _ := index.Digest()
+	manifest, _ := index.IndexManifest()
+
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"}}
+	clonedImage := &cachev1alpha1.ClonedImage{
+		Spec: cachev1alpha1.ClonedImageSpec{Source: source, Destination: "docker.io/kube456/nginx:1.21"},
+		Status: cachev1alpha1.ClonedImageStatus{
+			SourceDigest: indexDigest.String(),
+			// The digest of one of the platforms, which a HEAD of the source never returns
+			DestinationDigest: manifest.Manifests[0].Digest.String(),
+			Workloads:         []cachev1alpha1.WorkloadReference{{Kind: "Deployment", Namespace: "default", Name: "app"}},
+		},
+	}
+	recorder := record.NewFakeRecorder(10)
+	r := &Resyncer{
+		Client:   fake.NewFakeClientWithScheme(clientgoscheme.Scheme, deployment),
+		Log:      ctrl.Log,
+		Recorder: recorder,
+	}
+	rule := resync.Rule{Policy: resync.PolicyReport}
+
+	r.resync(context.Background(), clonedImage, rule)
+	if len(recorder.Events) != 0 {
+		t.Errorf("expected an unchanged multi platform image not to drift, got %s", <-recorder.Events)
+	}
+
+	moved, _ := random.Index(64, 1, 2)
+	if err := remote.WriteIndex(ref, moved); err != nil {
+		t.Fatal(err)
+	}
+	r.resync(context.Background(), clonedImage, rule)
+	if len(recorder.Events) != 1 {
+		t.Errorf("expected a moved multi platform image to drift")
+	}
+}
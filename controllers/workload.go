@@ -0,0 +1,285 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Tiemma/image-clone-controller/pkg/annotations"
+	"github.com/Tiemma/image-clone-controller/pkg/backoff"
+	"github.com/Tiemma/image-clone-controller/pkg/docker"
+	"github.com/Tiemma/image-clone-controller/pkg/env"
+	"github.com/Tiemma/image-clone-controller/pkg/errors"
+	"github.com/Tiemma/image-clone-controller/pkg/flapping"
+	"github.com/Tiemma/image-clone-controller/pkg/gitops"
+	"github.com/Tiemma/image-clone-controller/pkg/health"
+	"github.com/Tiemma/image-clone-controller/pkg/metrics"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// WorkloadReconciler holds the logic shared by the reconcilers of
+// every workload kind whose pod template images get cloned
+type WorkloadReconciler struct {
+	client.Client
+	Log               logr.Logger
+	Scheme            *runtime.Scheme
+	KubeServerVersion string
+	Backoff           *backoff.Tracker
+	Recorder          record.EventRecorder
+	// ManagedPolicy applies to workloads kept in sync by GitOps tools or other operators
+	ManagedPolicy   gitops.Policy
+	IgnoredManagers []string
+	Flapping        *flapping.Detector
+	// Health tracks the destination registry, leaving it nil disables the upstream fallback
+	Health *health.Checker
+}
+
+// Event reasons emitted on workloads
+const (
+	reasonCloneStarted      = "CloneStarted"
+	reasonCloneSucceeded    = "CloneSucceeded"
+	reasonCloneFailed       = "CloneFailed"
+	reasonSkipped           = "Skipped"
+	reasonRewritten         = "Rewritten"
+	reasonReverted          = "Reverted"
+	reasonImagesPrecached   = "ImagesPrecached"
+	reasonRewriteFlapping   = "RewriteFlapping"
+	reasonRegistryFallback  = "RegistryFallback"
+	reasonRegistryRecovered = "RegistryRecovered"
+	reasonImageScanFailed   = "ImageScanFailed"
+	reasonSignatureFailed   = "ImageSignatureFailed"
+)
+
+// maxListedFindings is the number of findings listed in ImageScanFailed events
+const maxListedFindings = 10
+
+// Reasons reported by the skipped reconciles metric
+const (
+	skipReasonNamespace         = "namespace"
+	skipReasonParked            = "parked"
+	skipReasonCached            = "cached"
+	skipReasonManaged           = "managed"
+	skipReasonFlapping          = "flapping"
+	skipReasonReverted          = "reverted"
+	skipReasonRegistryUnhealthy = "registry_unhealthy"
+)
+
+func workloadKey(kind, namespace, name string) string {
+	return fmt.Sprintf("%s/%s/%s", kind, namespace, name)
+}
+
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;create;update
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get
+// +kubebuilder:rbac:groups=cache.bakman.build,resources=clonedimages,verbs=get;list;watch;create;update;patch;delete
+
+func (r *WorkloadReconciler) reconcileWorkload(ctx context.Context, log logr.Logger, kind string, obj client.Object, template *corev1.PodTemplateSpec) (ctrl.Result, error) {
+	if env.IsSkippableNamespace(kind, obj.GetNamespace()) {
+		// Skipped namespaces are reconciled all the time, an Event each time would only be noise
+		metrics.UpdateSkippedReconcilesMetric(kind, skipReasonNamespace)
+		return ctrl.Result{}, nil
+	}
+
+	if env.IsRevertAllEnabled() || annotations.IsRevertRequested(obj) {
+		return r.revertWorkload(ctx, log, kind, obj, template)
+	}
+
+	if res, handled := r.registryFallback(ctx, log, kind, obj, template); handled {
+		return res, nil
+	}
+
+	key := workloadKey(kind, obj.GetNamespace(), obj.GetName())
+	if r.Backoff.IsParked(key, obj.GetGeneration()) {
+		log.Info("Workload exhausted its retries, skipping until its spec changes")
+		return r.skip(obj, kind, skipReasonParked, "Retries exhausted, skipping until the spec changes"), nil
+	}
+
+	if docker.IsPodSpecCached(&template.Spec, obj.GetNamespace(), r.KubeServerVersion) {
+		metrics.UpdateSkippedReconcilesMetric(kind, skipReasonCached)
+		r.Backoff.Reset(key)
+		return ctrl.Result{}, nil
+	}
+
+	manager := gitops.ManagerOf(obj, r.IgnoredManagers)
+	if manager != "" && r.ManagedPolicy == gitops.PolicySkip {
+		log.Info(fmt.Sprintf("Workload is managed by %s, skipping...", manager))
+		return r.skip(obj, kind, skipReasonManaged, fmt.Sprintf("Workload is managed by %s", manager)), nil
+	}
+
+	if remaining, ok := r.Flapping.Suppressed(key); ok {
+		log.Info(fmt.Sprintf("Workload rewrites keep being reverted, resuming in %s", remaining))
+		res := r.skip(obj, kind, skipReasonFlapping, fmt.Sprintf("Rewrites keep being reverted, resuming in %s", remaining.Round(time.Second)))
+		res.RequeueAfter = remaining
+		return res, nil
+	}
+
+	r.Recorder.Event(obj, corev1.EventTypeNormal, reasonCloneStarted,
+		fmt.Sprintf("Cloning images: %s", strings.Join(uncachedImages(&template.Spec, obj.GetNamespace()), ", ")))
+
+	original := template.Spec.DeepCopy()
+	workload := docker.Workload{Kind: kind, Namespace: obj.GetNamespace(), Name: obj.GetName()}
+	if err := docker.MustCacheAndModifyPodImage(ctx, workload, &template.Spec, r.KubeServerVersion); err != nil {
+		return r.handleErr(log, kind, obj, err)
+	}
+
+	// Only the rewritten images are sent to the API server, the object is
+	// restored so it keeps mirroring what the server last returned
+	rewrites := imageRewrites(original, &template.Spec)
+	original.DeepCopyInto(&template.Spec)
+	if len(rewrites) > 0 {
+		r.Recorder.Event(obj, corev1.EventTypeNormal, reasonCloneSucceeded, fmt.Sprintf("Cloned %d image(s)", len(rewrites)))
+	}
+
+	if manager != "" && r.ManagedPolicy == gitops.PolicyPrecache {
+		// Rewriting would be reverted on the next sync so leave the change to the source of truth
+		r.Recorder.Event(obj, corev1.EventTypeNormal, reasonImagesPrecached, fmt.Sprintf(
+			"Workload is managed by %s, images were cached but not rewritten. Update its source to use: %s",
+			manager, describeRewrites(rewrites)))
+		r.Backoff.Reset(key)
+		return ctrl.Result{}, nil
+	}
+
+	reverted := isRevert(obj, rewrites)
+	err := r.patchWorkload(ctx, obj, func() ([]patchOp, error) {
+		return rewritePatchOps(obj, template, rewrites)
+	})
+	if err != nil {
+		return r.handleErr(log, kind, obj, errors.ErrorUpdatingResource(obj.GetName(), obj.GetNamespace(), kind, err))
+	}
+	r.Backoff.Reset(key)
+	if len(rewrites) > 0 {
+		r.Recorder.Event(obj, corev1.EventTypeNormal, reasonRewritten, fmt.Sprintf("Rewrote images: %s", describeRewrites(rewrites)))
+	}
+
+	if reverted && r.Flapping.RecordRewrite(key) {
+		writer := lastSpecWriter(obj)
+		cooldown, _ := r.Flapping.Suppressed(key)
+		metrics.UpdateFlappingWorkloadsMetric(obj.GetName(), obj.GetNamespace(), kind, writer)
+		r.Recorder.Event(obj, corev1.EventTypeWarning, reasonRewriteFlapping, fmt.Sprintf(
+			"Rewritten images keep being reverted, last by field manager %s. Pausing rewrites for %s",
+			writer, cooldown))
+		log.Info("Workload rewrites keep being reverted, pausing rewrites", "lastWriter", writer, "cooldown", cooldown.String())
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// revertWorkload restores the upstream images recorded on obj and removes the record
+func (r *WorkloadReconciler) revertWorkload(ctx context.Context, log logr.Logger, kind string, obj client.Object, template *corev1.PodTemplateSpec) (ctrl.Result, error) {
+	if !annotations.Has(obj, annotations.OriginalImages) {
+		return r.skip(obj, kind, skipReasonReverted, "Revert requested, leaving images untouched"), nil
+	}
+
+	err := r.patchWorkload(ctx, obj, func() ([]patchOp, error) {
+		return revertPatchOps(obj, template)
+	})
+	if err != nil {
+		return r.handleErr(log, kind, obj, errors.ErrorUpdatingResource(obj.GetName(), obj.GetNamespace(), kind, err))
+	}
+	log.Info("Restored original images")
+	r.Recorder.Event(obj, corev1.EventTypeNormal, reasonReverted, "Restored original images")
+
+	return ctrl.Result{}, nil
+}
+
+// describeRewrites lists rewrites as container: from -> to pairs
+func describeRewrites(rewrites []imageRewrite) string {
+	descriptions := make([]string, 0, len(rewrites))
+	for _, rw := range rewrites {
+		descriptions = append(descriptions, fmt.Sprintf("%s: %s -> %s", rw.container, rw.from, rw.to))
+	}
+
+	return strings.Join(descriptions, ", ")
+}
+
+// uncachedImages lists the images of spec, run in namespace, not pointing at the cache yet
+func uncachedImages(spec *corev1.PodSpec, namespace string) []string {
+	var images []string
+	for _, c := range podImages(spec) {
+		if !docker.IsCacheURL(c.image, namespace) {
+			images = append(images, c.image)
+		}
+	}
+
+	return images
+}
+
+// skip records a reconcile returning early along with the reason why
+func (r *WorkloadReconciler) skip(obj client.Object, kind, reason, message string) ctrl.Result {
+	metrics.UpdateSkippedReconcilesMetric(kind, reason)
+	r.Recorder.Event(obj, corev1.EventTypeNormal, reasonSkipped, message)
+
+	return ctrl.Result{}
+}
+
+// handleErr records a failed reconcile and requeues it following the backoff policy of its error class.
+// The error is never returned to controller-runtime so its own backoff does not stack on ours.
+// obj only needs a name and namespace when it could not be fetched
+func (r *WorkloadReconciler) handleErr(log logr.Logger, kind string, obj client.Object, err error) (ctrl.Result, error) {
+	name, namespace, generation := obj.GetName(), obj.GetNamespace(), obj.GetGeneration()
+	if cloneErr, ok := errors.AsCloneError(err); ok && cloneErr.Workload == "" {
+		cloneErr.WithWorkload(kind, namespace, name)
+	}
+	metrics.UpdateFailedImageClonesMetric(name, namespace, kind, errors.ImageOf(err), errors.TypeOf(err))
+	if obj.GetUID() != "" {
+		if findings, ok := docker.ScanFindings(err); ok {
+			r.Recorder.Event(obj, corev1.EventTypeWarning, reasonImageScanFailed, scanFailureMessage(err, findings))
+		} else if errors.TypeOf(err) == errors.ImageSignature {
+			r.Recorder.Event(obj, corev1.EventTypeWarning, reasonSignatureFailed, err.Error())
+		} else {
+			r.Recorder.Event(obj, corev1.EventTypeWarning, reasonCloneFailed, err.Error())
+		}
+	}
+
+	if !errors.IsRetryable(err) {
+		log.Error(err, "permanent error occurred, not requeueing")
+		return ctrl.Result{}, nil
+	}
+
+	delay, ok := r.Backoff.Next(workloadKey(kind, namespace, name), errors.TypeOf(err), generation)
+	if !ok {
+		metrics.UpdateParkedWorkloadsMetric(name, namespace, kind, errors.TypeOf(err))
+		log.Error(err, "retries exhausted, parking workload until its spec changes")
+		return ctrl.Result{}, nil
+	}
+
+	log.Error(err, "error occurred, requeueing", "after", delay.String())
+	return ctrl.Result{RequeueAfter: delay}, nil
+}
+
+// scanFailureMessage lists the first findings that kept an image from being promoted
+func scanFailureMessage(err error, findings []docker.Finding) string {
+	listed := make([]string, 0, maxListedFindings)
+	for idx, finding := range findings {
+		if idx == maxListedFindings {
+			listed = append(listed, fmt.Sprintf("and %d more", len(findings)-maxListedFindings))
+			break
+		}
+		listed = append(listed, finding.String())
+	}
+
+	return fmt.Sprintf("%s: %s", err.Error(), strings.Join(listed, ", "))
+}
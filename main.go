@@ -17,14 +17,29 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	cachev1alpha1 "github.com/Tiemma/image-clone-controller/api/v1alpha1"
 	"github.com/Tiemma/image-clone-controller/controllers"
+	"github.com/Tiemma/image-clone-controller/pkg/backoff"
+	"github.com/Tiemma/image-clone-controller/pkg/docker"
 	"github.com/Tiemma/image-clone-controller/pkg/env"
 	"github.com/Tiemma/image-clone-controller/pkg/errors"
+	"github.com/Tiemma/image-clone-controller/pkg/events"
+	"github.com/Tiemma/image-clone-controller/pkg/flapping"
+	"github.com/Tiemma/image-clone-controller/pkg/gitops"
+	"github.com/Tiemma/image-clone-controller/pkg/health"
+	"github.com/Tiemma/image-clone-controller/pkg/inventory"
 	"github.com/Tiemma/image-clone-controller/pkg/metrics"
+	"github.com/Tiemma/image-clone-controller/pkg/provisioner"
+	"github.com/Tiemma/image-clone-controller/pkg/resync"
+	"github.com/Tiemma/image-clone-controller/pkg/routing"
+	"github.com/Tiemma/image-clone-controller/pkg/scanner"
+	"github.com/Tiemma/image-clone-controller/pkg/tenant"
 	appsv1 "k8s.io/api/apps/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/version"
 	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
@@ -36,6 +51,7 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"strconv"
+	"strings"
 	"time"
 	// +kubebuilder:scaffold:imports
 )
@@ -44,12 +60,29 @@ var (
 	scheme                         = runtime.NewScheme()
 	setupLog                       = ctrl.Log.WithName("setup")
 	defaultRetryDelayMinutes int64 = 5
+
+	defaultFlapWindow    = 10 * time.Minute
+	defaultFlapThreshold = 3
+	defaultFlapCooldown  = time.Hour
+
+	defaultRegistryProbeInterval = 30 * time.Second
+	defaultRegistryOutageWindow  = 5 * time.Minute
+
+	defaultEventInterval = 5 * time.Minute
+	defaultAuditInterval = time.Hour
+
+	defaultGCGracePeriod = 7 * 24 * time.Hour
+	defaultGCKeepLast    = 1
+
+	defaultReplicaRetryInterval = time.Minute
+	defaultReplicaMaxRetryDelay = time.Hour
 )
 
 func init() {
 	_ = clientgoscheme.AddToScheme(scheme)
 
 	_ = appsv1.AddToScheme(scheme)
+	_ = cachev1alpha1.AddToScheme(scheme)
 	// +kubebuilder:scaffold:scheme
 }
 
@@ -62,15 +95,369 @@ func getDelayPeriod() int64 {
 	delay, err := strconv.Atoi(delayPeriod)
 	if err != nil {
 		setupLog.Error(err, "specified delay period is not valid")
+		os.Exit(1)
 	}
 
 	if delay <= 0 {
-		errors.HandleErr(fmt.Errorf("delay must be positive and greater than 0"))
+		setupLog.Error(fmt.Errorf("delay must be positive and greater than 0"), "specified delay period is not valid")
+		os.Exit(1)
 	}
 
 	return int64(delay)
 }
 
+// getBackoffTracker builds the retry policies, DELAY_PERIOD caps the classes without a faster default
+func getBackoffTracker(maxDelay time.Duration) *backoff.Tracker {
+	policies := backoff.DefaultPolicies(maxDelay)
+	if err := backoff.ParsePolicies(os.Getenv(env.RetryPolicy), policies); err != nil {
+		setupLog.Error(err, "specified retry policy is not valid")
+		os.Exit(1)
+	}
+
+	return backoff.NewTracker(policies, backoff.DefaultFallbackPolicy(maxDelay))
+}
+
+func getManagedWorkloadPolicy() gitops.Policy {
+	policy, err := gitops.ParsePolicy(os.Getenv(env.ManagedWorkloadPolicy))
+	if err != nil {
+		setupLog.Error(err, "specified managed workload policy is not valid")
+		os.Exit(1)
+	}
+
+	return policy
+}
+
+func getIgnoredManagers() []string {
+	if ignoredManagers := env.GetList(env.IgnoredManagers); len(ignoredManagers) > 0 {
+		return ignoredManagers
+	}
+
+	return gitops.DefaultIgnoredManagers
+}
+
+func getFlappingDetector() *flapping.Detector {
+	window, err := env.GetDuration(env.FlapWindow, defaultFlapWindow)
+	if err != nil {
+		setupLog.Error(err, "specified flap window is not valid")
+		os.Exit(1)
+	}
+
+	threshold, err := env.GetInt(env.FlapThreshold, defaultFlapThreshold)
+	if err != nil {
+		setupLog.Error(err, "specified flap threshold is not valid")
+		os.Exit(1)
+	}
+
+	cooldown, err := env.GetDuration(env.FlapCooldown, defaultFlapCooldown)
+	if err != nil {
+		setupLog.Error(err, "specified flap cooldown is not valid")
+		os.Exit(1)
+	}
+
+	return flapping.NewDetector(window, threshold, cooldown)
+}
+
+// getRoutes returns the routing table of ROUTES_FILE, or one sending every image to REPO_URL
+func getRoutes() *routing.Table {
+	path := os.Getenv(env.RoutesFile)
+	if path == "" {
+		table, err := routing.New(nil, os.Getenv(env.RepoURL))
+		if err != nil {
+			setupLog.Error(err, "specified repo url is not valid")
+			os.Exit(1)
+		}
+		return table
+	}
+
+	table, err := routing.Load(path, os.Getenv(env.RepoURL))
+	if err != nil {
+		setupLog.Error(err, "specified routes are not valid")
+		os.Exit(1)
+	}
+
+	return table
+}
+
+// getHealthChecker returns the destination registries checker, or nil when the fallback is disabled.
+// REPO_URL and the destinations and replicas of every route are probed
+func getHealthChecker(routes *routing.Table) *health.Checker {
+	if !env.IsRegistryFallbackEnabled() {
+		return nil
+	}
+
+	var destinations []string
+	if repoURL := os.Getenv(env.RepoURL); repoURL != "" {
+		destinations = append(destinations, repoURL)
+	}
+	for _, route := range routes.Routes() {
+		if route.Skip {
+			continue
+		}
+		destinations = append(destinations, route.Destination)
+		for _, replica := range route.Replicas {
+			destinations = append(destinations, replica.Destination)
+		}
+	}
+	if len(destinations) == 0 {
+		return nil
+	}
+
+	interval, err := env.GetDuration(env.RegistryProbeInterval, defaultRegistryProbeInterval)
+	if err != nil {
+		setupLog.Error(err, "specified registry probe interval is not valid")
+		os.Exit(1)
+	}
+
+	outageWindow, err := env.GetDuration(env.RegistryOutageWindow, defaultRegistryOutageWindow)
+	if err != nil {
+		setupLog.Error(err, "specified registry outage window is not valid")
+		os.Exit(1)
+	}
+
+	checker, err := health.NewChecker(destinations, interval, outageWindow)
+	if err != nil {
+		setupLog.Error(err, "unable to create registry health checker")
+		os.Exit(1)
+	}
+
+	return checker
+}
+
+func getEventRecorder(mgr ctrl.Manager) *events.RateLimitedRecorder {
+	interval, err := env.GetDuration(env.EventInterval, defaultEventInterval)
+	if err != nil {
+		setupLog.Error(err, "specified event interval is not valid")
+		os.Exit(1)
+	}
+
+	return events.NewRateLimitedRecorder(mgr.GetEventRecorderFor("image-clone-controller"), interval)
+}
+
+// getCloneCache returns the clone cache warmed from its store
+func getCloneCache(mgr ctrl.Manager) *docker.Cache {
+	ttl, err := env.GetDuration(env.CloneCacheTTL, docker.DefaultCacheTTL)
+	if err != nil {
+		setupLog.Error(err, "specified clone cache ttl is not valid")
+		os.Exit(1)
+	}
+
+	maxEntries, err := env.GetInt(env.CloneCacheMaxEntries, docker.DefaultCacheMaxEntries)
+	if err != nil {
+		setupLog.Error(err, "specified clone cache max entries is not valid")
+		os.Exit(1)
+	}
+
+	var store docker.CacheStore
+	if path := os.Getenv(env.CloneCacheFile); path != "" {
+		store = docker.FileStore{Path: path}
+	} else if configMap := os.Getenv(env.CloneCacheConfigMap); configMap != "" {
+		parts := strings.Split(configMap, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			setupLog.Error(fmt.Errorf("expected <namespace>/<name>, got %s", configMap), "specified clone cache configmap is not valid")
+			os.Exit(1)
+		}
+		store = docker.ConfigMapStore{
+			Client: mgr.GetClient(),
+			Reader: mgr.GetAPIReader(),
+			Key:    types.NamespacedName{Namespace: parts[0], Name: parts[1]},
+		}
+	}
+
+	cache := docker.NewCache(store, ttl, maxEntries)
+	if err := cache.Load(context.Background()); err != nil {
+		// A cold cache only costs extra manifest fetches
+		setupLog.Error(err, "unable to load clone cache, starting empty")
+	}
+
+	return cache
+}
+
+// getResyncSchedule returns the schedule of mutable tag resyncs, or nil when no rule is configured
+// restoreTenants registers the destinations of every namespace so their credentials are known
+// before their workloads are reconciled again
+func restoreTenants(tenants *tenant.Tenants) {
+	namespaces, err := tenants.Namespaces(context.Background())
+	if err != nil {
+		// Tenants are still registered as their workloads are reconciled
+		setupLog.Error(err, "unable to list namespaces, tenants are not restored")
+		return
+	}
+
+	docker.RestoreTenants(context.Background(), namespaces)
+}
+
+func getResyncSchedule() *resync.Schedule {
+	rules, err := resync.ParseRules(os.Getenv(env.ResyncRules))
+	if err != nil {
+		setupLog.Error(err, "specified resync rules are not valid")
+		os.Exit(1)
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	return resync.NewSchedule(rules)
+}
+
+func getTagConflictPolicy() docker.TagConflictPolicy {
+	policy, err := docker.ParseTagConflictPolicy(os.Getenv(env.TagConflictPolicy))
+	if err != nil {
+		setupLog.Error(err, "specified tag conflict policy is not valid")
+		os.Exit(1)
+	}
+
+	return policy
+}
+
+func getPrivateImagePolicy() docker.PrivateImagePolicy {
+	policy, err := docker.ParsePrivateImagePolicy(os.Getenv(env.PrivateImagePolicy))
+	if err != nil {
+		setupLog.Error(err, "specified private image policy is not valid")
+		os.Exit(1)
+	}
+
+	return policy
+}
+
+// getProvisioner returns the provisioner of destination repositories, or nil when REPOSITORY_PROVISIONER is unset
+func getProvisioner() docker.Provisioner {
+	kind, err := provisioner.ParseKind(os.Getenv(env.RepositoryProvisioner))
+	if err != nil {
+		setupLog.Error(err, "specified repository provisioner is not valid")
+		os.Exit(1)
+	}
+
+	url := os.Getenv(env.ProvisionerURL)
+	switch kind {
+	case provisioner.KindHarbor:
+		return &provisioner.Harbor{URL: url}
+	case provisioner.KindECR:
+		return &provisioner.ECR{
+			Endpoint:        url,
+			Region:          os.Getenv(env.AWSRegion),
+			AccessKeyID:     os.Getenv(env.AWSAccessKeyID),
+			SecretAccessKey: os.Getenv(env.AWSSecretAccessKey),
+			SessionToken:    os.Getenv(env.AWSSessionToken),
+		}
+	case provisioner.KindHook:
+		if url == "" {
+			setupLog.Error(errors.ErrorMissingConfig(env.ProvisionerURL), "the hook provisioner needs a url")
+			os.Exit(1)
+		}
+		return &provisioner.Hook{URL: url}
+	default:
+		return nil
+	}
+}
+
+// getArtifactKinds returns the artifacts copied alongside images, none when COPY_ARTIFACTS is unset
+func getArtifactKinds() []docker.ArtifactKind {
+	kinds, err := docker.ParseArtifactKinds(env.GetList(env.CopyArtifacts))
+	if err != nil {
+		setupLog.Error(err, "specified artifacts are not valid")
+		os.Exit(1)
+	}
+
+	return kinds
+}
+
+// setScanGate stages and scans every image before promotion when SCANNER_COMMAND is set
+func setScanGate() {
+	command := os.Getenv(env.ScannerCommand)
+	if command == "" {
+		return
+	}
+
+	execScanner, err := scanner.NewExec(command)
+	if err != nil {
+		setupLog.Error(err, "specified scanner command is not valid")
+		os.Exit(1)
+	}
+
+	stagingRepo := os.Getenv(env.StagingRepoURL)
+	if stagingRepo == "" {
+		setupLog.Error(errors.ErrorMissingConfig(env.StagingRepoURL), "scanning images needs a staging repository")
+		os.Exit(1)
+	}
+
+	threshold, err := docker.ParseSeverityThreshold(os.Getenv(env.ScanSeverityThreshold))
+	if err != nil {
+		setupLog.Error(err, "specified scan severity threshold is not valid")
+		os.Exit(1)
+	}
+
+	docker.SetScanGate(execScanner, stagingRepo, threshold)
+}
+
+func getAuditInterval() time.Duration {
+	interval, err := env.GetDuration(env.AuditInterval, defaultAuditInterval)
+	if err != nil {
+		setupLog.Error(err, "specified audit interval is not valid")
+		os.Exit(1)
+	}
+
+	return interval
+}
+
+// getGarbageCollector returns the garbage collector of unused cached images, or nil when GC_INTERVAL is unset
+func getGarbageCollector(mgr ctrl.Manager) *controllers.GarbageCollector {
+	if os.Getenv(env.GCInterval) == "" {
+		return nil
+	}
+
+	interval, err := env.GetDuration(env.GCInterval, 0)
+	if err != nil {
+		setupLog.Error(err, "specified garbage collection interval is not valid")
+		os.Exit(1)
+	}
+
+	gracePeriod, err := env.GetDuration(env.GCGracePeriod, defaultGCGracePeriod)
+	if err != nil {
+		setupLog.Error(err, "specified garbage collection grace period is not valid")
+		os.Exit(1)
+	}
+
+	keepLast, err := env.GetInt(env.GCKeepLast, defaultGCKeepLast)
+	if err != nil {
+		setupLog.Error(err, "specified number of images to keep is not valid")
+		os.Exit(1)
+	}
+
+	return &controllers.GarbageCollector{
+		Client:      mgr.GetClient(),
+		Reader:      mgr.GetAPIReader(),
+		Log:         ctrl.Log.WithName("controllers").WithName("GarbageCollector"),
+		Interval:    interval,
+		GracePeriod: gracePeriod,
+		KeepLast:    keepLast,
+		DryRun:      env.IsGCDryRunEnabled(),
+	}
+}
+
+// getReplicaSyncer returns the syncer of lagging replicas, or nil when no route replicates images
+func getReplicaSyncer(mgr ctrl.Manager, routes *routing.Table) *controllers.ReplicaSyncer {
+	replicated := false
+	for _, route := range routes.Routes() {
+		replicated = replicated || len(route.Replicas) > 0
+	}
+	if !replicated {
+		return nil
+	}
+
+	interval, err := env.GetDuration(env.ReplicaRetryInterval, defaultReplicaRetryInterval)
+	if err != nil {
+		setupLog.Error(err, "specified replica retry interval is not valid")
+		os.Exit(1)
+	}
+
+	return &controllers.ReplicaSyncer{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("ReplicaSyncer"),
+		Interval: interval,
+		Policy:   backoff.Policy{BaseDelay: interval, MaxDelay: defaultReplicaMaxRetryDelay, Jitter: 0.2},
+	}
+}
+
 func getKubeConfig() *rest.Config {
 	if os.Getenv(env.IsDevEnv) == "true" {
 		configPath := filepath.Join(
@@ -111,6 +498,12 @@ func getKubeServerVersion() *version.Info {
 	return kubeVersion
 }
 
+// withLogger returns a copy of the shared reconciler logging under the given kind
+func withLogger(base controllers.WorkloadReconciler, kind string) controllers.WorkloadReconciler {
+	base.Log = ctrl.Log.WithName("controllers").WithName(kind)
+	return base
+}
+
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
@@ -134,33 +527,110 @@ func main() {
 		os.Exit(1)
 	}
 
-	env.MustValidateRequiredEnvsExist()
+	if err := env.ValidateRequiredEnvsExist(); err != nil {
+		setupLog.Error(err, "missing required configuration")
+		os.Exit(1)
+	}
 
-	kubeVersion := getKubeServerVersion()
-	retryDelayDuration := time.Duration(getDelayPeriod()) * time.Minute
+	routes := getRoutes()
+	docker.SetRoutes(routes)
+	// Secrets are read directly so the controller does not cache every Secret of the cluster
+	tenants := tenant.New(mgr.GetAPIReader(), os.Getenv(env.TenantSecret))
+	if env.IsTenantIsolationEnabled() {
+		docker.SetTenants(tenants)
+		restoreTenants(tenants)
+	}
+	docker.SetPrivateImagePolicy(getPrivateImagePolicy(), tenants)
+	docker.SetProvisioner(getProvisioner())
+	setScanGate()
+	docker.SetArtifactKinds(getArtifactKinds())
+
+	healthChecker := getHealthChecker(routes)
+	if healthChecker != nil {
+		if err := mgr.Add(healthChecker); err != nil {
+			setupLog.Error(err, "unable to add registry health checker")
+			os.Exit(1)
+		}
+	}
 	metrics.Init()
+	docker.SetInventory(inventory.New(mgr.GetClient()))
+	docker.SetCache(getCloneCache(mgr))
+	docker.SetTagConflictPolicy(getTagConflictPolicy())
+	docker.SetVerifyBlobs(env.IsBlobVerificationEnabled())
 
-	if err = (&controllers.DaemonSetReconciler{
+	workloadReconciler := controllers.WorkloadReconciler{
 		Client:            mgr.GetClient(),
-		Log:               ctrl.Log.WithName("controllers").WithName("DaemonSet"),
 		Scheme:            mgr.GetScheme(),
-		KubeServerVersion: kubeVersion.GitVersion,
-		RetryDelay:        retryDelayDuration,
+		KubeServerVersion: getKubeServerVersion().GitVersion,
+		Backoff:           getBackoffTracker(time.Duration(getDelayPeriod()) * time.Minute),
+		Recorder:          getEventRecorder(mgr),
+		ManagedPolicy:     getManagedWorkloadPolicy(),
+		IgnoredManagers:   getIgnoredManagers(),
+		Flapping:          getFlappingDetector(),
+		Health:            healthChecker,
+	}
+
+	if err = (&controllers.DaemonSetReconciler{
+		WorkloadReconciler: withLogger(workloadReconciler, "DaemonSet"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "DaemonSet")
 		os.Exit(1)
 	}
 
 	if err = (&controllers.DeploymentReconciler{
-		Client:            mgr.GetClient(),
-		Log:               ctrl.Log.WithName("controllers").WithName("Deployment"),
-		Scheme:            mgr.GetScheme(),
-		KubeServerVersion: kubeVersion.GitVersion,
-		RetryDelay: retryDelayDuration,
+		WorkloadReconciler: withLogger(workloadReconciler, "Deployment"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Deployment")
 		os.Exit(1)
 	}
+
+	if schedule := getResyncSchedule(); schedule != nil {
+		if err := mgr.Add(&controllers.Resyncer{
+			Client:   mgr.GetClient(),
+			Log:      ctrl.Log.WithName("controllers").WithName("Resyncer"),
+			Recorder: workloadReconciler.Recorder,
+			Schedule: schedule,
+		}); err != nil {
+			setupLog.Error(err, "unable to add resyncer")
+			os.Exit(1)
+		}
+	}
+
+	if env.IsAuditEnabled() {
+		if err := mgr.Add(&controllers.Auditor{
+			Client:   mgr.GetClient(),
+			Log:      ctrl.Log.WithName("controllers").WithName("Auditor"),
+			Recorder: workloadReconciler.Recorder,
+			Interval: getAuditInterval(),
+		}); err != nil {
+			setupLog.Error(err, "unable to add auditor")
+			os.Exit(1)
+		}
+	}
+
+	if err = (&controllers.PodReconciler{
+		Client:          mgr.GetClient(),
+		Log:             ctrl.Log.WithName("controllers").WithName("Pod"),
+		Recorder:        workloadReconciler.Recorder,
+		RevertOnFailure: env.IsRevertOnPullFailureEnabled(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Pod")
+		os.Exit(1)
+	}
+
+	if gc := getGarbageCollector(mgr); gc != nil {
+		if err := mgr.Add(gc); err != nil {
+			setupLog.Error(err, "unable to add garbage collector")
+			os.Exit(1)
+		}
+	}
+
+	if syncer := getReplicaSyncer(mgr, routes); syncer != nil {
+		if err := mgr.Add(syncer); err != nil {
+			setupLog.Error(err, "unable to add replica syncer")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")
@@ -0,0 +1,69 @@
+package annotations
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	prefix = "image-clone-controller.bakman.build/"
+
+	// OriginalImages holds a JSON object mapping each rewritten container to its upstream image
+	OriginalImages = prefix + "original-images"
+	// FallbackImages holds the cached image of each container switched back upstream during a registry outage
+	FallbackImages = prefix + "fallback-images"
+	// Revert asks the controller to restore the original images of a workload when set to "true"
+	Revert = prefix + "revert"
+	// Destination set on a namespace overrides the repository its images are cloned to
+	Destination = prefix + "destination"
+	// CredentialsSecret set on a namespace names the dockerconfigjson Secret used to push its images
+	CredentialsSecret = prefix + "credentials-secret"
+)
+
+// ContainerKey identifies a container within OriginalImages e.g "initContainers/setup"
+func ContainerKey(field, container string) string {
+	return fmt.Sprintf("%s/%s", field, container)
+}
+
+func getImages(obj metav1.Object, annotation string) (map[string]string, error) {
+	images := map[string]string{}
+	value, ok := obj.GetAnnotations()[annotation]
+	if !ok {
+		return images, nil
+	}
+
+	if err := json.Unmarshal([]byte(value), &images); err != nil {
+		return nil, fmt.Errorf("annotation %s is not valid: %w", annotation, err)
+	}
+
+	return images, nil
+}
+
+// GetOriginalImages returns the upstream images recorded on obj keyed by ContainerKey
+func GetOriginalImages(obj metav1.Object) (map[string]string, error) {
+	return getImages(obj, OriginalImages)
+}
+
+// GetFallbackImages returns the cached images replaced during a registry outage keyed by ContainerKey
+func GetFallbackImages(obj metav1.Object) (map[string]string, error) {
+	return getImages(obj, FallbackImages)
+}
+
+// Has reports whether obj carries the annotation
+func Has(obj metav1.Object, annotation string) bool {
+	_, ok := obj.GetAnnotations()[annotation]
+	return ok
+}
+
+// IsRevertRequested reports whether obj asks for its original images back
+func IsRevertRequested(obj metav1.Object) bool {
+	return strings.EqualFold(obj.GetAnnotations()[Revert], "true")
+}
+
+// EscapeJSONPointer escapes an annotation key for use in a JSON patch path
+func EscapeJSONPointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,207 @@
+package backoff
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Tiemma/image-clone-controller/pkg/errors"
+)
+
+const defaultJitter = 0.2
+
+// Policy describes how failures of a single error class are retried
+type Policy struct {
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// MaxAttempts is the number of retries allowed before the item is parked, 0 disables retries
+	MaxAttempts int
+	// Jitter is the fraction of the delay randomly added or removed to spread out retries
+	Jitter float64
+}
+
+// Delay returns the exponential backoff for the given attempt, starting at 1
+func (p Policy) Delay(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if p.Jitter > 0 {
+		delay += time.Duration(float64(delay) * p.Jitter * (2*rand.Float64() - 1))
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	return delay
+}
+
+// DefaultPolicies returns the built-in policy per error class, maxDelay caps the slower classes
+func DefaultPolicies(maxDelay time.Duration) map[errors.ErrType]Policy {
+	return map[errors.ErrType]Policy{
+		errors.ImageReference:   {},
+		errors.Config:           {},
+		errors.ImageTagConflict: {},
+		errors.ImageWrite:       {BaseDelay: 2 * time.Second, MaxDelay: time.Minute, MaxAttempts: 15, Jitter: defaultJitter},
+		errors.ImageVerify:      {BaseDelay: 5 * time.Second, MaxDelay: 2 * time.Minute, MaxAttempts: 10, Jitter: defaultJitter},
+		errors.ImageManifest:    {BaseDelay: 30 * time.Second, MaxDelay: maxDelay, MaxAttempts: 10, Jitter: defaultJitter},
+		errors.SpecGet:          {BaseDelay: time.Second, MaxDelay: 30 * time.Second, MaxAttempts: 10, Jitter: defaultJitter},
+		errors.SpecUpdate:       {BaseDelay: time.Second, MaxDelay: 30 * time.Second, MaxAttempts: 10, Jitter: defaultJitter},
+		errors.ImageDelete:      {BaseDelay: time.Minute, MaxDelay: maxDelay, MaxAttempts: 5, Jitter: defaultJitter},
+		// Missing permissions or quotas take an operator to fix, retrying quickly only floods the registry API
+		errors.RepositoryProvision: {BaseDelay: 2 * time.Minute, MaxDelay: maxDelay, MaxAttempts: 5, Jitter: defaultJitter},
+		// Findings and invalid signatures are permanent, only scanner and registry failures reading signatures are retried
+		errors.ImageScan:      {BaseDelay: 2 * time.Minute, MaxDelay: maxDelay, MaxAttempts: 5, Jitter: defaultJitter},
+		errors.ImageSignature: {BaseDelay: time.Minute, MaxDelay: maxDelay, MaxAttempts: 5, Jitter: defaultJitter},
+	}
+}
+
+// DefaultFallbackPolicy is used for error classes without a dedicated policy
+func DefaultFallbackPolicy(maxDelay time.Duration) Policy {
+	return Policy{BaseDelay: 30 * time.Second, MaxDelay: maxDelay, MaxAttempts: 10, Jitter: defaultJitter}
+}
+
+// ParsePolicies overrides policies with a comma separated list of
+// ERR_TYPE=base:max:attempts[:jitter] entries e.g "IMAGE_WRITE=1s:30s:20"
+func ParsePolicies(str string, policies map[errors.ErrType]Policy) error {
+	for _, entry := range strings.Split(strings.ReplaceAll(str, " ", ""), ",") {
+		if entry == "" {
+			continue
+		}
+
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("retry policy %q must be of the form ERR_TYPE=base:max:attempts[:jitter]", entry)
+		}
+
+		policy, err := parsePolicy(kv[1])
+		if err != nil {
+			return fmt.Errorf("retry policy for %s is not valid: %w", kv[0], err)
+		}
+		policies[errors.ErrType(kv[0])] = policy
+	}
+
+	return nil
+}
+
+func parsePolicy(str string) (Policy, error) {
+	parts := strings.Split(str, ":")
+	if len(parts) < 3 || len(parts) > 4 {
+		return Policy{}, fmt.Errorf("expected base:max:attempts[:jitter], got %q", str)
+	}
+
+	base, err := time.ParseDuration(parts[0])
+	if err != nil {
+		return Policy{}, err
+	}
+	max, err := time.ParseDuration(parts[1])
+	if err != nil {
+		return Policy{}, err
+	}
+	attempts, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return Policy{}, err
+	}
+	// A zero delay would be taken as not requeueing at all
+	if base <= 0 || max < base || attempts < 0 {
+		return Policy{}, fmt.Errorf("delays and attempts must be positive with base <= max, got %q", str)
+	}
+
+	jitter := defaultJitter
+	if len(parts) == 4 {
+		if jitter, err = strconv.ParseFloat(parts[3], 64); err != nil {
+			return Policy{}, err
+		}
+		if jitter < 0 || jitter > 1 {
+			return Policy{}, fmt.Errorf("jitter must be between 0 and 1, got %q", parts[3])
+		}
+	}
+
+	return Policy{BaseDelay: base, MaxDelay: max, MaxAttempts: attempts, Jitter: jitter}, nil
+}
+
+type state struct {
+	errType  errors.ErrType
+	attempts int
+	// parkedGeneration is the workload generation retries were exhausted on, 0 when not parked
+	parkedGeneration int64
+}
+
+// Tracker counts consecutive failures per item and applies the policy of their error class
+type Tracker struct {
+	mu       sync.Mutex
+	policies map[errors.ErrType]Policy
+	fallback Policy
+	items    map[string]*state
+}
+
+func NewTracker(policies map[errors.ErrType]Policy, fallback Policy) *Tracker {
+	return &Tracker{
+		policies: policies,
+		fallback: fallback,
+		items:    map[string]*state{},
+	}
+}
+
+func (t *Tracker) policyFor(errType errors.ErrType) Policy {
+	if policy, ok := t.policies[errType]; ok {
+		return policy
+	}
+
+	return t.fallback
+}
+
+// Next records a failure for key and returns the delay before the next attempt.
+// It returns false once the policy is exhausted, in which case the item is parked
+// until its generation changes. Attempts keep counting when the error type changes
+// so items alternating between failures still get parked
+func (t *Tracker) Next(key string, errType errors.ErrType, generation int64) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	item, ok := t.items[key]
+	if !ok {
+		item = &state{}
+		t.items[key] = item
+	}
+	item.errType = errType
+	item.attempts++
+
+	policy := t.policyFor(errType)
+	if item.attempts > policy.MaxAttempts {
+		item.parkedGeneration = generation
+		return 0, false
+	}
+
+	return policy.Delay(item.attempts), true
+}
+
+// IsParked reports whether key exhausted its retries on the given generation
+func (t *Tracker) IsParked(key string, generation int64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	item, ok := t.items[key]
+	if !ok || item.parkedGeneration == 0 {
+		return false
+	}
+	if item.parkedGeneration != generation {
+		// The workload changed since it was parked so give it a fresh start
+		delete(t.items, key)
+		return false
+	}
+
+	return true
+}
+
+// Reset clears the failure history of key
+func (t *Tracker) Reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.items, key)
+}
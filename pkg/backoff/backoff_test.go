@@ -0,0 +1,116 @@
+package backoff
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Tiemma/image-clone-controller/pkg/errors"
+)
+
+func TestMain(m *testing.M) {
+	// Run tests
+	code := m.Run()
+
+	os.Exit(code)
+}
+
+func TestPolicyDelay(t *testing.T) {
+	policy := Policy{BaseDelay: time.Second, MaxDelay: 10 * time.Second, MaxAttempts: 10}
+	specs := []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{attempt: 1, expected: time.Second},
+		{attempt: 2, expected: 2 * time.Second},
+		{attempt: 4, expected: 8 * time.Second},
+		{attempt: 5, expected: 10 * time.Second},
+		{attempt: 50, expected: 10 * time.Second},
+	}
+
+	for _, spec := range specs {
+		if res := policy.Delay(spec.attempt); res != spec.expected {
+			t.Errorf("expected %s for attempt %d, got %s", spec.expected, spec.attempt, res)
+		}
+	}
+}
+
+func TestPolicyDelayJitter(t *testing.T) {
+	policy := Policy{BaseDelay: 10 * time.Second, MaxDelay: time.Minute, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		res := policy.Delay(1)
+		if res < 5*time.Second || res > 15*time.Second {
+			t.Errorf("expected delay within jitter bounds, got %s", res)
+		}
+	}
+}
+
+func TestParsePolicies(t *testing.T) {
+	policies := DefaultPolicies(5 * time.Minute)
+	if err := ParsePolicies("IMAGE_WRITE=1s:30s:20, SPEC_GET=2s:1m:3:0", policies); err != nil {
+		t.Errorf("error occured parsing policies: %s", err)
+	}
+
+	expected := Policy{BaseDelay: time.Second, MaxDelay: 30 * time.Second, MaxAttempts: 20, Jitter: defaultJitter}
+	if policies[errors.ImageWrite] != expected {
+		t.Errorf("expected %v, got %v", expected, policies[errors.ImageWrite])
+	}
+	if policies[errors.SpecGet].MaxAttempts != 3 || policies[errors.SpecGet].Jitter != 0 {
+		t.Errorf("expected SPEC_GET override, got %v", policies[errors.SpecGet])
+	}
+
+	for _, invalid := range []string{"IMAGE_WRITE", "IMAGE_WRITE=1s:30s", "IMAGE_WRITE=1m:1s:3", "IMAGE_WRITE=a:b:c", "IMAGE_WRITE=0s:30s:3", "IMAGE_WRITE=1s:30s:3:-0.1", "IMAGE_WRITE=1s:30s:3:1.5"} {
+		if err := ParsePolicies(invalid, policies); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestDefaultPolicies(t *testing.T) {
+	policies := DefaultPolicies(5 * time.Minute)
+	for _, errType := range []errors.ErrType{
+		errors.ImageManifest, errors.ImageReference, errors.ImageWrite, errors.SpecUpdate, errors.SpecGet, errors.Config,
+		errors.ImageTagConflict, errors.ImageVerify, errors.ImageDelete, errors.RepositoryProvision, errors.ImageScan, errors.ImageSignature,
+	} {
+		if _, ok := policies[errType]; !ok {
+			t.Errorf("expected a default policy for %s", errType)
+		}
+	}
+}
+
+func TestTracker(t *testing.T) {
+	policies := DefaultPolicies(5 * time.Minute)
+	policies[errors.ImageWrite] = Policy{BaseDelay: time.Second, MaxDelay: time.Second, MaxAttempts: 2}
+	tracker := NewTracker(policies, DefaultFallbackPolicy(5*time.Minute))
+
+	if _, ok := tracker.Next("ref", errors.ImageReference, 1); ok {
+		t.Errorf("expected %s to never be retried", errors.ImageReference)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, ok := tracker.Next("write", errors.ImageWrite, 1); !ok {
+			t.Errorf("expected attempt %d to be retried", i+1)
+		}
+	}
+	if _, ok := tracker.Next("write", errors.ImageWrite, 1); ok {
+		t.Errorf("expected item to be parked after exhausting its attempts")
+	}
+	if !tracker.IsParked("write", 1) {
+		t.Errorf("expected item to be parked on its generation")
+	}
+	if tracker.IsParked("write", 2) {
+		t.Errorf("expected a new generation to unpark the item")
+	}
+
+	tracker.Next("alternating", errors.ImageWrite, 1)
+	tracker.Next("alternating", errors.ImageManifest, 1)
+	if _, ok := tracker.Next("alternating", errors.ImageWrite, 1); ok {
+		t.Errorf("expected attempts to keep counting when the error type changes")
+	}
+
+	tracker.Next("reset", errors.ImageWrite, 1)
+	tracker.Reset("reset")
+	if delay, _ := tracker.Next("reset", errors.ImageWrite, 1); delay != time.Second {
+		t.Errorf("expected reset to restart the backoff, got %s", delay)
+	}
+}
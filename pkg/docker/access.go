@@ -0,0 +1,144 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Tiemma/image-clone-controller/pkg/errors"
+	"github.com/Tiemma/image-clone-controller/pkg/metrics"
+	"github.com/Tiemma/image-clone-controller/pkg/routing"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// PrivateImagePolicy decides who may use the cached copy of a source that cannot be pulled anonymously
+type PrivateImagePolicy string
+
+const (
+	// PrivateShared lets every namespace use cached copies, whatever their source access
+	PrivateShared PrivateImagePolicy = "shared"
+	// PrivateAuthorized only rewrites workloads whose pull secrets can pull the source
+	PrivateAuthorized PrivateImagePolicy = "authorized"
+	// PrivateNamespaced clones private sources to a folder of the namespace of the workload
+	PrivateNamespaced PrivateImagePolicy = "namespaced"
+
+	// DefaultPrivateImagePolicy never lets a namespace use a private image cloned for another one
+	DefaultPrivateImagePolicy = PrivateNamespaced
+)
+
+// Access of a source recorded along its clone
+const (
+	AccessPublic     = "public"
+	AccessRestricted = "restricted"
+)
+
+// Decisions reported by the private images metric
+const (
+	privateDecisionAllowed    = "allowed"
+	privateDecisionDenied     = "denied"
+	privateDecisionNamespaced = "namespaced"
+)
+
+// PullSecrets builds the credentials of the image pull secrets of a namespace
+type PullSecrets interface {
+	Keychain(ctx context.Context, namespace string, names []string) (authn.Keychain, error)
+}
+
+var (
+	privateImagePolicy = DefaultPrivateImagePolicy
+	pullSecrets        PullSecrets
+)
+
+// ParsePrivateImagePolicy parses a PRIVATE_IMAGE_POLICY value, defaulting to namespaced
+func ParsePrivateImagePolicy(str string) (PrivateImagePolicy, error) {
+	switch policy := PrivateImagePolicy(strings.ToLower(str)); policy {
+	case "":
+		return DefaultPrivateImagePolicy, nil
+	case PrivateShared, PrivateAuthorized, PrivateNamespaced:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("private image policy must be one of %s, %s or %s, got %q", PrivateShared, PrivateAuthorized, PrivateNamespaced, str)
+	}
+}
+
+// SetPrivateImagePolicy sets the policy applied to private sources along the pull secrets
+// used to check workloads may pull them
+func SetPrivateImagePolicy(policy PrivateImagePolicy, secrets PullSecrets) {
+	privateImagePolicy, pullSecrets = policy, secrets
+}
+
+// pullKeychain returns the credentials of the pull secrets of a workload, nil when it has none
+// or they are not needed by the policy
+func pullKeychain(ctx context.Context, namespace string, names []string) (authn.Keychain, error) {
+	if privateImagePolicy != PrivateAuthorized || pullSecrets == nil || len(names) == 0 {
+		return nil, nil
+	}
+
+	return pullSecrets.Keychain(ctx, namespace, names)
+}
+
+// canPull reports whether ref can be read with auth, registries answering
+// unauthorized, forbidden or not found to hide private repositories
+func canPull(ref name.Reference, auth remote.Option) (bool, error) {
+	_, err := remote.Head(ref, auth)
+	if err == nil {
+		return true, nil
+	}
+
+	if transportErr, ok := err.(*transport.Error); ok {
+		switch transportErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+			return false, nil
+		}
+	}
+
+	return false, err
+}
+
+// authorizeSource applies the private image policy to the route of ref for a workload placed
+// at p, returning the route to use, the access of the source and whether the workload may be rewritten
+func authorizeSource(ref name.Reference, route routing.Route, p placement) (routing.Route, string, bool, error) {
+	if privateImagePolicy == PrivateShared {
+		return route, "", true, nil
+	}
+
+	public, err := canPull(ref, remote.WithAuth(authn.Anonymous))
+	if err != nil {
+		return route, "", false, errors.ErrorCloningImage(ref.Name(), errors.ImageManifest, err)
+	}
+	if public {
+		return route, AccessPublic, true, nil
+	}
+
+	switch privateImagePolicy {
+	case PrivateNamespaced:
+		metrics.UpdatePrivateImagesMetric(string(privateImagePolicy), privateDecisionNamespaced)
+		// Tenant routes already are scoped to their namespace
+		if p.tenant != nil {
+			return route, AccessRestricted, true, nil
+		}
+		route, err := route.ForTenant(p.namespace, "")
+		if err != nil {
+			return route, "", false, errors.Permanent(errors.Config, ref.Name(), err)
+		}
+		return route, AccessRestricted, true, nil
+	default:
+		authorized := false
+		if p.pullKeychain != nil {
+			if authorized, err = canPull(ref, remote.WithAuthFromKeychain(p.pullKeychain)); err != nil {
+				return route, "", false, errors.ErrorCloningImage(ref.Name(), errors.ImageManifest, err)
+			}
+		}
+		if !authorized {
+			metrics.UpdatePrivateImagesMetric(string(privateImagePolicy), privateDecisionDenied)
+			logger.Info(fmt.Sprintf("Image %s is private and the pull secrets of namespace %s cannot pull it, ignoring...", ref.Name(), p.namespace))
+			return route, AccessRestricted, false, nil
+		}
+		metrics.UpdatePrivateImagesMetric(string(privateImagePolicy), privateDecisionAllowed)
+		return route, AccessRestricted, true, nil
+	}
+}
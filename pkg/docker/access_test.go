@@ -0,0 +1,104 @@
+package docker
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// basicAuth only lets requests with the credentials of user through
+func basicAuth(user, password string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != user || p != password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func TestAuthorizeSource(t *testing.T) {
+	server := httptest.NewServer(basicAuth("team-a", "secret", registry.New()))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	img, _ := random.Image(64, 1)
+	ref, _ := name.ParseReference(host + "/private/app:1")
+	if err := remote.Write(ref, img, remote.WithAuthFromKeychain(mustConfigKeychain(t, host, "team-a", "secret"))); err != nil {
+		t.Errorf("error occured seeding registry: %s", err)
+		return
+	}
+	route, _ := routes.Match(ref, "team-b")
+	defer SetPrivateImagePolicy(DefaultPrivateImagePolicy, nil)
+
+	tests := []struct {
+		policy  PrivateImagePolicy
+		p       placement
+		access  string
+		allowed bool
+		url     string
+	}{
+		{policy: PrivateShared, p: placement{namespace: "team-b"}, allowed: true, url: repoURL + "/app:1"},
+		{policy: PrivateAuthorized, p: placement{namespace: "team-b"}, access: AccessRestricted, allowed: false},
+		{policy: PrivateAuthorized, p: placement{namespace: "team-b", pullKeychain: mustConfigKeychain(t, host, "team-b", "wrong")}, access: AccessRestricted, allowed: false},
+		{policy: PrivateAuthorized, p: placement{namespace: "team-a", pullKeychain: mustConfigKeychain(t, host, "team-a", "secret")}, access: AccessRestricted, allowed: true, url: repoURL + "/app:1"},
+		{policy: PrivateNamespaced, p: placement{namespace: "team-b"}, access: AccessRestricted, allowed: true, url: repoURL + "/team-b/app:1"},
+	}
+
+	for _, test := range tests {
+		SetPrivateImagePolicy(test.policy, nil)
+		res, access, allowed, err := authorizeSource(ref, route, test.p)
+		if err != nil {
+			t.Errorf("error occured authorizing source with policy %s: %s", test.policy, err)
+			continue
+		}
+		if access != test.access || allowed != test.allowed {
+			t.Errorf("expected access %q (allowed %v) with policy %s, got %q (allowed %v)", test.access, test.allowed, test.policy, access, allowed)
+		}
+		if allowed && res.URL(ref) != test.url {
+			t.Errorf("expected %s with policy %s, got %s", test.url, test.policy, res.URL(ref))
+		}
+	}
+
+	public := httptest.NewServer(registry.New())
+	defer public.Close()
+	publicRef, _ := name.ParseReference(strings.TrimPrefix(public.URL, "http://") + "/public/app:1")
+	if err := remote.Write(publicRef, img); err != nil {
+		t.Errorf("error occured seeding registry: %s", err)
+		return
+	}
+	SetPrivateImagePolicy(PrivateAuthorized, nil)
+	if _, access, allowed, err := authorizeSource(publicRef, route, placement{namespace: "team-b"}); access != AccessPublic || !allowed || err != nil {
+		t.Errorf("expected public images to be allowed, got %q (allowed %v): %v", access, allowed, err)
+	}
+}
+
+func TestParsePrivateImagePolicy(t *testing.T) {
+	if policy, err := ParsePrivateImagePolicy(""); policy != PrivateNamespaced || err != nil {
+		t.Errorf("expected private images to be namespaced by default, got %s and %v", policy, err)
+	}
+	if policy, err := ParsePrivateImagePolicy("Shared"); policy != PrivateShared || err != nil {
+		t.Errorf("expected shared to be accepted, got %s and %v", policy, err)
+	}
+	if _, err := ParsePrivateImagePolicy("public"); err == nil {
+		t.Errorf("expected unknown policies to be rejected")
+	}
+}
+
+func mustConfigKeychain(t *testing.T, host, user, password string) authn.Keychain {
+	keychain, err := NewConfigKeychain([]byte(fmt.Sprintf(`{"auths":{%q:{"username":%q,"password":%q}}}`, host, user, password)))
+	if err != nil {
+		t.Fatalf("error occured building keychain: %s", err)
+	}
+
+	return keychain
+}
@@ -0,0 +1,191 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Tiemma/image-clone-controller/pkg/errors"
+	"github.com/Tiemma/image-clone-controller/pkg/metrics"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	containerRegistry "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// ArtifactKind names the artifacts copied alongside images
+type ArtifactKind string
+
+const (
+	// ArtifactSignature is the cosign signature tag sha256-<hex>.sig
+	ArtifactSignature ArtifactKind = "sig"
+	// ArtifactAttestation is the cosign attestation tag sha256-<hex>.att
+	ArtifactAttestation ArtifactKind = "att"
+	// ArtifactSBOM is the cosign SBOM tag sha256-<hex>.sbom
+	ArtifactSBOM ArtifactKind = "sbom"
+	// ArtifactReferrers are the manifests whose subject is the image, listed by the OCI 1.1
+	// referrers API or the sha256-<hex> fallback tag
+	ArtifactReferrers ArtifactKind = "referrers"
+)
+
+// Results reported by the copied artifacts metric
+const (
+	artifactCopied = "copied"
+	artifactFailed = "failed"
+)
+
+var artifactKinds []ArtifactKind
+
+// ParseArtifactKinds parses the COPY_ARTIFACTS list, an empty list copying no artifacts
+func ParseArtifactKinds(kinds []string) ([]ArtifactKind, error) {
+	res := make([]ArtifactKind, 0, len(kinds))
+	for _, kind := range kinds {
+		switch artifactKind := ArtifactKind(strings.ToLower(kind)); artifactKind {
+		case ArtifactSignature, ArtifactAttestation, ArtifactSBOM, ArtifactReferrers:
+			res = append(res, artifactKind)
+		default:
+			return nil, fmt.Errorf("artifacts must be among %s, %s, %s and %s, got %q", ArtifactSignature, ArtifactAttestation, ArtifactSBOM, ArtifactReferrers, kind)
+		}
+	}
+
+	return res, nil
+}
+
+// SetArtifactKinds sets the artifacts copied alongside every image
+func SetArtifactKinds(kinds []ArtifactKind) {
+	artifactKinds = kinds
+}
+
+// artifactTag returns the tag cosign stores the artifacts of digest under, suffix being empty for the referrers fallback tag
+func artifactTag(digest containerRegistry.Hash, suffix ArtifactKind) string {
+	tag := fmt.Sprintf("%s-%s", digest.Algorithm, digest.Hex)
+	if suffix != "" {
+		tag += "." + string(suffix)
+	}
+
+	return tag
+}
+
+// copyArtifacts copies the artifacts of digest, the digest source resolved to and signatures are made over,
+// found next to source into the repository of destination, returning the references written. Artifacts
+// missing from source are skipped. kc authenticates to source
+func copyArtifacts(ctx context.Context, source name.Reference, digest containerRegistry.Hash, destination name.Reference, kc authn.Keychain) ([]string, error) {
+	if len(artifactKinds) == 0 {
+		return nil, nil
+	}
+
+	var copied []string
+	copyManifest := func(kind ArtifactKind, src, dst name.Reference) error {
+		ok, err := copyManifest(src, dst, kc)
+		if err != nil {
+			metrics.UpdateCopiedArtifactsMetric(string(kind), artifactFailed)
+			logger.Error(err, "error occurred copying artifact", "artifact", src.Name())
+			return errors.ErrorCloningImage(destination.Name(), errors.ImageWrite, fmt.Errorf("cannot copy artifact %s: %w", src.Name(), err))
+		}
+		if ok {
+			metrics.UpdateCopiedArtifactsMetric(string(kind), artifactCopied)
+			copied = append(copied, dst.Name())
+		}
+		return nil
+	}
+
+	for _, kind := range artifactKinds {
+		if kind != ArtifactReferrers {
+			tag := artifactTag(digest, kind)
+			if err := copyManifest(kind, source.Context().Tag(tag), destination.Context().Tag(tag)); err != nil {
+				return copied, err
+			}
+			continue
+		}
+
+		referrers, err := listReferrers(ctx, source.Context(), digest, kc)
+		if err != nil {
+			return copied, errors.ErrorCloningImage(destination.Name(), errors.ImageWrite, err)
+		}
+		for _, referrer := range referrers {
+			if err := copyManifest(kind, source.Context().Digest(referrer.String()), destination.Context().Digest(referrer.String())); err != nil {
+				return copied, err
+			}
+		}
+		// Registries without the referrers API list them in an index under the fallback tag
+		tag := artifactTag(digest, "")
+		if err := copyManifest(kind, source.Context().Tag(tag), destination.Context().Tag(tag)); err != nil {
+			return copied, err
+		}
+	}
+
+	return copied, nil
+}
+
+// copyManifest copies the image or index at src, authenticating with kc, to dst, reporting false when src does not exist
+func copyManifest(src, dst name.Reference, kc authn.Keychain) (bool, error) {
+	desc, err := remote.Get(src, remote.WithAuthFromKeychain(kc))
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if desc.MediaType.IsIndex() {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return false, err
+		}
+		return true, remote.WriteIndex(dst, index, getDestinationAuthConfig(dst)...)
+	}
+
+	img, err := desc.Image()
+	if err != nil {
+		return false, err
+	}
+	return true, remote.Write(dst, img, getDestinationAuthConfig(dst)...)
+}
+
+// listReferrers returns the digests of the manifests referring to digest through the OCI 1.1
+// referrers API, registries not supporting it returning none. Other failures such as missing
+// credentials are returned so they are not mistaken for an image without referrers. kc authenticates to repository
+func listReferrers(ctx context.Context, repository name.Repository, digest containerRegistry.Hash, kc authn.Keychain) ([]containerRegistry.Hash, error) {
+	auth, err := kc.Resolve(repository)
+	if err != nil {
+		return nil, err
+	}
+	rt, err := transport.NewWithContext(ctx, repository.Registry, auth, http.DefaultTransport, []string{repository.Scope(transport.PullScope)})
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s://%s/v2/%s/referrers/%s", repository.Registry.Scheme(), repository.RegistryStr(), repository.RepositoryStr(), digest)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", string(types.OCIImageIndex))
+
+	resp, err := (&http.Client{Transport: rt}).Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot list referrers of %s: %s", digest, resp.Status)
+	}
+
+	index := containerRegistry.IndexManifest{}
+	if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+		return nil, fmt.Errorf("cannot parse referrers of %s: %w", digest, err)
+	}
+	referrers := make([]containerRegistry.Hash, 0, len(index.Manifests))
+	for _, manifest := range index.Manifests {
+		referrers = append(referrers, manifest.Digest)
+	}
+
+	return referrers, nil
+}
@@ -0,0 +1,111 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	containerRegistry "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+func TestCopyArtifacts(t *testing.T) {
+	img, _ := random.Image(64, 1)
+	digest, _ := img.Digest()
+	signature, _ := random.Image(16, 1)
+	referrer, _ := random.Image(16, 1)
+	referrerDigest, _ := referrer.Digest()
+
+	reg := registry.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/source/test/referrers/"+digest.String() {
+			reg.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", string(types.OCIImageIndex))
+		_ = json.NewEncoder(w).Encode(containerRegistry.IndexManifest{
+			SchemaVersion: 2,
+			MediaType:     types.OCIImageIndex,
+			Manifests:     []containerRegistry.Descriptor{{MediaType: types.OCIManifestSchema1, Digest: referrerDigest}},
+		})
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	source, _ := name.ParseReference(host + "/source/test:123")
+	destination, _ := name.ParseReference(host + "/destination/test:123")
+	for ref, artifact := range map[name.Reference]containerRegistry.Image{
+		source: img,
+		source.Context().Tag(artifactTag(digest, ArtifactSignature)): signature,
+		source.Context().Digest(referrerDigest.String()):             referrer,
+	} {
+		if err := remote.Write(ref, artifact); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	SetArtifactKinds(nil)
+	if copied, err := copyArtifacts(context.Background(), source, digest, destination, keychain); err != nil || len(copied) != 0 {
+		t.Errorf("expected no artifacts to be copied when disabled, got %v and %v", copied, err)
+	}
+
+	SetArtifactKinds([]ArtifactKind{ArtifactSignature, ArtifactAttestation, ArtifactReferrers})
+	defer SetArtifactKinds(nil)
+	copied, err := copyArtifacts(context.Background(), source, digest, destination, keychain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		destination.Context().Tag(artifactTag(digest, ArtifactSignature)).Name(),
+		destination.Context().Digest(referrerDigest.String()).Name(),
+	}
+	if strings.Join(copied, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected artifacts %v to be copied, got %v", expected, copied)
+	}
+	for _, artifact := range expected {
+		ref, _ := name.ParseReference(artifact)
+		if _, err := remote.Head(ref); err != nil {
+			t.Errorf("expected artifact %s in the destination: %s", artifact, err)
+		}
+	}
+}
+
+func TestParseArtifactKinds(t *testing.T) {
+	if kinds, err := ParseArtifactKinds([]string{"SIG", "referrers"}); err != nil || len(kinds) != 2 || kinds[0] != ArtifactSignature {
+		t.Errorf("expected sig and referrers, got %v and %v", kinds, err)
+	}
+	if _, err := ParseArtifactKinds([]string{"provenance"}); err == nil {
+		t.Errorf("expected unknown artifacts to be rejected")
+	}
+}
+
+func TestListReferrers(t *testing.T) {
+	status := http.StatusNotFound
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/referrers/") {
+			w.WriteHeader(status)
+			return
+		}
+		registry.New().ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	repository, _ := name.NewRepository(strings.TrimPrefix(server.URL, "http://") + "/source/test")
+	img, _ := random.Image(16, 1)
+	digest, _ := img.Digest()
+
+	if referrers, err := listReferrers(context.Background(), repository, digest, keychain); err != nil || len(referrers) != 0 {
+		t.Errorf("expected registries without the referrers API to list none, got %v and %v", referrers, err)
+	}
+
+	status = http.StatusForbidden
+	if _, err := listReferrers(context.Background(), repository, digest, keychain); err == nil {
+		t.Errorf("expected a forbidden referrers listing to fail")
+	}
+}
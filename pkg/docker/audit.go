@@ -0,0 +1,25 @@
+package docker
+
+import (
+	"github.com/Tiemma/image-clone-controller/pkg/errors"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// DestinationDigest returns the digest a cached image currently resolves to,
+// or false when the registry no longer has it
+func DestinationDigest(image string) (string, bool, error) {
+	ref, err := getReference(image)
+	if err != nil {
+		return "", false, errors.ErrorCloningImage(image, errors.ImageReference, err)
+	}
+
+	desc, err := remote.Head(ref, getDestinationAuthConfig(ref)...)
+	if isNotFound(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, errors.ErrorCloningImage(image, errors.ImageManifest, err)
+	}
+
+	return desc.Digest.String(), true, nil
+}
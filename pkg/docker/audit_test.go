@@ -0,0 +1,34 @@
+package docker
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestDestinationDigest(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	img, _ := random.Image(64, 1)
+	digest, _ := img.Digest()
+	ref, _ := name.ParseReference(host + "/kube456/test:123")
+	if err := remote.Write(ref, img); err != nil {
+		t.Errorf("error occured seeding registry: %s", err)
+	}
+
+	got, found, err := DestinationDigest(host + "/kube456/test:123")
+	if err != nil || !found || got != digest.String() {
+		t.Errorf("expected %s, got %s (found %v, err %v)", digest, got, found, err)
+	}
+
+	if _, found, err := DestinationDigest(host + "/kube456/missing:123"); err != nil || found {
+		t.Errorf("expected a deleted image not to be found, got found %v, err %v", found, err)
+	}
+}
@@ -0,0 +1,337 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	DefaultCacheTTL = 24 * time.Hour
+	// DefaultCacheMaxEntries keeps the persisted cache well below the 1 MiB limit of a ConfigMap
+	DefaultCacheMaxEntries = 2000
+
+	cacheConfigMapKey = "cache.json"
+)
+
+// CacheEntry remembers where a source reference was cloned to
+type CacheEntry struct {
+	SourceDigest      string    `json:"sourceDigest"`
+	Destination       string    `json:"destination"`
+	DestinationDigest string    `json:"destinationDigest"`
+	SyncedAt          time.Time `json:"syncedAt"`
+	// Scanned is set when the image passed the scan gate before being cloned
+	Scanned bool `json:"scanned,omitempty"`
+	// Verified is set when the signatures of the source were verified before it was cloned
+	Verified bool `json:"verified,omitempty"`
+	// LaggingReplicas of the destination that failed their last write
+	LaggingReplicas []string `json:"laggingReplicas,omitempty"`
+}
+
+// CacheStore persists the clone cache across restarts
+type CacheStore interface {
+	Load(ctx context.Context) (map[string]CacheEntry, error)
+	Save(ctx context.Context, entries map[string]CacheEntry) error
+}
+
+// Cache maps source references to the digests already cloned for them so
+// known images are not fetched again. Sources cloned to several destination
+// repositories, such as tenant folders, get an entry per repository. Entries
+// for tags expire after the TTL since the tag may have moved, digest references
+// never expire but the least recently synced entries are dropped past maxEntries
+type Cache struct {
+	mu sync.Mutex
+	// entries keyed by cacheKey
+	entries map[string]CacheEntry
+	// saveMu serialises saves so an older snapshot never overwrites a newer one
+	saveMu     sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	store      CacheStore
+	now        func() time.Time
+}
+
+// NewCache returns a cache of at most maxEntries persisted to store, which may be nil to keep it in memory only
+func NewCache(store CacheStore, ttl time.Duration, maxEntries int) *Cache {
+	return &Cache{
+		entries:    map[string]CacheEntry{},
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		store:      store,
+		now:        time.Now,
+	}
+}
+
+var cloneCache = NewCache(nil, DefaultCacheTTL, DefaultCacheMaxEntries)
+
+// SetCache replaces the clone cache consulted before fetching manifests
+func SetCache(c *Cache) {
+	cloneCache = c
+}
+
+// cacheKey identifies the clone of source to the repository of destination
+func cacheKey(source, destination string) string {
+	repository := destination
+	if ref, err := name.ParseReference(destination); err == nil {
+		repository = ref.Context().Name()
+	}
+
+	return source + " " + repository
+}
+
+// sourceOf returns the source reference of key, keys persisted by older versions only holding the source
+func sourceOf(key string) string {
+	return strings.SplitN(key, " ", 2)[0]
+}
+
+func isMutable(ref name.Reference) bool {
+	_, isDigest := ref.(name.Digest)
+	return !isDigest
+}
+
+// Load warms the cache from its store
+func (c *Cache) Load(ctx context.Context) error {
+	if c.store == nil {
+		return nil
+	}
+
+	entries, err := c.store.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range entries {
+		c.entries[cacheKey(sourceOf(key), entry.Destination)] = entry
+	}
+	c.prune()
+	logger.Info(fmt.Sprintf("Loaded %d cached image(s)", len(entries)))
+
+	return nil
+}
+
+// Save persists the cache to its store. Concurrent saves are serialised, each writing every entry known when it starts
+func (c *Cache) Save(ctx context.Context) error {
+	if c.store == nil {
+		return nil
+	}
+
+	c.saveMu.Lock()
+	defer c.saveMu.Unlock()
+
+	return c.store.Save(ctx, c.Entries())
+}
+
+// Lookup returns the entry of source cloned to the repository of destination if it is still fresh
+func (c *Cache) Lookup(source name.Reference, destination string) (CacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[cacheKey(source.Name(), destination)]
+	if !ok {
+		return CacheEntry{}, false
+	}
+	if isMutable(source) && c.now().Sub(entry.SyncedAt) >= c.ttl {
+		return CacheEntry{}, false
+	}
+
+	return entry, true
+}
+
+// Put records that source was cloned to entry.Destination
+func (c *Cache) Put(source name.Reference, entry CacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry.SyncedAt.IsZero() {
+		entry.SyncedAt = c.now()
+	}
+	c.entries[cacheKey(source.Name(), entry.Destination)] = entry
+	c.prune()
+}
+
+// prune drops expired tags, then the least recently synced entries until at most maxEntries are left.
+// Callers must hold the lock
+func (c *Cache) prune() {
+	for key, entry := range c.entries {
+		ref, err := name.ParseReference(sourceOf(key))
+		if err != nil || (isMutable(ref) && c.now().Sub(entry.SyncedAt) >= c.ttl) {
+			delete(c.entries, key)
+		}
+	}
+	if c.maxEntries <= 0 || len(c.entries) <= c.maxEntries {
+		return
+	}
+
+	keys := make([]string, 0, len(c.entries))
+	for key := range c.entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return c.entries[keys[i]].SyncedAt.Before(c.entries[keys[j]].SyncedAt)
+	})
+	for _, key := range keys[:len(keys)-c.maxEntries] {
+		delete(c.entries, key)
+	}
+}
+
+// isLagging reports whether replica is one of the replicas of e that were not written
+func (e CacheEntry) isLagging(replica string) bool {
+	for _, lagging := range e.LaggingReplicas {
+		if lagging == replica {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ReplicaSynced records that replica of every entry cloned to destination was written
+func (c *Cache) ReplicaSynced(destination, replica string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, entry := range c.entries {
+		if entry.Destination != destination || !entry.isLagging(replica) {
+			continue
+		}
+
+		var lagging []string
+		for _, existing := range entry.LaggingReplicas {
+			if existing != replica {
+				lagging = append(lagging, existing)
+			}
+		}
+		entry.LaggingReplicas = lagging
+		c.entries[key] = entry
+	}
+}
+
+// Forget removes every entry cloned to destination
+func (c *Cache) Forget(destination string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, entry := range c.entries {
+		if entry.Destination == destination {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// Entries returns a copy of every entry keyed by source reference and destination repository
+func (c *Cache) Entries() map[string]CacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := make(map[string]CacheEntry, len(c.entries))
+	for key, entry := range c.entries {
+		entries[key] = entry
+	}
+
+	return entries
+}
+
+// FileStore persists the cache as JSON in a local file
+type FileStore struct {
+	Path string
+}
+
+func (s FileStore) Load(_ context.Context) (map[string]CacheEntry, error) {
+	entries := map[string]CacheEntry{}
+	data, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, json.Unmarshal(data, &entries)
+}
+
+func (s FileStore) Save(_ context.Context, entries map[string]CacheEntry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated cache behind
+	tmp := filepath.Join(filepath.Dir(s.Path), "."+filepath.Base(s.Path)+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.Path)
+}
+
+// ConfigMapStore persists the cache as JSON in a ConfigMap
+type ConfigMapStore struct {
+	Client client.Client
+	// Reader reads the ConfigMap directly from the API server as the cache is loaded before informers start
+	Reader client.Reader
+	Key    types.NamespacedName
+}
+
+func (s ConfigMapStore) Load(ctx context.Context) (map[string]CacheEntry, error) {
+	entries := map[string]CacheEntry{}
+	configMap := &corev1.ConfigMap{}
+	if err := s.Reader.Get(ctx, s.Key, configMap); err != nil {
+		if apierrors.IsNotFound(err) {
+			return entries, nil
+		}
+		return nil, err
+	}
+
+	data, ok := configMap.Data[cacheConfigMapKey]
+	if !ok {
+		return entries, nil
+	}
+
+	return entries, json.Unmarshal([]byte(data), &entries)
+}
+
+// Save writes entries to the ConfigMap, retrying when another replica updated or created it meanwhile
+func (s ConfigMapStore) Save(ctx context.Context, entries map[string]CacheEntry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	isConflict := func(err error) bool {
+		return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+	}
+	return retry.OnError(retry.DefaultRetry, isConflict, func() error {
+		configMap := &corev1.ConfigMap{}
+		if err := s.Reader.Get(ctx, s.Key, configMap); err != nil {
+			if !apierrors.IsNotFound(err) {
+				return err
+			}
+
+			configMap.Name, configMap.Namespace = s.Key.Name, s.Key.Namespace
+			configMap.Data = map[string]string{cacheConfigMapKey: string(data)}
+			return s.Client.Create(ctx, configMap)
+		}
+
+		if configMap.Data == nil {
+			configMap.Data = map[string]string{}
+		}
+		configMap.Data[cacheConfigMapKey] = string(data)
+		return s.Client.Update(ctx, configMap)
+	})
+}
@@ -0,0 +1,122 @@
+package docker
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheLookup(t *testing.T) {
+	now := time.Now()
+	cache := NewCache(nil, time.Hour, DefaultCacheMaxEntries)
+	cache.now = func() time.Time { return now }
+
+	tag, _ := getReference("docker.io/kube123/test:123")
+	digest, _ := getReference("docker.io/kube123/test@sha256:b5bb9d8014a0f9b1d61e21e796d78dccdf1352f23cd32812f4850b878ae4944c")
+
+	if _, ok := cache.Lookup(tag, "docker.io/kube456/test:123"); ok {
+		t.Errorf("expected empty cache to miss")
+	}
+
+	cache.Put(tag, CacheEntry{Destination: "docker.io/kube456/test:123"})
+	cache.Put(tag, CacheEntry{Destination: "docker.io/kube456/team-a/test:123"})
+	cache.Put(digest, CacheEntry{Destination: "docker.io/kube456/test@sha256:b5bb"})
+	if entry, ok := cache.Lookup(tag, "docker.io/kube456/test:123"); !ok || entry.Destination != "docker.io/kube456/test:123" {
+		t.Errorf("expected tag to hit, got %v", entry)
+	}
+	if entry, ok := cache.Lookup(tag, "docker.io/kube456/team-a/test:123"); !ok || entry.Destination != "docker.io/kube456/team-a/test:123" {
+		t.Errorf("expected the clone to another destination repository to be kept, got %v", entry)
+	}
+	if _, ok := cache.Lookup(tag, "docker.io/kube456/team-b/test:123"); ok {
+		t.Errorf("expected destination repositories the source was not cloned to to miss")
+	}
+
+	now = now.Add(time.Hour)
+	if _, ok := cache.Lookup(tag, "docker.io/kube456/test:123"); ok {
+		t.Errorf("expected tag to expire after the TTL")
+	}
+	if _, ok := cache.Lookup(digest, "docker.io/kube456/test@sha256:b5bb"); !ok {
+		t.Errorf("expected digest to never expire")
+	}
+}
+
+func TestCachePrune(t *testing.T) {
+	now := time.Now()
+	cache := NewCache(nil, time.Hour, 2)
+	cache.now = func() time.Time { return now }
+
+	tag, _ := getReference("docker.io/kube123/test:123")
+	cache.Put(tag, CacheEntry{Destination: "docker.io/kube456/test:123"})
+	now = now.Add(time.Hour)
+	for _, hex := range []string{"1", "2", "3"} {
+		digest, _ := getReference("docker.io/kube123/test@sha256:" + strings.Repeat(hex, 64))
+		cache.Put(digest, CacheEntry{Destination: "docker.io/kube456/test@sha256:" + hex})
+		now = now.Add(time.Minute)
+	}
+
+	entries := cache.Entries()
+	if len(entries) != 2 {
+		t.Errorf("expected the expired tag and the oldest digest to be pruned, got %v", entries)
+	}
+	if _, ok := entries[cacheKey("index.docker.io/kube123/test@sha256:"+strings.Repeat("1", 64), "docker.io/kube456/test@sha256:1")]; ok {
+		t.Errorf("expected the least recently synced digest to be pruned first")
+	}
+}
+
+func TestCacheReplicaSynced(t *testing.T) {
+	cache := NewCache(nil, time.Hour, DefaultCacheMaxEntries)
+	tag, _ := getReference("docker.io/kube123/test:123")
+	eu, us := "eu.example.com/kube456/test:123", "us.example.com/kube456/test:123"
+	cache.Put(tag, CacheEntry{Destination: "docker.io/kube456/test:123", LaggingReplicas: []string{eu, us}})
+
+	cache.ReplicaSynced("docker.io/kube456/test:123", eu)
+	entry, ok := cache.Lookup(tag, "docker.io/kube456/test:123")
+	if !ok || entry.isLagging(eu) || !entry.isLagging(us) {
+		t.Errorf("expected only the synced replica to stop lagging, got %v", entry.LaggingReplicas)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Errorf("error occured creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := FileStore{Path: filepath.Join(dir, "cache.json")}
+	tag, _ := getReference("docker.io/kube123/test:123")
+
+	cache := NewCache(store, time.Hour, DefaultCacheMaxEntries)
+	if err := cache.Load(context.Background()); err != nil {
+		t.Errorf("expected missing file to load an empty cache, got %s", err)
+	}
+	cache.Put(tag, CacheEntry{SourceDigest: "sha256:abc", Destination: "docker.io/kube456/test:123"})
+	if err := cache.Save(context.Background()); err != nil {
+		t.Errorf("error occured saving cache: %s", err)
+	}
+
+	warm := NewCache(store, time.Hour, DefaultCacheMaxEntries)
+	if err := warm.Load(context.Background()); err != nil {
+		t.Errorf("error occured loading cache: %s", err)
+	}
+	if entry, ok := warm.Lookup(tag, "docker.io/kube456/test:123"); !ok || entry.SourceDigest != "sha256:abc" {
+		t.Errorf("expected cache to survive a restart, got %v", entry)
+	}
+
+	// Entries persisted by older versions are keyed by source only
+	legacy := map[string]CacheEntry{tag.Name(): {SourceDigest: "sha256:abc", Destination: "docker.io/kube456/test:123", SyncedAt: time.Now()}}
+	if err := store.Save(context.Background(), legacy); err != nil {
+		t.Errorf("error occured saving cache: %s", err)
+	}
+	migrated := NewCache(store, time.Hour, DefaultCacheMaxEntries)
+	if err := migrated.Load(context.Background()); err != nil {
+		t.Errorf("error occured loading cache: %s", err)
+	}
+	if _, ok := migrated.Lookup(tag, "docker.io/kube456/test:123"); !ok {
+		t.Errorf("expected entries keyed by source only to be loaded")
+	}
+}
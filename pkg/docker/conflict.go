@@ -0,0 +1,100 @@
+package docker
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Tiemma/image-clone-controller/pkg/errors"
+	"github.com/Tiemma/image-clone-controller/pkg/metrics"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// TagConflictPolicy decides what happens when the destination tag already holds a different image
+type TagConflictPolicy string
+
+const (
+	// ConflictOverwrite replaces the existing image
+	ConflictOverwrite TagConflictPolicy = "overwrite"
+	// ConflictSkip keeps the existing image and leaves the container on its upstream image
+	ConflictSkip TagConflictPolicy = "skip"
+	// ConflictFail fails the clone with an IMAGE_TAG_CONFLICT error
+	ConflictFail TagConflictPolicy = "fail"
+	// ConflictSuffix writes to <tag>-<short digest> and rewrites the container to it
+	ConflictSuffix TagConflictPolicy = "suffix"
+
+	DefaultTagConflictPolicy = ConflictOverwrite
+
+	shortDigestLength = 12
+)
+
+var tagConflictPolicy = DefaultTagConflictPolicy
+
+func ParseTagConflictPolicy(str string) (TagConflictPolicy, error) {
+	switch policy := TagConflictPolicy(str); policy {
+	case "":
+		return DefaultTagConflictPolicy, nil
+	case ConflictOverwrite, ConflictSkip, ConflictFail, ConflictSuffix:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("tag conflict policy must be one of %s, %s, %s or %s, got %q",
+			ConflictOverwrite, ConflictSkip, ConflictFail, ConflictSuffix, str)
+	}
+}
+
+// SetTagConflictPolicy sets the policy applied when a destination tag holds a different image
+func SetTagConflictPolicy(policy TagConflictPolicy) {
+	tagConflictPolicy = policy
+}
+
+func isNotFound(err error) bool {
+	transportErr, ok := err.(*transport.Error)
+	return ok && transportErr.StatusCode == http.StatusNotFound
+}
+
+// resolveTagConflict checks whether cacheURL already holds an image other than img and applies
+// the tag conflict policy. It returns the url img should be written to, or false when it must not be written
+func resolveTagConflict(image, cacheURL string, img clonedManifest) (string, bool, error) {
+	cacheRef, err := getReference(cacheURL)
+	if err != nil {
+		return "", false, errors.ErrorCloningImage(image, errors.ImageReference, err)
+	}
+	if _, isTag := cacheRef.(name.Tag); !isTag {
+		// Digests cannot point at another image
+		return cacheURL, true, nil
+	}
+
+	existing, err := remote.Head(cacheRef, getDestinationAuthConfig(cacheRef)...)
+	if isNotFound(err) {
+		return cacheURL, true, nil
+	}
+	if err != nil {
+		return "", false, errors.ErrorCloningImage(cacheURL, errors.ImageWrite, fmt.Errorf("cannot check existing destination tag: %w", err))
+	}
+
+	digest, err := img.Digest()
+	if err != nil {
+		return "", false, errors.ErrorCloningImage(image, errors.ImageManifest, err)
+	}
+	if existing.Digest == digest {
+		return cacheURL, true, nil
+	}
+
+	metrics.UpdateTagConflictsMetric(string(tagConflictPolicy))
+	conflict := fmt.Sprintf("destination %s holds %s instead of %s", cacheURL, existing.Digest, digest)
+	switch tagConflictPolicy {
+	case ConflictSkip:
+		logger.Info(fmt.Sprintf("Tag conflict, %s, keeping it and leaving %s upstream", conflict, image))
+		return image, false, nil
+	case ConflictFail:
+		return "", false, errors.ErrorCloningImage(image, errors.ImageTagConflict, fmt.Errorf("%s", conflict))
+	case ConflictSuffix:
+		suffixed := fmt.Sprintf("%s-%s", cacheURL, digest.Hex[:shortDigestLength])
+		logger.Info(fmt.Sprintf("Tag conflict, %s, writing to %s instead", conflict, suffixed))
+		return suffixed, true, nil
+	default:
+		logger.Info(fmt.Sprintf("Tag conflict, %s, overwriting it", conflict))
+		return cacheURL, true, nil
+	}
+}
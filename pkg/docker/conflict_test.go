@@ -0,0 +1,64 @@
+package docker
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Tiemma/image-clone-controller/pkg/errors"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestResolveTagConflict(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	existing, _ := random.Image(64, 1)
+	img, _ := random.Image(64, 1)
+	digest, _ := img.Digest()
+
+	cacheURL := host + "/kube456/test:123"
+	ref, _ := name.ParseReference(cacheURL)
+	if err := remote.Write(ref, existing); err != nil {
+		t.Errorf("error occured seeding registry: %s", err)
+	}
+	defer SetTagConflictPolicy(DefaultTagConflictPolicy)
+
+	tests := []struct {
+		policy   TagConflictPolicy
+		cacheURL string
+		url      string
+		write    bool
+		errType  errors.ErrType
+	}{
+		{policy: ConflictFail, cacheURL: host + "/kube456/missing:123", url: host + "/kube456/missing:123", write: true},
+		{policy: ConflictOverwrite, cacheURL: cacheURL, url: cacheURL, write: true},
+		{policy: ConflictSkip, cacheURL: cacheURL, url: "test:123", write: false},
+		{policy: ConflictFail, cacheURL: cacheURL, errType: errors.ImageTagConflict},
+		{policy: ConflictSuffix, cacheURL: cacheURL, url: cacheURL + "-" + digest.Hex[:12], write: true},
+	}
+
+	for _, test := range tests {
+		SetTagConflictPolicy(test.policy)
+		url, write, err := resolveTagConflict("test:123", test.cacheURL, img)
+		if errors.TypeOf(err) != test.errType {
+			t.Errorf("expected error type %q with policy %s, got %v", test.errType, test.policy, err)
+		}
+		if url != test.url || write != test.write {
+			t.Errorf("expected %s (write %v) with policy %s, got %s (write %v)", test.url, test.write, test.policy, url, write)
+		}
+	}
+}
+
+func TestParseTagConflictPolicy(t *testing.T) {
+	if policy, err := ParseTagConflictPolicy(""); err != nil || policy != DefaultTagConflictPolicy {
+		t.Errorf("expected %s, got %s (%v)", DefaultTagConflictPolicy, policy, err)
+	}
+	if _, err := ParseTagConflictPolicy("rename"); err == nil {
+		t.Errorf("expected an error for an unknown policy")
+	}
+}
@@ -1,16 +1,20 @@
 package docker
 
 import (
+	"context"
 	"fmt"
 	"github.com/Tiemma/image-clone-controller/pkg/env"
 	"github.com/Tiemma/image-clone-controller/pkg/errors"
 	"github.com/Tiemma/image-clone-controller/pkg/metrics"
+	"github.com/Tiemma/image-clone-controller/pkg/routing"
+	"github.com/Tiemma/image-clone-controller/pkg/signature"
 	"github.com/google/go-containerregistry/pkg/authn"
+	"io/ioutil"
 	"os"
-	"strings"
 
 	"github.com/google/go-containerregistry/pkg/name"
 	containerRegistry "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/cache"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"golang.org/x/mod/semver"
 	v1 "k8s.io/api/core/v1"
@@ -18,30 +22,89 @@ import (
 )
 
 var (
-	repoURL                                   = os.Getenv(env.RepoURL)
+	routes                                    = routing.Default(os.Getenv(env.RepoURL))
+	keychain                                  = authn.NewMultiKeychain(newRouteKeychain(routes), authn.DefaultKeychain)
 	ephemeralContainerMinimumSupportedVersion = "v1.16"
+	topologyRegionLabel                       = "topology.kubernetes.io/region"
 
 	logger = ctrl.Log.WithValues("pkg", "docker")
 )
 
-func getAuthConfig() []remote.Option {
+// SetRoutes replaces the routing table deciding where images are cloned to
+func SetRoutes(table *routing.Table) {
+	routes = table
+	keychain = authn.NewMultiKeychain(newRouteKeychain(table), authn.DefaultKeychain)
+}
+
+// destinationKeychainFor returns the credentials of the destination ref, the ones of the tenant owning ref if any
+func destinationKeychainFor(ref name.Reference) authn.Keychain {
+	if tenantKeychain, ok := tenantKeychainOf(ref); ok {
+		return tenantKeychain
+	}
+
+	return keychain
+}
+
+// getAuthConfig authenticates to the registry of the source ref with the controller-wide credentials
+func getAuthConfig(ref name.Reference) []remote.Option {
 	return []remote.Option{
-		remote.WithAuthFromKeychain(authn.DefaultKeychain),
+		remote.WithAuthFromKeychain(keychain),
 	}
 }
 
-func getImageManifest(ref name.Reference) (containerRegistry.Image, error) {
-	img, err := remote.Image(ref, getAuthConfig()...)
+// getDestinationAuthConfig authenticates to the registry of the destination ref, with the credentials of
+// the tenant owning ref if any. Sources never use the credentials of tenants, even when in their destination
+func getDestinationAuthConfig(ref name.Reference) []remote.Option {
+	return []remote.Option{
+		remote.WithAuthFromKeychain(destinationKeychainFor(ref)),
+	}
+}
+
+// getImageManifest returns what ref resolves to along with its digest, the whole index
+// for multi platform images so every platform gets cloned. options authenticate to ref
+func getImageManifest(ref name.Reference, options ...remote.Option) (clonedManifest, containerRegistry.Hash, error) {
+	desc, err := remote.Get(ref, options...)
 	if err != nil {
 		logger.Error(err, "error occurred getting manifest")
-		return nil, err
+		return nil, containerRegistry.Hash{}, err
+	}
+
+	var m clonedManifest
+	if desc.MediaType.IsIndex() {
+		m, err = desc.ImageIndex()
+	} else {
+		m, err = desc.Image()
+	}
+	if err != nil {
+		logger.Error(err, "error occurred getting manifest")
+		return nil, containerRegistry.Hash{}, err
 	}
 
-	return img, err
+	return m, desc.Digest, nil
 }
 
-func isAlreadyCached(image string) bool {
-	isCached := strings.Contains(image, repoURL)
+// IsCacheURL reports whether image points at the destination of any route or at the one of the tenant of namespace
+func IsCacheURL(image, namespace string) bool {
+	return routes.IsDestination(image) || isTenantDestination(image, namespace)
+}
+
+// isSettled reports whether image needs no cloning, either because it is cached
+// or because its route leaves it where it is
+func isSettled(image, namespace string) bool {
+	if IsCacheURL(image, namespace) {
+		return true
+	}
+
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return false
+	}
+	_, routed := routes.Destination(ref, namespace)
+	return !routed
+}
+
+func isAlreadyCached(image, namespace string) bool {
+	isCached := IsCacheURL(image, namespace)
 	if isCached {
 		logger.Info(fmt.Sprintf("Image %s is already cached, ignoring...", image))
 	}
@@ -49,69 +112,251 @@ func isAlreadyCached(image string) bool {
 	return isCached
 }
 
-func MustCacheAndModifyPodImage(podSpec *v1.PodSpec, k8sVersion string) (string, errors.ErrType) {
-	images := map[name.Reference]remote.Taggable{}
+// IsPodSpecCached reports whether every image of podSpec already points at the cache
+// or is routed nowhere, letting callers skip the registry entirely
+func IsPodSpecCached(podSpec *v1.PodSpec, namespace, k8sVersion string) bool {
+	for _, c := range podSpec.Containers {
+		if !isSettled(c.Image, namespace) {
+			return false
+		}
+	}
+
+	if semver.Compare(k8sVersion, ephemeralContainerMinimumSupportedVersion) == 1 {
+		for _, ec := range podSpec.EphemeralContainers {
+			if !isSettled(ec.Image, namespace) {
+				return false
+			}
+		}
+	}
+
+	for _, ic := range podSpec.InitContainers {
+		if !isSettled(ic.Image, namespace) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// placement describes where the workload whose images are cloned runs
+type placement struct {
+	namespace string
+	region    string
+	// tenant of the namespace, nil when namespaces are not isolated
+	tenant *Tenant
+	// pullKeychain holds the credentials of the pull secrets of the workload
+	pullKeychain authn.Keychain
+	// pullSecrets are the names of the pull secrets of the workload
+	pullSecrets []string
+}
+
+// routeOf returns the route of ref for workloads of p, or false when ref stays where it is
+func routeOf(ref name.Reference, p placement) (routing.Route, bool, error) {
+	route, routed := routes.Match(ref, p.namespace)
+	if !routed || route.Skip {
+		return route, false, nil
+	}
+	if p.tenant == nil {
+		return route, true, nil
+	}
+
+	route, err := route.ForTenant(p.namespace, p.tenant.Destination)
+	return route, err == nil, err
+}
+
+// cacheImage resolves the manifest of image and queues it for caching, returning the url
+// the image would be available at once cached for a workload placed at p. Images already
+// cached are added to hits instead
+func cacheImage(image string, p placement, images map[name.Reference]cloneJob, hits map[string]ImageUse) (string, error) {
+	ref, err := getReference(image)
+	if err != nil {
+		return "", errors.ErrorCloningImage(image, errors.ImageReference, err)
+	}
+
+	route, routed, err := routeOf(ref, p)
+	if err != nil {
+		return "", errors.Permanent(errors.Config, image, err)
+	}
+	if !routed {
+		logger.Info(fmt.Sprintf("Image %s is not routed to any destination, ignoring...", image))
+		return image, nil
+	}
+	route, access, allowed, err := authorizeSource(ref, route, p)
+	if err != nil || !allowed {
+		return image, err
+	}
+	cacheURL := route.URL(ref)
+
+	if entry, ok := cloneCache.Lookup(ref, cacheURL); ok && entry.gated(route.Verifier()) {
+		// Workloads pinned to a replica that was not written yet clone it again, the others use the cache
+		if selected := route.Select(entry.Destination, p.region); !entry.isLagging(selected) {
+			logger.Info(fmt.Sprintf("Image %s was already cloned to %s, skipping...", image, entry.Destination))
+			use := ImageUse{Destination: entry.Destination, Authorized: access == AccessRestricted}
+			if use.Authorized && privateImagePolicy == PrivateAuthorized {
+				use.PullSecrets = p.pullSecrets
+			}
+			hits[entry.Destination] = use
+			return selected, nil
+		}
+	}
+
+	img, digest, err := getImageManifest(ref, getAuthConfig(ref)...)
+	if err != nil {
+		return "", errors.ErrorCloningImage(image, errors.ImageManifest, err)
+	}
+
+	cacheURL, write, err := resolveTagConflict(image, cacheURL, img)
+	if err != nil || !write {
+		return cacheURL, err
+	}
+
+	cacheRef, err := getReference(cacheURL)
+	if err != nil {
+		return "", errors.ErrorCloningImage(image, errors.ImageReference, err)
+	}
+	job := cloneJob{source: ref, sourceDigest: digest, image: img, access: access, verifier: route.Verifier()}
+	if access == AccessRestricted && privateImagePolicy == PrivateAuthorized {
+		job.pullSecrets = p.pullSecrets
+	}
+	for _, replicaURL := range route.ReplicaURLs(cacheURL) {
+		replicaRef, err := getReference(replicaURL)
+		if err != nil {
+			return "", errors.ErrorCloningImage(image, errors.ImageReference, err)
+		}
+		job.replicas = append(job.replicas, replicaRef)
+	}
+
+	selected := route.Select(cacheURL, p.region)
+	if selected != cacheURL {
+		if job.selected, err = getReference(selected); err != nil {
+			return "", errors.ErrorCloningImage(image, errors.ImageReference, err)
+		}
+	}
+	images[cacheRef] = job
+
+	return selected, nil
+}
+
+// gated reports whether the image of entry went through the gates applied to new clones, entries cloned
+// before a gate was enabled being cloned again so the gate applies to them too. verifier is the
+// signature policy of the route of the source, nil when signatures are not checked
+func (e CacheEntry) gated(verifier *signature.Verifier) bool {
+	return (scanner == nil || e.Scanned) && (verifier == nil || e.Verified)
+}
+
+// Workload identifies the workload images are cloned for
+type Workload struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+// cloneJob is an image read from its source waiting to be written to the cache
+type cloneJob struct {
+	source name.Reference
+	// sourceDigest is the digest source resolved to, signatures being made over it
+	sourceDigest containerRegistry.Hash
+	image        clonedManifest
+	// verifier of the signatures of source, nil when they are not checked
+	verifier *signature.Verifier
+	// replicas the image is also written to
+	replicas []name.Reference
+	// selected is the replica the workload is rewritten to, nil for the primary destination
+	selected name.Reference
+	// access of the source, empty when unchecked
+	access string
+	// pullSecrets of the workload found able to pull the restricted source
+	pullSecrets []string
+	// artifacts copied alongside the image and the error that stopped copying them
+	artifacts    []string
+	artifactsErr error
+}
+
+// regionOf returns the region the pods of podSpec are pinned to through their
+// node selector or required node affinity, or an empty string
+func regionOf(podSpec *v1.PodSpec) string {
+	if region, ok := podSpec.NodeSelector[topologyRegionLabel]; ok {
+		return region
+	}
+
+	affinity := podSpec.Affinity
+	if affinity == nil || affinity.NodeAffinity == nil || affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return ""
+	}
+	for _, term := range affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		for _, expr := range term.MatchExpressions {
+			if expr.Key == topologyRegionLabel && expr.Operator == v1.NodeSelectorOpIn && len(expr.Values) == 1 {
+				return expr.Values[0]
+			}
+		}
+	}
+
+	return ""
+}
+
+func MustCacheAndModifyPodImage(ctx context.Context, workload Workload, podSpec *v1.PodSpec, k8sVersion string) error {
+	tenant, err := resolveTenant(ctx, workload.Namespace)
+	if err != nil {
+		return err
+	}
+	secretNames := make([]string, 0, len(podSpec.ImagePullSecrets))
+	for _, secret := range podSpec.ImagePullSecrets {
+		secretNames = append(secretNames, secret.Name)
+	}
+	keychain, err := pullKeychain(ctx, workload.Namespace, secretNames)
+	if err != nil {
+		return err
+	}
+	p := placement{namespace: workload.Namespace, region: regionOf(podSpec), tenant: tenant, pullKeychain: keychain, pullSecrets: secretNames}
+	images := map[name.Reference]cloneJob{}
+	hits := map[string]ImageUse{}
 
 	// Duplicate images are not a problem since their tags would make them differ
 	// as opposed to an overwrite if it were only the image url
 	for idx, c := range podSpec.Containers {
-		if isAlreadyCached(c.Image) {
+		if isAlreadyCached(c.Image, workload.Namespace) {
 			continue
 		}
 
-		ref, err := getReference(c.Image)
-		if err != nil {
-			return c.Image, errors.ImageReference
-		}
-
-		img, err := getImageManifest(ref)
+		cacheURL, err := cacheImage(c.Image, p, images, hits)
 		if err != nil {
-			return c.Image, errors.ImageManifest
+			return err
 		}
-
-		images[getCacheImageReference(ref)] = img
-		podSpec.Containers[idx].Image = getCacheImageURL(ref)
+		podSpec.Containers[idx].Image = cacheURL
 	}
 
 	if semver.Compare(k8sVersion, ephemeralContainerMinimumSupportedVersion) == 1 {
 		for idx, ec := range podSpec.EphemeralContainers {
-			if isAlreadyCached(ec.Image) {
+			if isAlreadyCached(ec.Image, workload.Namespace) {
 				continue
 			}
 
-			ref, err := getReference(ec.Image)
+			cacheURL, err := cacheImage(ec.Image, p, images, hits)
 			if err != nil {
-				return ec.Image, errors.ImageReference
+				return err
 			}
-
-			img, err := getImageManifest(ref)
-			if err != nil {
-				return ec.Image, errors.ImageManifest
-			}
-			images[getCacheImageReference(ref)] = img
-			podSpec.EphemeralContainers[idx].Image = getCacheImageURL(ref)
+			podSpec.EphemeralContainers[idx].Image = cacheURL
 		}
 	}
 
 	for idx, ic := range podSpec.InitContainers {
-		if isAlreadyCached(ic.Image) {
+		if isAlreadyCached(ic.Image, workload.Namespace) {
 			continue
 		}
 
-		ref, err := getReference(ic.Image)
+		cacheURL, err := cacheImage(ic.Image, p, images, hits)
 		if err != nil {
-			return ic.Image, errors.ImageReference
+			return err
 		}
+		podSpec.InitContainers[idx].Image = cacheURL
+	}
 
-		img, err := getImageManifest(ref)
-		if err != nil {
-			return ic.Image, errors.ImageManifest
-		}
-		images[getCacheImageReference(ref)] = img
-		podSpec.EphemeralContainers[idx].Image = getCacheImageURL(ref)
+	if err := mustCacheImages(ctx, workload, images); err != nil {
+		return err
 	}
+	recordUses(ctx, workload, hits)
 
-	return mustCacheImages(images)
+	return nil
 }
 
 func getReference(image string) (name.Reference, error) {
@@ -124,40 +369,96 @@ func getReference(image string) (name.Reference, error) {
 	return ref, err
 }
 
-func getCacheImageURL(ref name.Reference) string {
-	imageURLParts := strings.Split(ref.Name(), "/")
-
-	// Pick the last end of it being the image name and tag without the repository
-	return fmt.Sprintf("%s/%s", repoURL, imageURLParts[len(imageURLParts)-1])
-}
-
-func getCacheImageReference(ref name.Reference) name.Reference {
-	ref, err := getReference(getCacheImageURL(ref))
-
-	// Panic in this case as cache url should always work
-	errors.HandleErr(err)
+// writeImage writes img to ref, provisioning its repository first, and checks it can be read back
+func writeImage(ctx context.Context, ref name.Reference, img clonedManifest) error {
+	if err := provision(ctx, ref); err != nil {
+		return err
+	}
+	if err := writeManifest(ref, img, getDestinationAuthConfig(ref)...); err != nil {
+		logger.Error(err, "error occurred writing images")
+		return errors.ErrorCloningImage(ref.Name(), errors.ImageWrite, err)
+	}
+	if err := verifyWrite(ref, img); err != nil {
+		logger.Error(err, "error occurred verifying written image")
+		return err
+	}
 
-	return ref
+	return nil
 }
 
-func mustCacheImages(images map[name.Reference]remote.Taggable) (string, errors.ErrType) {
+func mustCacheImages(ctx context.Context, workload Workload, images map[name.Reference]cloneJob) error {
 	imageCount := len(images)
 	if len(images) == 0 {
 		logger.Info("No new images found")
 
-		return "", ""
+		return nil
 	}
 
-	logger.Info(fmt.Sprintf("Caching %d image(s): %s", imageCount, images))
+	refs := make([]string, 0, imageCount)
+	for ref := range images {
+		refs = append(refs, ref.Name())
+	}
+	logger.Info(fmt.Sprintf("Caching %d image(s): %s", imageCount, refs))
 
-	for ref, img := range images {
-		err := remote.Write(ref, img.(containerRegistry.Image), getAuthConfig()...)
-		if err != nil {
-			logger.Error(err, "error occurred writing images")
-			return ref.Name(), errors.ImageWrite
+	// Staged or replicated images are read from the source once, their blobs
+	// being kept on disk until every destination was written
+	blobDir, err := ioutil.TempDir("", "image-clone-")
+	if err != nil {
+		return errors.ErrorCloningImage("", errors.ImageWrite, err)
+	}
+	defer os.RemoveAll(blobDir)
+
+	for ref, job := range images {
+		if err := verifySignatures(job); err != nil {
+			return err
+		}
+		if len(job.replicas) > 0 || scanner != nil {
+			job.image = withBlobCache(job.image, cache.NewFilesystemCache(blobDir))
+		}
+
+		if err := stageAndScan(ctx, ref, job.image); err != nil {
+			return err
+		}
+		if err := writeImage(ctx, ref, job.image); err != nil {
+			return err
 		}
 		metrics.ImageCloneTotal.Add(1)
+		job.artifacts, job.artifactsErr = copyArtifacts(ctx, job.source, job.sourceDigest, ref, keychain)
+		recordClone(ctx, workload, ref, job)
+		if job.artifactsErr != nil {
+			return job.artifactsErr
+		}
+
+		// The primary destination is cached even when replicas lag, those being retried on their own
+		lagging, err := replicate(ctx, ref, job)
+		cacheClone(ref, job, lagging)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := cloneCache.Save(ctx); err != nil {
+		logger.Error(err, "error occurred persisting clone cache")
+	}
+
+	return nil
+}
+
+// cacheClone remembers the image of job written to destination so it is not fetched again,
+// along with the replicas that could not be written yet
+func cacheClone(destination name.Reference, job cloneJob, lagging []string) {
+	digest, err := job.image.Digest()
+	if err != nil {
+		logger.Error(err, "error occurred getting digest of cloned image", "image", destination.Name())
+		return
 	}
 
-	return "", ""
+	cloneCache.Put(job.source, CacheEntry{
+		SourceDigest:      job.sourceDigest.String(),
+		Destination:       destination.Name(),
+		DestinationDigest: digest.String(),
+		Scanned:           scanner != nil,
+		Verified:          job.verifier != nil,
+		LaggingReplicas:   lagging,
+	})
 }
@@ -5,10 +5,15 @@ import (
 	"os"
 	"strings"
 	"testing"
+
+	"github.com/Tiemma/image-clone-controller/pkg/routing"
+	v1 "k8s.io/api/core/v1"
 )
 
+const repoURL = "docker.io/kube456"
+
 func TestMain(m *testing.M) {
-	repoURL = "docker.io/kube456"
+	SetRoutes(routing.Default(repoURL))
 
 	// Run tests
 	code := m.Run()
@@ -36,7 +41,7 @@ func TestGetReference(t *testing.T) {
 	}
 }
 
-func TestGetCacheImageURL(t *testing.T) {
+func TestCacheImageURL(t *testing.T) {
 	specs := []struct {
 		img      string
 		expected string
@@ -51,9 +56,32 @@ func TestGetCacheImageURL(t *testing.T) {
 			t.Errorf("error occured getting reference: %s", err)
 		}
 
-		res := getCacheImageURL(ref)
+		res, _ := routes.Destination(ref, "default")
 		if res != spec.expected {
 			t.Errorf("expected %s, got %s", spec.expected, res)
 		}
 	}
 }
+
+func TestIsPodSpecCached(t *testing.T) {
+	specs := []struct {
+		podSpec  v1.PodSpec
+		expected bool
+	}{
+		{podSpec: v1.PodSpec{Containers: []v1.Container{{Image: fmt.Sprintf("%s/test:123", repoURL)}}}, expected: true},
+		{podSpec: v1.PodSpec{Containers: []v1.Container{{Image: "docker.io/kube123/test:123"}}}, expected: false},
+		{
+			podSpec: v1.PodSpec{
+				Containers:     []v1.Container{{Image: fmt.Sprintf("%s/test:123", repoURL)}},
+				InitContainers: []v1.Container{{Image: "docker.io/kube123/init:123"}},
+			},
+			expected: false,
+		},
+	}
+
+	for _, spec := range specs {
+		if res := IsPodSpecCached(&spec.podSpec, "default", "v1.19.2"); res != spec.expected {
+			t.Errorf("expected %t for %v, got %t", spec.expected, spec.podSpec, res)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package docker
+
+import (
+	"context"
+
+	"github.com/Tiemma/image-clone-controller/pkg/errors"
+	"github.com/google/go-containerregistry/pkg/name"
+	containerRegistry "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// DeleteImage deletes the manifest of a cached image through the registry API and forgets
+// it was cloned. Manifests are deleted by digest since most registries refuse deleting tags.
+// The artifacts copied alongside the image are deleted with it
+func DeleteImage(ctx context.Context, destination, digest string) error {
+	ref, err := getReference(destination)
+	if err != nil {
+		return errors.ErrorCloningImage(destination, errors.ImageReference, err)
+	}
+	hash, err := containerRegistry.NewHash(digest)
+	if err != nil {
+		return errors.ErrorCloningImage(destination, errors.ImageReference, err)
+	}
+
+	// Referrers can only be listed while their subject exists
+	artifacts := artifactsOf(ctx, ref.Context(), hash)
+
+	target := ref.Context().Digest(digest)
+	if err := remote.Delete(target, getDestinationAuthConfig(target)...); err != nil && !isNotFound(err) {
+		return errors.ErrorCloningImage(destination, errors.ImageDelete, err)
+	}
+	for _, artifact := range artifacts {
+		if err := remote.Delete(artifact, getDestinationAuthConfig(artifact)...); err != nil && !isNotFound(err) {
+			logger.Error(err, "error occurred deleting artifact", "image", destination, "artifact", artifact.Name())
+		}
+	}
+
+	cloneCache.Forget(ref.Name())
+	if err := cloneCache.Save(ctx); err != nil {
+		logger.Error(err, "error occurred persisting clone cache")
+	}
+
+	return nil
+}
+
+// artifactsOf returns the digests of the cosign artifacts and referrers of digest found in repository.
+// Failures are only logged, leaving artifacts behind rather than keeping the image
+func artifactsOf(ctx context.Context, repository name.Repository, digest containerRegistry.Hash) []name.Digest {
+	var artifacts []name.Digest
+	for _, kind := range []ArtifactKind{ArtifactSignature, ArtifactAttestation, ArtifactSBOM, ""} {
+		tag := repository.Tag(artifactTag(digest, kind))
+		desc, err := remote.Head(tag, getDestinationAuthConfig(tag)...)
+		if err != nil {
+			if !isNotFound(err) {
+				logger.Error(err, "error occurred looking up artifact", "artifact", tag.Name())
+			}
+			continue
+		}
+		artifacts = append(artifacts, repository.Digest(desc.Digest.String()))
+	}
+
+	referrers, err := listReferrers(ctx, repository, digest, destinationKeychainFor(repository.Digest(digest.String())))
+	if err != nil {
+		logger.Error(err, "error occurred listing referrers", "image", repository.Digest(digest.String()).Name())
+	}
+	for _, referrer := range referrers {
+		artifacts = append(artifacts, repository.Digest(referrer.String()))
+	}
+
+	return artifacts
+}
@@ -0,0 +1,128 @@
+package docker
+
+import (
+	"context"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// CloneRecord describes an image written to the cache
+type CloneRecord struct {
+	Source            string
+	Destination       string
+	SourceDigest      string
+	DestinationDigest string
+	MediaType         string
+	Size              int64
+	Platforms         []string
+	// Access of the source, public or restricted, empty when unchecked
+	Access string
+	// Authorized is set when the workload was allowed to use the restricted source, either through
+	// its pull secrets or by getting a copy in its own namespace folder
+	Authorized bool
+	// PullSecrets of the workload that were found able to pull the restricted source
+	PullSecrets []string
+	// Artifacts such as signatures copied alongside the image
+	Artifacts []string
+	// ArtifactsError of the last failed copy of the artifacts
+	ArtifactsError string
+	// Replicas the image is written to besides the destination, tenant folders included
+	Replicas []string
+}
+
+// ImageUse is a workload rewritten to an image already in the cache
+type ImageUse struct {
+	Destination string
+	// Authorized is set when the workload was allowed to use the restricted source, like for CloneRecord
+	Authorized bool
+	// PullSecrets of the workload that were found able to pull the restricted source
+	PullSecrets []string
+}
+
+// Inventory keeps track of the images written to the cache
+type Inventory interface {
+	Record(ctx context.Context, record CloneRecord, workload Workload) error
+	// RecordUse adds workload to the users of the image cloned to use.Destination, if recorded
+	RecordUse(ctx context.Context, use ImageUse, workload Workload) error
+	// RecordReplicas updates the replication status of the image written to destination
+	RecordReplicas(ctx context.Context, destination string, replicas []ReplicaRecord) error
+}
+
+var inventory Inventory
+
+// SetInventory registers where successful clones get recorded
+func SetInventory(i Inventory) {
+	inventory = i
+}
+
+// newCloneRecord describes the clone of job, whose manifest is written unchanged to destination
+func newCloneRecord(destination name.Reference, job cloneJob) (CloneRecord, error) {
+	record := CloneRecord{
+		Source:       job.source.Name(),
+		Destination:  destination.Name(),
+		SourceDigest: job.sourceDigest.String(),
+	}
+
+	digest, err := job.image.Digest()
+	if err != nil {
+		return record, err
+	}
+	record.DestinationDigest = digest.String()
+
+	mediaType, err := job.image.MediaType()
+	if err != nil {
+		return record, err
+	}
+	record.MediaType = string(mediaType)
+
+	if record.Size, err = manifestSize(job.image); err != nil {
+		return record, err
+	}
+	if record.Platforms, err = manifestPlatforms(job.image); err != nil {
+		return record, err
+	}
+
+	return record, nil
+}
+
+// recordClone adds a written image to the inventory. Failures are only logged
+// since the image itself was cloned successfully
+func recordClone(ctx context.Context, workload Workload, destination name.Reference, job cloneJob) {
+	if inventory == nil {
+		return
+	}
+
+	record, err := newCloneRecord(destination, job)
+	if err != nil {
+		logger.Error(err, "error occurred describing cloned image", "image", destination.Name())
+		return
+	}
+	record.Access = job.access
+	record.Authorized = job.access == AccessRestricted
+	record.PullSecrets = job.pullSecrets
+	record.Artifacts = job.artifacts
+	for _, replica := range job.replicas {
+		record.Replicas = append(record.Replicas, replica.Name())
+	}
+	if job.artifactsErr != nil {
+		record.ArtifactsError = job.artifactsErr.Error()
+	}
+
+	if err := inventory.Record(ctx, record, workload); err != nil {
+		logger.Error(err, "error occurred recording cloned image", "image", destination.Name())
+	}
+}
+
+// recordUses adds workload to the users of the images it was rewritten to from the cache.
+// Failures are only logged like those of recordClone
+func recordUses(ctx context.Context, workload Workload, uses map[string]ImageUse) {
+	if inventory == nil {
+		return
+	}
+
+	for _, use := range uses {
+		if err := inventory.RecordUse(ctx, use, workload); err != nil {
+			logger.Error(err, "error occurred recording cached image use", "image", use.Destination)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package docker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/Tiemma/image-clone-controller/pkg/routing"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// dockerHubAuthKey is the key docker uses for Docker Hub credentials in config files
+const dockerHubAuthKey = "https://index.docker.io/v1/"
+
+// dockerConfig holds the credentials of a docker config.json
+type dockerConfig struct {
+	Auths map[string]authn.AuthConfig `json:"auths"`
+}
+
+// parseDockerConfig reads the credentials of a docker config.json keyed by registry host
+func parseDockerConfig(data []byte) (map[string]authn.AuthConfig, error) {
+	config := dockerConfig{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+
+	auths := map[string]authn.AuthConfig{}
+	for key, auth := range config.Auths {
+		if key == dockerHubAuthKey {
+			key = name.DefaultRegistry
+		}
+		// Keys may be urls such as https://registry.example.com/v2/
+		key = strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+		key = strings.SplitN(key, "/", 2)[0]
+		if reg, err := name.NewRegistry(key); err == nil {
+			key = reg.RegistryStr()
+		}
+		auths[key] = auth
+	}
+
+	return auths, nil
+}
+
+// configKeychain authenticates with the auths of a docker config.json
+type configKeychain struct {
+	auths map[string]authn.AuthConfig
+}
+
+// NewConfigKeychain authenticates with the auths of the docker config.json held in data
+func NewConfigKeychain(data []byte) (authn.Keychain, error) {
+	auths, err := parseDockerConfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return configKeychain{auths: auths}, nil
+}
+
+func (kc configKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	auth, ok := kc.auths[target.RegistryStr()]
+	if !ok {
+		return authn.Anonymous, nil
+	}
+
+	return authn.FromConfig(auth), nil
+}
+
+// routeKeychain authenticates to route destinations with the docker config of their route.
+// Credential helpers are not supported, only the auths of the config
+type routeKeychain struct {
+	// configs maps destination prefixes to the folder of their docker config
+	configs map[string]string
+}
+
+// newRouteKeychain authenticates with the docker config of the route or replica of each destination prefix
+func newRouteKeychain(table *routing.Table) routeKeychain {
+	return routeKeychain{configs: table.DockerConfigs()}
+}
+
+// configOf returns the docker config folder of the longest destination prefix holding target.
+// Only repositories can be told apart, registries get no route credentials
+func (kc routeKeychain) configOf(target authn.Resource) (string, bool) {
+	repository, ok := target.(name.Repository)
+	if !ok {
+		return "", false
+	}
+
+	dir, longest := "", 0
+	for prefix, config := range kc.configs {
+		if len(prefix) > longest && routing.RepositoryWithin(repository, prefix) {
+			dir, longest = config, len(prefix)
+		}
+	}
+
+	return dir, dir != ""
+}
+
+func (kc routeKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	dir, ok := kc.configOf(target)
+	if !ok {
+		return authn.Anonymous, nil
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return nil, err
+	}
+	config, err := NewConfigKeychain(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return config.Resolve(target)
+}
@@ -0,0 +1,72 @@
+package docker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Tiemma/image-clone-controller/pkg/routing"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+func TestParseDockerConfig(t *testing.T) {
+	config := []byte(`{"auths": {
+		"https://index.docker.io/v1/": {"auth": "aHViOnNlY3JldA=="},
+		"https://harbor.example.com/v2/": {"username": "robot", "password": "secret"},
+		"gcr.io": {"auth": "X2pzb25fa2V5Ons9"}
+	}}`)
+
+	auths, err := parseDockerConfig(config)
+	if err != nil {
+		t.Errorf("error occured parsing docker config: %s", err)
+	}
+
+	if auths["index.docker.io"].Auth != "aHViOnNlY3JldA==" {
+		t.Errorf("expected docker hub credentials under index.docker.io, got %v", auths)
+	}
+	if auths["harbor.example.com"].Username != "robot" {
+		t.Errorf("expected harbor credentials under harbor.example.com, got %v", auths)
+	}
+	if auths["gcr.io"].Auth != "X2pzb25fa2V5Ons9" {
+		t.Errorf("expected gcr credentials under gcr.io, got %v", auths)
+	}
+}
+
+func TestRouteKeychain(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "route-keychain")
+	defer os.RemoveAll(dir)
+	var routes []routing.Route
+	for _, project := range []string{"team-a", "team-b"} {
+		configDir := filepath.Join(dir, project)
+		_ = os.Mkdir(configDir, 0700)
+		config := fmt.Sprintf(`{"auths": {"harbor.example.com": {"username": "robot$%s", "password": "secret"}}}`, project)
+		if err := ioutil.WriteFile(filepath.Join(configDir, "config.json"), []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+		routes = append(routes, routing.Route{Prefix: project + "/", Destination: "harbor.example.com/" + project, DockerConfig: configDir})
+	}
+	table, err := routing.New(routes, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kc := newRouteKeychain(table)
+	for _, project := range []string{"team-a", "team-b"} {
+		repository, _ := name.NewRepository("harbor.example.com/" + project + "/nginx")
+		auth, err := kc.Resolve(repository)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config, _ := auth.Authorization(); config.Username != "robot$"+project {
+			t.Errorf("expected the robot account of %s, got %s", project, config.Username)
+		}
+	}
+
+	other, _ := name.NewRepository("harbor.example.com/team-c/nginx")
+	if auth, _ := kc.Resolve(other); auth != authn.Anonymous {
+		t.Errorf("expected repositories outside of every route destination to be anonymous")
+	}
+}
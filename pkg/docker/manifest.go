@@ -0,0 +1,202 @@
+package docker
+
+import (
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	containerRegistry "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/cache"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// clonedManifest is what a reference resolves to, the index of a multi platform image or a single image.
+// It is written unchanged so the destination resolves to the same digest as the source
+type clonedManifest interface {
+	Digest() (containerRegistry.Hash, error)
+	MediaType() (types.MediaType, error)
+	Size() (int64, error)
+}
+
+// unknownPlatform is how attestation manifests of an index are listed
+const unknownPlatform = "unknown/unknown"
+
+// writeManifest writes m to ref, along with every image of m when it is an index
+func writeManifest(ref name.Reference, m clonedManifest, options ...remote.Option) error {
+	switch m := m.(type) {
+	case containerRegistry.ImageIndex:
+		return remote.WriteIndex(ref, m, options...)
+	case containerRegistry.Image:
+		return remote.Write(ref, m, options...)
+	default:
+		return fmt.Errorf("cannot write manifest of type %T", m)
+	}
+}
+
+// manifestImages returns the images of m, every image of nested indexes included
+func manifestImages(m clonedManifest) ([]containerRegistry.Image, error) {
+	switch m := m.(type) {
+	case containerRegistry.Image:
+		return []containerRegistry.Image{m}, nil
+	case containerRegistry.ImageIndex:
+		manifest, err := m.IndexManifest()
+		if err != nil {
+			return nil, err
+		}
+
+		var images []containerRegistry.Image
+		for _, desc := range manifest.Manifests {
+			var child clonedManifest
+			switch {
+			case desc.MediaType.IsIndex():
+				child, err = m.ImageIndex(desc.Digest)
+			case desc.MediaType.IsImage():
+				child, err = m.Image(desc.Digest)
+			default:
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			childImages, err := manifestImages(child)
+			if err != nil {
+				return nil, err
+			}
+			images = append(images, childImages...)
+		}
+		return images, nil
+	default:
+		return nil, fmt.Errorf("cannot read images of manifest of type %T", m)
+	}
+}
+
+// manifestPlatforms returns the os/architecture of every image of m, attestations left out
+func manifestPlatforms(m clonedManifest) ([]string, error) {
+	index, ok := m.(containerRegistry.ImageIndex)
+	if !ok {
+		img, ok := m.(containerRegistry.Image)
+		if !ok {
+			return nil, fmt.Errorf("cannot read platforms of manifest of type %T", m)
+		}
+		config, err := img.ConfigFile()
+		if err != nil {
+			return nil, err
+		}
+		return []string{platformString(config.OS, config.Architecture, "")}, nil
+	}
+
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	var platforms []string
+	for _, desc := range manifest.Manifests {
+		if desc.Platform == nil {
+			continue
+		}
+		if platform := platformString(desc.Platform.OS, desc.Platform.Architecture, desc.Platform.Variant); platform != unknownPlatform {
+			platforms = append(platforms, platform)
+		}
+	}
+
+	return platforms, nil
+}
+
+func platformString(goos, architecture, variant string) string {
+	if variant == "" {
+		return fmt.Sprintf("%s/%s", goos, architecture)
+	}
+
+	return fmt.Sprintf("%s/%s/%s", goos, architecture, variant)
+}
+
+// manifestSize returns the size of m along with every manifest, config and layer it references
+func manifestSize(m clonedManifest) (int64, error) {
+	size, err := m.Size()
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := m.(containerRegistry.ImageIndex); ok {
+		// Indexes only add the size of their own manifest to the images below
+		images, err := manifestImages(m)
+		if err != nil {
+			return 0, err
+		}
+		for _, img := range images {
+			imgSize, err := manifestSize(img)
+			if err != nil {
+				return 0, err
+			}
+			size += imgSize
+		}
+		return size, nil
+	}
+
+	manifest, err := m.(containerRegistry.Image).Manifest()
+	if err != nil {
+		return 0, err
+	}
+	size += manifest.Config.Size
+	for _, layer := range manifest.Layers {
+		size += layer.Size
+	}
+
+	return size, nil
+}
+
+// withBlobCache keeps the blobs of m in c once read, so writing m to several destinations reads them once
+func withBlobCache(m clonedManifest, c cache.Cache) clonedManifest {
+	switch m := m.(type) {
+	case containerRegistry.ImageIndex:
+		return cachedIndex{index: m, cache: c}
+	case containerRegistry.Image:
+		return cache.Image(m, c)
+	default:
+		return m
+	}
+}
+
+// cachedIndex is an index whose images keep their blobs in cache
+type cachedIndex struct {
+	index containerRegistry.ImageIndex
+	cache cache.Cache
+}
+
+func (i cachedIndex) MediaType() (types.MediaType, error) {
+	return i.index.MediaType()
+}
+
+func (i cachedIndex) Digest() (containerRegistry.Hash, error) {
+	return i.index.Digest()
+}
+
+func (i cachedIndex) Size() (int64, error) {
+	return i.index.Size()
+}
+
+func (i cachedIndex) IndexManifest() (*containerRegistry.IndexManifest, error) {
+	return i.index.IndexManifest()
+}
+
+func (i cachedIndex) RawManifest() ([]byte, error) {
+	return i.index.RawManifest()
+}
+
+func (i cachedIndex) Image(h containerRegistry.Hash) (containerRegistry.Image, error) {
+	img, err := i.index.Image(h)
+	if err != nil {
+		return nil, err
+	}
+
+	return cache.Image(img, i.cache), nil
+}
+
+func (i cachedIndex) ImageIndex(h containerRegistry.Hash) (containerRegistry.ImageIndex, error) {
+	index, err := i.index.ImageIndex(h)
+	if err != nil {
+		return nil, err
+	}
+
+	return cachedIndex{index: index, cache: i.cache}, nil
+}
@@ -0,0 +1,74 @@
+package docker
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	containerRegistry "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/cache"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// multiPlatformIndex returns an index of random linux/amd64 and linux/arm64 images
+func multiPlatformIndex(t *testing.T) containerRegistry.ImageIndex {
+	var addenda []mutate.IndexAddendum
+	for _, arch := range []string{"amd64", "arm64"} {
+		img, err := random.Image(64, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addenda = append(addenda, mutate.IndexAddendum{
+			Add:        img,
+			Descriptor: containerRegistry.Descriptor{Platform: &containerRegistry.Platform{OS: "linux", Architecture: arch}},
+		})
+	}
+
+	return mutate.AppendManifests(empty.Index, addenda...)
+}
+
+func TestCloneIndex(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	dir, _ := ioutil.TempDir("", "image-clone-")
+	defer os.RemoveAll(dir)
+	SetVerifyBlobs(true)
+	defer SetVerifyBlobs(false)
+
+	source, _ := name.ParseReference(host + "/source/test:123")
+	destination, _ := name.ParseReference(host + "/kube456/test:123")
+	if err := remote.WriteIndex(source, multiPlatformIndex(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	m, digest, err := getImageManifest(source, getAuthConfig(source)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeImage(context.Background(), destination, withBlobCache(m, cache.NewFilesystemCache(dir))); err != nil {
+		t.Fatalf("expected the index to be written, got %s", err)
+	}
+	if written, err := remote.Head(destination); err != nil || written.Digest != digest {
+		t.Errorf("expected the destination to resolve to the source index %s, got %v and %v", digest, written, err)
+	}
+
+	record, err := newCloneRecord(destination, cloneJob{source: source, sourceDigest: digest, image: m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.SourceDigest != digest.String() || record.DestinationDigest != digest.String() {
+		t.Errorf("expected both digests to be the index %s, got %s and %s", digest, record.SourceDigest, record.DestinationDigest)
+	}
+	if strings.Join(record.Platforms, ",") != "linux/amd64,linux/arm64" {
+		t.Errorf("expected both platforms to be recorded, got %v", record.Platforms)
+	}
+}
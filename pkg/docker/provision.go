@@ -0,0 +1,65 @@
+package docker
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Tiemma/image-clone-controller/pkg/errors"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// Provisioner creates the destination repository of an image before it is written, registries
+// such as Harbor or ECR rejecting pushes to repositories that do not exist yet. Provisioning a
+// repository that already exists must succeed
+type Provisioner interface {
+	Provision(ctx context.Context, repository name.Repository, auth authn.Authenticator) error
+}
+
+var (
+	provisioner Provisioner
+
+	// provisioned remembers the repositories already provisioned since the controller started
+	provisioned   = map[string]bool{}
+	provisionedMu sync.Mutex
+)
+
+// SetProvisioner registers how destination repositories are created, nil expects them to exist
+func SetProvisioner(p Provisioner) {
+	provisioner = p
+
+	provisionedMu.Lock()
+	defer provisionedMu.Unlock()
+	provisioned = map[string]bool{}
+}
+
+// provision creates the repository of ref unless it was already provisioned
+func provision(ctx context.Context, ref name.Reference) error {
+	if provisioner == nil {
+		return nil
+	}
+
+	repository := ref.Context()
+	provisionedMu.Lock()
+	done := provisioned[repository.Name()]
+	provisionedMu.Unlock()
+	if done {
+		return nil
+	}
+
+	auth, err := destinationKeychainFor(ref).Resolve(repository)
+	if err != nil {
+		return errors.ErrorCloningImage(ref.Name(), errors.RepositoryProvision, err)
+	}
+
+	if err := provisioner.Provision(ctx, repository, auth); err != nil {
+		logger.Error(err, "error occurred provisioning repository", "repository", repository.Name())
+		return errors.ErrorCloningImage(ref.Name(), errors.RepositoryProvision, err)
+	}
+
+	provisionedMu.Lock()
+	provisioned[repository.Name()] = true
+	provisionedMu.Unlock()
+
+	return nil
+}
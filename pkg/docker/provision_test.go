@@ -0,0 +1,47 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Tiemma/image-clone-controller/pkg/errors"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// countingProvisioner counts provisioned repositories, refusing the ones listed in refuse
+type countingProvisioner struct {
+	calls  map[string]int
+	refuse map[string]bool
+}
+
+func (c *countingProvisioner) Provision(_ context.Context, repository name.Repository, _ authn.Authenticator) error {
+	c.calls[repository.Name()]++
+	if c.refuse[repository.Name()] {
+		return fmt.Errorf("refused")
+	}
+
+	return nil
+}
+
+func TestProvision(t *testing.T) {
+	p := &countingProvisioner{calls: map[string]int{}, refuse: map[string]bool{"harbor.example.com/kube456/refused": true}}
+	SetProvisioner(p)
+	defer SetProvisioner(nil)
+
+	for _, image := range []string{"harbor.example.com/kube456/nginx:1", "harbor.example.com/kube456/nginx:2"} {
+		ref, _ := name.ParseReference(image)
+		if err := provision(context.Background(), ref); err != nil {
+			t.Errorf("error occured provisioning %s: %s", image, err)
+		}
+	}
+	if calls := p.calls["harbor.example.com/kube456/nginx"]; calls != 1 {
+		t.Errorf("expected the repository to be provisioned once, got %d", calls)
+	}
+
+	ref, _ := name.ParseReference("harbor.example.com/kube456/refused:1")
+	if err := provision(context.Background(), ref); errors.TypeOf(err) != errors.RepositoryProvision {
+		t.Errorf("expected a %s error, got %v", errors.RepositoryProvision, err)
+	}
+}
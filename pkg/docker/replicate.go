@@ -0,0 +1,104 @@
+package docker
+
+import (
+	"context"
+
+	"github.com/Tiemma/image-clone-controller/pkg/errors"
+	"github.com/Tiemma/image-clone-controller/pkg/metrics"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+const (
+	replicationSynced = "synced"
+	replicationFailed = "failed"
+)
+
+// ReplicaRecord describes the outcome of writing an image to a replica destination
+type ReplicaRecord struct {
+	Destination       string
+	DestinationDigest string
+	Err               error
+}
+
+// replicate writes the image of job to each of its replicas, returning the ones that failed.
+// Only a failure to write the replica the workload is rewritten to is returned, the others
+// are recorded and retried on their own
+func replicate(ctx context.Context, primary name.Reference, job cloneJob) ([]string, error) {
+	if len(job.replicas) == 0 {
+		return nil, nil
+	}
+
+	digest, err := job.image.Digest()
+	if err != nil {
+		return nil, errors.ErrorCloningImage(primary.Name(), errors.ImageWrite, err)
+	}
+
+	var lagging []string
+	var selectedErr error
+	records := make([]ReplicaRecord, 0, len(job.replicas))
+	for _, replica := range job.replicas {
+		record := ReplicaRecord{Destination: replica.Name()}
+		err := writeImage(ctx, replica, job.image)
+		if err == nil {
+			// Artifacts were copied to the primary destination already
+			_, err = copyArtifacts(ctx, primary, job.sourceDigest, replica, destinationKeychainFor(primary))
+		}
+		if err != nil {
+			logger.Error(err, "error occurred replicating image", "image", primary.Name(), "replica", replica.Name())
+			metrics.UpdateReplicationsMetric(replica.Context().RegistryStr(), replicationFailed)
+			record.Err = err
+			lagging = append(lagging, replica.Name())
+			if job.selected != nil && job.selected.Name() == replica.Name() {
+				selectedErr = err
+			}
+		} else {
+			metrics.UpdateReplicationsMetric(replica.Context().RegistryStr(), replicationSynced)
+			record.DestinationDigest = digest.String()
+		}
+		records = append(records, record)
+	}
+	recordReplicas(ctx, primary.Name(), records)
+
+	return lagging, selectedErr
+}
+
+// Replicate copies the image cached at primary to replica, reading it back from
+// the primary destination so upstream is not contacted again
+func Replicate(ctx context.Context, primary, replica string) error {
+	primaryRef, err := getReference(primary)
+	if err != nil {
+		return errors.ErrorCloningImage(primary, errors.ImageReference, err)
+	}
+	replicaRef, err := getReference(replica)
+	if err != nil {
+		return errors.ErrorCloningImage(replica, errors.ImageReference, err)
+	}
+
+	img, digest, err := getImageManifest(primaryRef, getDestinationAuthConfig(primaryRef)...)
+	if err != nil {
+		return errors.ErrorCloningImage(primary, errors.ImageManifest, err)
+	}
+
+	if _, err := replicate(ctx, primaryRef, cloneJob{source: primaryRef, sourceDigest: digest, image: img, replicas: []name.Reference{replicaRef}, selected: replicaRef}); err != nil {
+		return err
+	}
+
+	cloneCache.ReplicaSynced(primaryRef.Name(), replicaRef.Name())
+	if err := cloneCache.Save(ctx); err != nil {
+		logger.Error(err, "error occurred persisting clone cache")
+	}
+
+	return nil
+}
+
+// recordReplicas updates the replication status of destination in the inventory,
+// failures are only logged
+func recordReplicas(ctx context.Context, destination string, replicas []ReplicaRecord) {
+	if inventory == nil {
+		return
+	}
+
+	if err := inventory.RecordReplicas(ctx, destination, replicas); err != nil {
+		logger.Error(err, "error occurred recording replicas", "image", destination)
+	}
+}
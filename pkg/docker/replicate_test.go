@@ -0,0 +1,61 @@
+package docker
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestReplicate(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	unreachable := httptest.NewServer(registry.New())
+	unreachableHost := strings.TrimPrefix(unreachable.URL, "http://")
+	unreachable.Close()
+
+	img, _ := random.Image(64, 1)
+	primary, _ := name.ParseReference(host + "/primary/test:123")
+	replica, _ := name.ParseReference(host + "/replica/test:123")
+	lagging, _ := name.ParseReference(unreachableHost + "/replica/test:123")
+
+	job := cloneJob{source: primary, image: img, replicas: []name.Reference{replica, lagging}}
+	failed, err := replicate(context.Background(), primary, job)
+	if err != nil || len(failed) != 1 || failed[0] != lagging.Name() {
+		t.Errorf("expected a lagging replica not to fail the clone, got %v lagging and %v", failed, err)
+	}
+	if _, err := remote.Head(replica); err != nil {
+		t.Errorf("expected image to be replicated: %s", err)
+	}
+
+	job.selected = lagging
+	if _, err := replicate(context.Background(), primary, job); err == nil {
+		t.Errorf("expected failing to write the selected replica to fail the clone")
+	}
+}
+
+func TestRegionOf(t *testing.T) {
+	specs := map[string]v1.PodSpec{
+		"":          {},
+		"eu-west-1": {NodeSelector: map[string]string{topologyRegionLabel: "eu-west-1"}},
+		"us-east-1": {Affinity: &v1.Affinity{NodeAffinity: &v1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{{
+				MatchExpressions: []v1.NodeSelectorRequirement{{Key: topologyRegionLabel, Operator: v1.NodeSelectorOpIn, Values: []string{"us-east-1"}}},
+			}}},
+		}}},
+	}
+
+	for expected, spec := range specs {
+		if res := regionOf(&spec); res != expected {
+			t.Errorf("expected region %q, got %q", expected, res)
+		}
+	}
+}
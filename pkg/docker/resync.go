@@ -0,0 +1,93 @@
+package docker
+
+import (
+	"context"
+
+	"github.com/Tiemma/image-clone-controller/pkg/errors"
+	"github.com/Tiemma/image-clone-controller/pkg/signature"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// UpstreamDigest returns the digest source currently resolves to upstream.
+// Only the manifest is HEADed so checking does not count as a pull
+func UpstreamDigest(source string) (string, error) {
+	ref, err := getReference(source)
+	if err != nil {
+		return "", errors.ErrorCloningImage(source, errors.ImageReference, err)
+	}
+
+	desc, err := remote.Head(ref, getAuthConfig(ref)...)
+	if err != nil {
+		return "", errors.ErrorCloningImage(source, errors.ImageManifest, err)
+	}
+
+	return desc.Digest.String(), nil
+}
+
+// Recopy clones the upstream image of source over destination and its replicas, which are
+// the ones recorded when first cloned since routes cannot tell the tenant folders apart. A
+// non empty digest pins source to the image recorded when cloned rather than its current one.
+// The source must pass the signature policy of the routes it is matched by in every namespace
+// of its workloads, the ones matching every namespace when none is known
+func Recopy(ctx context.Context, source, digest, destination string, namespaces, replicas []string) error {
+	ref, err := getReference(source)
+	if err != nil {
+		return errors.ErrorCloningImage(source, errors.ImageReference, err)
+	}
+	pinned := ref
+	if digest != "" {
+		pinned = ref.Context().Digest(digest)
+	}
+	cacheRef, err := getReference(destination)
+	if err != nil {
+		return errors.ErrorCloningImage(destination, errors.ImageReference, err)
+	}
+
+	img, sourceDigest, err := getImageManifest(pinned, getAuthConfig(pinned)...)
+	if err != nil {
+		return errors.ErrorCloningImage(pinned.Name(), errors.ImageManifest, err)
+	}
+
+	// The source keeps its tag so the ClonedImage and cache entry stay keyed by it
+	job := cloneJob{source: ref, sourceDigest: sourceDigest, image: img}
+	for _, verifier := range sourceVerifiers(ref, namespaces) {
+		if job.verifier == nil {
+			// Checked along with the write, marking the cached copy as verified
+			job.verifier = verifier
+			continue
+		}
+		if err := verifySignatures(cloneJob{source: ref, sourceDigest: sourceDigest, verifier: verifier}); err != nil {
+			return err
+		}
+	}
+	for _, replicaURL := range replicas {
+		replicaRef, err := getReference(replicaURL)
+		if err != nil {
+			return errors.ErrorCloningImage(replicaURL, errors.ImageReference, err)
+		}
+		job.replicas = append(job.replicas, replicaRef)
+	}
+
+	return mustCacheImages(ctx, Workload{}, map[name.Reference]cloneJob{cacheRef: job})
+}
+
+// sourceVerifiers returns the distinct signature policies of the routes matching ref in namespaces
+func sourceVerifiers(ref name.Reference, namespaces []string) []*signature.Verifier {
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+
+	var verifiers []*signature.Verifier
+	seen := map[*signature.Verifier]bool{}
+	for _, namespace := range namespaces {
+		route, ok := routes.Match(ref, namespace)
+		if !ok || route.Skip || route.Verifier() == nil || seen[route.Verifier()] {
+			continue
+		}
+		seen[route.Verifier()] = true
+		verifiers = append(verifiers, route.Verifier())
+	}
+
+	return verifiers
+}
@@ -0,0 +1,47 @@
+package docker
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestRecopyPinned(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	source, _ := name.ParseReference(host + "/source/test:123")
+	destination, _ := name.ParseReference(host + "/kube456/test:123")
+	cloned, _ := random.Image(64, 1)
+	rebuilt, _ := random.Image(64, 1)
+	if err := remote.Write(source, cloned); err != nil {
+		t.Fatal(err)
+	}
+	clonedDigest, _ := cloned.Digest()
+	// The tag moved upstream after the image was cloned
+	if err := remote.Write(source, rebuilt); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Recopy(context.Background(), source.Name(), clonedDigest.String(), destination.Name(), nil, nil); err != nil {
+		t.Errorf("error occured recopying image: %s", err)
+	}
+	if desc, err := remote.Head(destination); err != nil || desc.Digest != clonedDigest {
+		t.Errorf("expected the recorded source digest to be cloned again, got %v (%v)", desc, err)
+	}
+
+	rebuiltDigest, _ := rebuilt.Digest()
+	if err := Recopy(context.Background(), source.Name(), "", destination.Name(), nil, nil); err != nil {
+		t.Errorf("error occured recopying image: %s", err)
+	}
+	if desc, err := remote.Head(destination); err != nil || desc.Digest != rebuiltDigest {
+		t.Errorf("expected the current upstream image to be cloned without digest, got %v (%v)", desc, err)
+	}
+}
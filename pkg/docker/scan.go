@@ -0,0 +1,218 @@
+package docker
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Tiemma/image-clone-controller/pkg/errors"
+	"github.com/Tiemma/image-clone-controller/pkg/metrics"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// Severity of a finding, ordered from least to most severe
+type Severity int
+
+const (
+	SeverityUnknown Severity = iota
+	SeverityLow
+	SeverityMedium
+	SeverityHigh
+	SeverityCritical
+
+	DefaultSeverityThreshold = SeverityCritical
+)
+
+var severityNames = []string{"UNKNOWN", "LOW", "MEDIUM", "HIGH", "CRITICAL"}
+
+func (s Severity) String() string {
+	if s < SeverityUnknown || s > SeverityCritical {
+		return severityNames[SeverityUnknown]
+	}
+
+	return severityNames[s]
+}
+
+// ParseSeverity parses a severity name such as HIGH, unknown names being SeverityUnknown
+func ParseSeverity(str string) Severity {
+	for idx, severityName := range severityNames {
+		if strings.EqualFold(str, severityName) {
+			return Severity(idx)
+		}
+	}
+
+	return SeverityUnknown
+}
+
+// ParseSeverityThreshold parses a SCAN_SEVERITY_THRESHOLD value, defaulting to CRITICAL
+func ParseSeverityThreshold(str string) (Severity, error) {
+	if str == "" {
+		return DefaultSeverityThreshold, nil
+	}
+
+	severity := ParseSeverity(str)
+	if severity == SeverityUnknown {
+		return 0, fmt.Errorf("scan severity threshold must be one of %s, got %q", strings.Join(severityNames[SeverityLow:], ", "), str)
+	}
+
+	return severity, nil
+}
+
+// Finding is an issue reported by a scanner
+type Finding struct {
+	ID       string
+	Package  string
+	Severity Severity
+	Title    string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s %s (%s)", f.Severity, f.ID, f.Package)
+}
+
+// Scanner reports the findings of the image at ref, authenticating to its registry with auth
+type Scanner interface {
+	Scan(ctx context.Context, ref string, auth authn.Authenticator) ([]Finding, error)
+}
+
+// ScanError lists the findings that kept an image from being promoted
+type ScanError struct {
+	Image     string
+	Threshold Severity
+	Findings  []Finding
+}
+
+func (e *ScanError) Error() string {
+	return fmt.Sprintf("image %s has %d finding(s) of severity %s or above", e.Image, len(e.Findings), e.Threshold)
+}
+
+// ScanFindings returns the findings that failed the scan of err, if it is a scan failure
+func ScanFindings(err error) ([]Finding, bool) {
+	var scanErr *ScanError
+	if stderrors.As(err, &scanErr) {
+		return scanErr.Findings, true
+	}
+
+	return nil, false
+}
+
+// Results reported by the scans metric
+const (
+	scanPassed = "passed"
+	scanFailed = "failed"
+	scanError  = "error"
+)
+
+var (
+	scanner           Scanner
+	stagingRepository string
+	severityThreshold = DefaultSeverityThreshold
+
+	// stagedUses counts the scans in progress on every staged copy, keyed by digest reference
+	stagedUses   = map[string]int{}
+	stagedUsesMu sync.Mutex
+)
+
+// SetScanGate stages every image in stagingRepo and only promotes it to its destination once
+// scanned without findings at or above threshold. A nil scanner disables the gate
+func SetScanGate(s Scanner, stagingRepo string, threshold Severity) {
+	scanner, stagingRepository, severityThreshold = s, strings.TrimSuffix(stagingRepo, "/"), threshold
+}
+
+// blocking returns the findings at or above the threshold
+func blocking(findings []Finding, threshold Severity) []Finding {
+	var res []Finding
+	for _, finding := range findings {
+		if finding.Severity >= threshold {
+			res = append(res, finding)
+		}
+	}
+
+	return res
+}
+
+// stageAndScan writes img to the staging repository and scans it there, failing with an ImageScan
+// error when findings reach the threshold. Staged images are kept on failure for inspection
+func stageAndScan(ctx context.Context, destination name.Reference, img clonedManifest) error {
+	if scanner == nil {
+		return nil
+	}
+
+	// The whole destination path is kept so images sharing a name in different repositories do not share a staged copy
+	path := strings.TrimPrefix(destination.Name(), destination.Context().RegistryStr()+"/")
+	staged, err := getReference(fmt.Sprintf("%s/%s", stagingRepository, path))
+	if err != nil {
+		return errors.ErrorCloningImage(destination.Name(), errors.ImageReference, err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		return errors.ErrorCloningImage(destination.Name(), errors.ImageScan, err)
+	}
+	stagedDigest := staged.Context().Digest(digest.String())
+
+	// Concurrent clones of the same image share its staged copy, only the last one done deletes it
+	acquireStaged(stagedDigest)
+	passed := false
+	defer func() {
+		if last := releaseStaged(stagedDigest); !last || !passed {
+			return
+		}
+		// The staged copy is not needed once promoted, deleting it keeps the staging repository small
+		if err := remote.Delete(stagedDigest, getDestinationAuthConfig(staged)...); err != nil && !isNotFound(err) {
+			logger.Error(err, "error occurred deleting staged image", "image", staged.Name())
+		}
+	}()
+
+	if err := writeImage(ctx, staged, img); err != nil {
+		return err
+	}
+
+	auth, err := destinationKeychainFor(staged).Resolve(staged.Context())
+	if err != nil {
+		return errors.ErrorCloningImage(destination.Name(), errors.ImageScan, err)
+	}
+	findings, err := scanner.Scan(ctx, staged.Name(), auth)
+	if err != nil {
+		metrics.UpdateScansMetric(scanError)
+		logger.Error(err, "error occurred scanning image", "image", staged.Name())
+		// A staged copy deleted meanwhile is not a scan failure, it is staged again on retry
+		if _, headErr := remote.Head(stagedDigest, getDestinationAuthConfig(staged)...); isNotFound(headErr) {
+			return errors.ErrorCloningImage(destination.Name(), errors.ImageWrite, fmt.Errorf("staged image %s was deleted before being scanned: %w", staged.Name(), err))
+		}
+		return errors.ErrorCloningImage(destination.Name(), errors.ImageScan, err)
+	}
+	if failed := blocking(findings, severityThreshold); len(failed) > 0 {
+		metrics.UpdateScansMetric(scanFailed)
+		return errors.Permanent(errors.ImageScan, destination.Name(), &ScanError{Image: staged.Name(), Threshold: severityThreshold, Findings: failed})
+	}
+	metrics.UpdateScansMetric(scanPassed)
+	passed = true
+
+	return nil
+}
+
+// acquireStaged records that a scan uses the staged copy staged
+func acquireStaged(staged name.Digest) {
+	stagedUsesMu.Lock()
+	defer stagedUsesMu.Unlock()
+
+	stagedUses[staged.Name()]++
+}
+
+// releaseStaged records that a scan is done with the staged copy staged, reporting whether it was the last one using it
+func releaseStaged(staged name.Digest) bool {
+	stagedUsesMu.Lock()
+	defer stagedUsesMu.Unlock()
+
+	stagedUses[staged.Name()]--
+	if stagedUses[staged.Name()] > 0 {
+		return false
+	}
+	delete(stagedUses, staged.Name())
+
+	return true
+}
@@ -0,0 +1,133 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Tiemma/image-clone-controller/pkg/errors"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// staticScanner reports the same findings for every image, remembering what it scanned
+type staticScanner struct {
+	findings []Finding
+	scanned  []string
+}
+
+func (s *staticScanner) Scan(_ context.Context, ref string, _ authn.Authenticator) ([]Finding, error) {
+	s.scanned = append(s.scanned, ref)
+	return s.findings, nil
+}
+
+func TestStageAndScan(t *testing.T) {
+	var deletes []string
+	handler := registry.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			deletes = append(deletes, r.URL.Path)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	img, _ := random.Image(64, 1)
+	destination, _ := name.ParseReference(host + "/kube456/test:123")
+	staged, _ := name.ParseReference(host + "/staging/kube456/test:123")
+	defer SetScanGate(nil, "", DefaultSeverityThreshold)
+
+	s := &staticScanner{findings: []Finding{{ID: "CVE-2021-1", Severity: SeverityHigh}, {ID: "CVE-2021-2", Severity: SeverityCritical}}}
+	SetScanGate(s, host+"/staging", SeverityCritical)
+	err := stageAndScan(context.Background(), destination, img)
+	if errors.TypeOf(err) != errors.ImageScan || errors.IsRetryable(err) {
+		t.Errorf("expected a permanent %s error, got %v", errors.ImageScan, err)
+	}
+	if findings, ok := ScanFindings(err); !ok || len(findings) != 1 || findings[0].ID != "CVE-2021-2" {
+		t.Errorf("expected the critical finding to be reported, got %v", findings)
+	}
+	if len(s.scanned) != 1 || s.scanned[0] != staged.Name() {
+		t.Errorf("expected the staged image to be scanned, got %v", s.scanned)
+	}
+	if _, err := remote.Head(staged); err != nil {
+		t.Errorf("expected failed images to stay staged: %s", err)
+	}
+
+	if (CacheEntry{}).gated(nil) || !(CacheEntry{Scanned: true}).gated(nil) {
+		t.Errorf("expected images cached before the scan gate to be scanned again")
+	}
+
+	SetScanGate(s, host+"/staging", SeverityCritical)
+	s.findings = s.findings[:1]
+	digest, _ := img.Digest()
+	stagedDigest := staged.Context().Digest(digest.String())
+	// Another clone of the same image is still scanning its staged copy
+	acquireStaged(stagedDigest)
+	if err := stageAndScan(context.Background(), destination, img); err != nil {
+		t.Errorf("expected findings below the threshold to pass, got %s", err)
+	}
+	if len(deletes) != 0 {
+		t.Errorf("expected the staged copy to be kept while another scan uses it, got %v", deletes)
+	}
+	if !releaseStaged(stagedDigest) {
+		t.Errorf("expected the last scan of the staged copy to be the one deleting it")
+	}
+	if err := stageAndScan(context.Background(), destination, img); err != nil {
+		t.Errorf("expected findings below the threshold to pass, got %s", err)
+	}
+	if len(deletes) != 1 || !strings.HasSuffix(deletes[0], "/manifests/"+digest.String()) {
+		t.Errorf("expected the staged copy to be deleted by the last scan using it, got %v", deletes)
+	}
+}
+
+// vanishingScanner fails like scanners do when the image they scan was deleted meanwhile
+type vanishingScanner struct {
+	deleted *bool
+}
+
+func (s vanishingScanner) Scan(_ context.Context, ref string, _ authn.Authenticator) ([]Finding, error) {
+	*s.deleted = true
+	return nil, fmt.Errorf("%s: MANIFEST_UNKNOWN", ref)
+}
+
+func TestStageAndScanDeleted(t *testing.T) {
+	deleted := false
+	handler := registry.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if deleted && strings.Contains(r.URL.Path, "/staging/") && strings.Contains(r.URL.Path, "/manifests/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	img, _ := random.Image(64, 1)
+	destination, _ := name.ParseReference(host + "/kube456/test:123")
+	defer SetScanGate(nil, "", DefaultSeverityThreshold)
+
+	SetScanGate(vanishingScanner{deleted: &deleted}, host+"/staging", SeverityCritical)
+	if err := stageAndScan(context.Background(), destination, img); errors.TypeOf(err) != errors.ImageWrite || !errors.IsRetryable(err) {
+		t.Errorf("expected a staged copy deleted before its scan to be retried, got %v", err)
+	}
+}
+
+func TestParseSeverityThreshold(t *testing.T) {
+	if severity, err := ParseSeverityThreshold(""); err != nil || severity != SeverityCritical {
+		t.Errorf("expected %s, got %s (%v)", SeverityCritical, severity, err)
+	}
+	if severity, err := ParseSeverityThreshold("high"); err != nil || severity != SeverityHigh {
+		t.Errorf("expected %s, got %s (%v)", SeverityHigh, severity, err)
+	}
+	if _, err := ParseSeverityThreshold("severe"); err == nil {
+		t.Errorf("expected unknown severities to be rejected")
+	}
+}
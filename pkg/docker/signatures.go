@@ -0,0 +1,95 @@
+package docker
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/Tiemma/image-clone-controller/pkg/errors"
+	"github.com/Tiemma/image-clone-controller/pkg/metrics"
+	"github.com/Tiemma/image-clone-controller/pkg/signature"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// Annotations cosign stores signatures and keyless signing certificates under
+const (
+	cosignSignatureAnnotation   = "dev.cosignproject.cosign/signature"
+	cosignCertificateAnnotation = "dev.sigstore.cosign/certificate"
+	cosignChainAnnotation       = "dev.sigstore.cosign/chain"
+)
+
+// Results reported by the signature verifications metric
+const (
+	signatureVerified = "verified"
+	signatureInvalid  = "invalid"
+	signatureError    = "error"
+)
+
+// verifySignatures checks the source of job carries a signature accepted by its route before anything
+// is written. Unsigned or wrongly signed sources fail with a permanent ImageSignature error
+func verifySignatures(job cloneJob) error {
+	if job.verifier == nil {
+		return nil
+	}
+
+	signatures, err := getSignatures(job.source.Context().Tag(artifactTag(job.sourceDigest, ArtifactSignature)))
+	if err != nil {
+		metrics.UpdateSignatureVerificationsMetric(signatureError)
+		logger.Error(err, "error occurred getting signatures", "image", job.source.Name())
+		return errors.ErrorCloningImage(job.source.Name(), errors.ImageSignature, err)
+	}
+	if err := job.verifier.Verify(job.sourceDigest.String(), signatures); err != nil {
+		metrics.UpdateSignatureVerificationsMetric(signatureInvalid)
+		return errors.Permanent(errors.ImageSignature, job.source.Name(), err)
+	}
+	metrics.UpdateSignatureVerificationsMetric(signatureVerified)
+
+	return nil
+}
+
+// getSignatures reads the cosign signatures stored at ref, none when it does not exist
+func getSignatures(ref name.Reference) ([]signature.Signature, error) {
+	img, err := remote.Image(ref, getAuthConfig(ref)...)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, err
+	}
+
+	signatures := make([]signature.Signature, 0, len(manifest.Layers))
+	for _, desc := range manifest.Layers {
+		sig, err := base64.StdEncoding.DecodeString(desc.Annotations[cosignSignatureAnnotation])
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode signature %s: %w", desc.Digest, err)
+		}
+
+		layer, err := img.LayerByDigest(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		rc, err := layer.Compressed()
+		if err != nil {
+			return nil, err
+		}
+		payload, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		s := signature.Signature{Payload: payload, Signature: sig}
+		if cert := desc.Annotations[cosignCertificateAnnotation]; cert != "" {
+			s.Certificate, s.Chain = []byte(cert), []byte(desc.Annotations[cosignChainAnnotation])
+		}
+		signatures = append(signatures, s)
+	}
+
+	return signatures, nil
+}
@@ -0,0 +1,148 @@
+package docker
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Tiemma/image-clone-controller/pkg/errors"
+	"github.com/Tiemma/image-clone-controller/pkg/routing"
+	"github.com/Tiemma/image-clone-controller/pkg/signature"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	containerRegistry "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// payloadLayer is a cosign signature payload stored as is
+type payloadLayer struct {
+	data []byte
+}
+
+func (l payloadLayer) Digest() (containerRegistry.Hash, error) {
+	hash, _, err := containerRegistry.SHA256(bytes.NewReader(l.data))
+	return hash, err
+}
+
+func (l payloadLayer) DiffID() (containerRegistry.Hash, error) {
+	return l.Digest()
+}
+
+func (l payloadLayer) Compressed() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(l.data)), nil
+}
+
+func (l payloadLayer) Uncompressed() (io.ReadCloser, error) {
+	return l.Compressed()
+}
+
+func (l payloadLayer) Size() (int64, error) {
+	return int64(len(l.data)), nil
+}
+
+func (l payloadLayer) MediaType() (types.MediaType, error) {
+	return "application/vnd.dev.cosign.simplesigning.v1+json", nil
+}
+
+// cosignSign writes a signature of img by key under its cosign signature tag in repository
+func cosignSign(t *testing.T, key *ecdsa.PrivateKey, repository name.Repository, digest containerRegistry.Hash) {
+	payload := []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":%q},"image":{"docker-manifest-digest":%q},"type":"cosign container image signature"}}`, repository.Name(), digest))
+	hash := sha256.Sum256(payload)
+	r, s, _ := ecdsa.Sign(rand.Reader, key, hash[:])
+	sig, _ := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+
+	img, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer:       payloadLayer{data: payload},
+		Annotations: map[string]string{cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(sig)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(repository.Tag(artifactTag(digest, ArtifactSignature)), img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifySignatures(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	dir, _ := ioutil.TempDir("", "signatures")
+	defer os.RemoveAll(dir)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	publicKey := filepath.Join(dir, "cosign.pub")
+	_ = ioutil.WriteFile(publicKey, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600)
+	verifier, err := signature.NewVerifier(signature.Policy{PublicKeys: []string{publicKey}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, _ := name.ParseReference(host + "/source/signed:1")
+	unsigned, _ := name.ParseReference(host + "/source/unsigned:1")
+	for _, ref := range []name.Reference{signed, unsigned} {
+		img, _ := random.Image(64, 1)
+		if err := remote.Write(ref, img); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	img, digest, err := getImageManifest(signed, getAuthConfig(signed)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cosignSign(t, key, signed.Context(), digest)
+	if err := verifySignatures(cloneJob{source: signed, sourceDigest: digest, image: img, verifier: verifier}); err != nil {
+		t.Errorf("expected signed image to be verified, got %s", err)
+	}
+
+	img, digest, _ = getImageManifest(unsigned, getAuthConfig(unsigned)...)
+	err = verifySignatures(cloneJob{source: unsigned, sourceDigest: digest, image: img, verifier: verifier})
+	if errors.TypeOf(err) != errors.ImageSignature || errors.IsRetryable(err) {
+		t.Errorf("expected a permanent %s error for an unsigned image, got %v", errors.ImageSignature, err)
+	}
+	if err := verifySignatures(cloneJob{source: unsigned, sourceDigest: digest, image: img}); err != nil {
+		t.Errorf("expected images of routes without signature policy not to be verified, got %s", err)
+	}
+
+	// Both routes share a destination, only the namespace of the workloads tells their policies apart
+	table, err := routing.New([]routing.Route{
+		{Namespace: "secure", Destination: repoURL, Signatures: &signature.Policy{PublicKeys: []string{publicKey}}},
+		{Destination: repoURL},
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetRoutes(table)
+	defer SetRoutes(routing.Default(repoURL))
+	if verifiers := sourceVerifiers(unsigned, []string{"default"}); len(verifiers) != 0 {
+		t.Errorf("expected no signature policy outside of the secure namespace, got %d", len(verifiers))
+	}
+	if verifiers := sourceVerifiers(unsigned, []string{"default", "secure"}); len(verifiers) != 1 {
+		t.Errorf("expected the policy of the secure namespace, got %d", len(verifiers))
+	}
+
+	if (CacheEntry{}).gated(verifier) || !(CacheEntry{Verified: true}).gated(verifier) {
+		t.Errorf("expected images cached before the signature policy to be verified again")
+	}
+}
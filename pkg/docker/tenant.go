@@ -0,0 +1,178 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Tiemma/image-clone-controller/pkg/errors"
+	"github.com/Tiemma/image-clone-controller/pkg/routing"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// Tenant is the destination and credentials used for the workloads of a namespace
+type Tenant struct {
+	// Destination repository overriding the route destination, images go to a
+	// namespace folder of the route destination when empty
+	Destination string
+	// Keychain authenticates to the destination instead of the controller-wide credentials
+	Keychain authn.Keychain
+}
+
+// Tenants resolves the tenant of every namespace
+type Tenants interface {
+	Tenant(ctx context.Context, namespace string) (Tenant, error)
+}
+
+// tenantDestinations are the destination repositories of a namespace and the credentials used for them
+type tenantDestinations struct {
+	// prefixes of the namespace folders of the routes, or of the destination of the namespace
+	prefixes []string
+	// claimed is the prefix of the destination annotation of the namespace, empty without one
+	claimed  string
+	keychain authn.Keychain
+}
+
+var (
+	tenants Tenants
+
+	// tenantsByNamespace holds the destinations of every namespace resolved so far
+	tenantsByNamespace   = map[string]tenantDestinations{}
+	tenantsByNamespaceMu sync.RWMutex
+)
+
+// SetTenants isolates namespaces in their own destinations, nil clones every image
+// following the routes with the controller-wide credentials
+func SetTenants(t Tenants) {
+	tenants = t
+}
+
+// RestoreTenants resolves the tenants of namespaces so background tasks such as audits
+// and garbage collection reach their destinations before their workloads are reconciled
+// again. Namespaces that cannot be resolved are registered once a workload needs them
+func RestoreTenants(ctx context.Context, namespaces []string) {
+	restored := 0
+	for _, namespace := range namespaces {
+		if _, err := resolveTenant(ctx, namespace); err != nil {
+			logger.Info(fmt.Sprintf("Tenant of namespace %s not restored: %s", namespace, err))
+			continue
+		}
+		restored++
+	}
+	logger.Info(fmt.Sprintf("Restored %d tenant(s)", restored))
+}
+
+// resolveTenant returns the tenant of namespace, or nil when namespaces are not isolated,
+// and registers its destinations. Destinations overlapping a route, a replica or the
+// destination of another namespace are rejected, the namespace claiming it first keeps it
+func resolveTenant(ctx context.Context, namespace string) (*Tenant, error) {
+	if tenants == nil {
+		return nil, nil
+	}
+
+	tenant, err := tenants.Tenant(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	destinations := tenantDestinations{keychain: tenant.Keychain}
+	if tenant.Destination != "" {
+		registry, prefix, err := routing.ParseDestination(tenant.Destination)
+		if err != nil {
+			return nil, errors.Permanent(errors.Config, "", fmt.Errorf("destination %q of namespace %s is not valid: %w", tenant.Destination, namespace, err))
+		}
+		if prefix == registry {
+			return nil, errors.Permanent(errors.Config, "", fmt.Errorf("destination %q of namespace %s must be a repository, not a whole registry", tenant.Destination, namespace))
+		}
+		destinations.claimed = prefix
+	}
+	for _, route := range routes.Routes() {
+		if route.Skip {
+			continue
+		}
+		if destinations.claimed != "" {
+			if prefix, ok := overlapsAny(destinations.claimed, route.DestinationPrefixes()); ok {
+				return nil, errors.Permanent(errors.Config, "", fmt.Errorf("destination %q of namespace %s overlaps the route destination %s", tenant.Destination, namespace, prefix))
+			}
+		}
+		tenantRoute, err := route.ForTenant(namespace, tenant.Destination)
+		if err != nil {
+			return nil, errors.Permanent(errors.Config, "", err)
+		}
+		destinations.prefixes = append(destinations.prefixes, tenantRoute.DestinationPrefixes()...)
+	}
+
+	if err := registerTenant(namespace, destinations); err != nil {
+		return nil, err
+	}
+
+	return &tenant, nil
+}
+
+// registerTenant records the destinations of namespace, replacing the ones it had. The
+// destination it claims must not overlap the one claimed by another namespace
+func registerTenant(namespace string, destinations tenantDestinations) error {
+	tenantsByNamespaceMu.Lock()
+	defer tenantsByNamespaceMu.Unlock()
+
+	if destinations.claimed != "" {
+		for other, existing := range tenantsByNamespace {
+			if other == namespace || existing.claimed == "" {
+				continue
+			}
+			if _, ok := overlapsAny(destinations.claimed, []string{existing.claimed}); ok {
+				return errors.Permanent(errors.Config, "", fmt.Errorf("destination %s of namespace %s overlaps the destination of namespace %s", destinations.claimed, namespace, other))
+			}
+		}
+	}
+	tenantsByNamespace[namespace] = destinations
+
+	return nil
+}
+
+// overlapsAny returns the first of prefixes that prefix lies within or that lies within prefix
+func overlapsAny(prefix string, prefixes []string) (string, bool) {
+	for _, other := range prefixes {
+		if prefix == other || strings.HasPrefix(prefix, other+"/") || strings.HasPrefix(other, prefix+"/") {
+			return other, true
+		}
+	}
+
+	return "", false
+}
+
+// tenantKeychainOf returns the keychain of the tenant whose destination holds ref under the
+// longest prefix. It must only be used for destinations, never for the sources of images
+func tenantKeychainOf(ref name.Reference) (authn.Keychain, bool) {
+	tenantsByNamespaceMu.RLock()
+	defer tenantsByNamespaceMu.RUnlock()
+
+	var keychain authn.Keychain
+	longest := 0
+	for _, destinations := range tenantsByNamespace {
+		for _, prefix := range destinations.prefixes {
+			if len(prefix) > longest && routing.Within(ref, prefix) {
+				keychain, longest = destinations.keychain, len(prefix)
+			}
+		}
+	}
+
+	return keychain, keychain != nil
+}
+
+// isTenantDestination reports whether image lives in the destination claimed by namespace.
+// The destinations of other namespaces are sources like any other image for its workloads
+func isTenantDestination(image, namespace string) bool {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return false
+	}
+
+	tenantsByNamespaceMu.RLock()
+	defer tenantsByNamespaceMu.RUnlock()
+
+	claimed := tenantsByNamespace[namespace].claimed
+	return claimed != "" && routing.Within(ref, claimed)
+}
@@ -0,0 +1,90 @@
+package docker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Tiemma/image-clone-controller/pkg/errors"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+type staticTenants map[string]Tenant
+
+func (s staticTenants) Tenant(_ context.Context, namespace string) (Tenant, error) {
+	return s[namespace], nil
+}
+
+func TestTenantRoutes(t *testing.T) {
+	keychain, _ := NewConfigKeychain([]byte(`{"auths":{}}`))
+	SetTenants(staticTenants{
+		"team-a": {Keychain: keychain},
+		"team-b": {Destination: "harbor.example.com/team-b", Keychain: keychain},
+	})
+	defer func() {
+		SetTenants(nil)
+		tenantsByNamespace = map[string]tenantDestinations{}
+	}()
+
+	tests := map[string]string{
+		"team-a": repoURL + "/team-a/nginx:1.21",
+		"team-b": "harbor.example.com/team-b/nginx:1.21",
+	}
+	ref, _ := name.ParseReference("nginx:1.21")
+	for namespace, expected := range tests {
+		tenant, err := resolveTenant(context.Background(), namespace)
+		if err != nil {
+			t.Errorf("error occured resolving tenant: %s", err)
+			continue
+		}
+
+		route, routed, err := routeOf(ref, placement{namespace: namespace, tenant: tenant})
+		if err != nil || !routed {
+			t.Errorf("expected %s to be routed, got %v", namespace, err)
+			continue
+		}
+		if url := route.URL(ref); url != expected {
+			t.Errorf("expected %s for %s, got %s", expected, namespace, url)
+		}
+		if !IsCacheURL(expected, namespace) {
+			t.Errorf("expected %s to be cached", expected)
+		}
+		destination, _ := name.ParseReference(expected)
+		if _, ok := tenantKeychainOf(destination); !ok {
+			t.Errorf("expected %s to use the credentials of %s", expected, namespace)
+		}
+	}
+
+	if _, ok := tenantKeychainOf(ref); ok {
+		t.Errorf("expected images outside the destinations of tenants to use the controller-wide credentials")
+	}
+	if IsCacheURL("harbor.example.com/team-b/nginx:1.21", "team-a") {
+		t.Errorf("expected the destination of a tenant to be a source for other namespaces")
+	}
+}
+
+func TestTenantOverlaps(t *testing.T) {
+	keychain, _ := NewConfigKeychain([]byte(`{"auths":{}}`))
+	SetTenants(staticTenants{
+		"team-a": {Destination: "harbor.example.com/team-a", Keychain: keychain},
+		"team-b": {Destination: "harbor.example.com/team-a/b", Keychain: keychain},
+		"team-c": {Destination: repoURL + "/team-c", Keychain: keychain},
+		"team-d": {Destination: "docker.io", Keychain: keychain},
+	})
+	defer func() {
+		SetTenants(nil)
+		tenantsByNamespace = map[string]tenantDestinations{}
+	}()
+
+	RestoreTenants(context.Background(), []string{"team-a", "team-b", "team-c", "team-d"})
+	if _, ok := tenantsByNamespace["team-a"]; !ok {
+		t.Errorf("expected the first namespace claiming a destination to keep it")
+	}
+	for _, namespace := range []string{"team-b", "team-c", "team-d"} {
+		if _, err := resolveTenant(context.Background(), namespace); errors.TypeOf(err) != errors.Config {
+			t.Errorf("expected the destination of %s to be rejected, got %v", namespace, err)
+		}
+		if _, ok := tenantsByNamespace[namespace]; ok {
+			t.Errorf("expected %s not to be registered", namespace)
+		}
+	}
+}
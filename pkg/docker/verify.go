@@ -0,0 +1,81 @@
+package docker
+
+import (
+	"fmt"
+
+	"github.com/Tiemma/image-clone-controller/pkg/errors"
+	"github.com/google/go-containerregistry/pkg/name"
+	containerRegistry "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+var verifyBlobs bool
+
+// SetVerifyBlobs enables checking every blob of a written image exists in the destination
+func SetVerifyBlobs(enabled bool) {
+	verifyBlobs = enabled
+}
+
+// verifyWrite reads back the manifest written to destination and checks it is img,
+// so workloads are never rewritten to an image that cannot be pulled
+func verifyWrite(destination name.Reference, img clonedManifest) error {
+	want, err := img.Digest()
+	if err != nil {
+		return errors.ErrorCloningImage(destination.Name(), errors.ImageVerify, err)
+	}
+
+	desc, err := remote.Get(destination, getDestinationAuthConfig(destination)...)
+	if err != nil {
+		return errors.ErrorCloningImage(destination.Name(), errors.ImageVerify, fmt.Errorf("cannot read written manifest: %w", err))
+	}
+	if desc.Digest != want {
+		return errors.ErrorCloningImage(destination.Name(), errors.ImageVerify,
+			fmt.Errorf("destination manifest is %s instead of %s", desc.Digest, want))
+	}
+
+	if !verifyBlobs {
+		return nil
+	}
+
+	blobs, err := imageBlobs(img)
+	if err != nil {
+		return errors.ErrorCloningImage(destination.Name(), errors.ImageVerify, err)
+	}
+	for _, blob := range blobs {
+		layer, err := remote.Layer(destination.Context().Digest(blob.String()), getDestinationAuthConfig(destination)...)
+		if err == nil {
+			// Size HEADs the blob
+			_, err = layer.Size()
+		}
+		if err != nil {
+			return errors.ErrorCloningImage(destination.Name(), errors.ImageVerify, fmt.Errorf("blob %s is missing: %w", blob, err))
+		}
+	}
+
+	return nil
+}
+
+// imageBlobs returns the digests of the configs and layers of every image of img
+func imageBlobs(img clonedManifest) ([]containerRegistry.Hash, error) {
+	images, err := manifestImages(img)
+	if err != nil {
+		return nil, err
+	}
+
+	var blobs []containerRegistry.Hash
+	seen := map[containerRegistry.Hash]bool{}
+	for _, image := range images {
+		manifest, err := image.Manifest()
+		if err != nil {
+			return nil, err
+		}
+		for _, blob := range append([]containerRegistry.Descriptor{manifest.Config}, manifest.Layers...) {
+			if !seen[blob.Digest] {
+				seen[blob.Digest] = true
+				blobs = append(blobs, blob.Digest)
+			}
+		}
+	}
+
+	return blobs, nil
+}
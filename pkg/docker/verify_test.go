@@ -0,0 +1,70 @@
+package docker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Tiemma/image-clone-controller/pkg/errors"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestVerifyWrite(t *testing.T) {
+	// Drop blob requests to mimic a registry that lost the layers
+	missingBlobs := false
+	reg := registry.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if missingBlobs && strings.Contains(r.URL.Path, "/blobs/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	defer SetVerifyBlobs(false)
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	img, _ := random.Image(64, 2)
+	other, _ := random.Image(64, 2)
+	ref, _ := name.ParseReference(host + "/kube456/test:123")
+	if err := remote.Write(ref, img); err != nil {
+		t.Errorf("error occured seeding registry: %s", err)
+	}
+
+	tests := []struct {
+		desc         string
+		image        string
+		verifyBlobs  bool
+		missingBlobs bool
+		wantErr      bool
+	}{
+		{desc: "matching manifest", image: "test:123"},
+		{desc: "missing manifest", image: "missing:123", wantErr: true},
+		{desc: "missing blobs unchecked", image: "test:123", missingBlobs: true},
+		{desc: "missing blobs checked", image: "test:123", verifyBlobs: true, missingBlobs: true, wantErr: true},
+		{desc: "present blobs checked", image: "test:123", verifyBlobs: true},
+	}
+
+	for _, test := range tests {
+		SetVerifyBlobs(test.verifyBlobs)
+		missingBlobs = test.missingBlobs
+		destination, _ := name.ParseReference(host + "/kube456/" + test.image)
+
+		err := verifyWrite(destination, img)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: expected error %v, got %v", test.desc, test.wantErr, err)
+		}
+		if err != nil && errors.TypeOf(err) != errors.ImageVerify {
+			t.Errorf("%s: expected error type %s, got %s", test.desc, errors.ImageVerify, errors.TypeOf(err))
+		}
+	}
+
+	missingBlobs = false
+	if err := verifyWrite(ref, other); errors.TypeOf(err) != errors.ImageVerify {
+		t.Errorf("expected a digest mismatch to fail with %s, got %v", errors.ImageVerify, err)
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"github.com/Tiemma/image-clone-controller/pkg/errors"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	ctrl "sigs.k8s.io/controller-runtime"
 )
@@ -12,10 +14,52 @@ import (
 const (
 	NamespacesToSkip = "NAMESPACES_TO_SKIP"
 	DelayPeriod      = "DELAY_PERIOD"
+	RetryPolicy      = "RETRY_POLICY"
 	IsDevEnv         = "IS_DEV_ENV"
 	Kubeconfig       = "KUBECONFIG"
 	RepoURL          = "REPO_URL"
 	DockerConfig     = "DOCKER_CONFIG"
+
+	ManagedWorkloadPolicy = "MANAGED_WORKLOAD_POLICY"
+	IgnoredManagers       = "IGNORED_MANAGERS"
+	FlapWindow            = "FLAP_WINDOW"
+	FlapThreshold         = "FLAP_THRESHOLD"
+	FlapCooldown          = "FLAP_COOLDOWN"
+	RevertAll             = "REVERT_ALL"
+	RegistryFallback      = "REGISTRY_FALLBACK"
+	RegistryProbeInterval = "REGISTRY_PROBE_INTERVAL"
+	RegistryOutageWindow  = "REGISTRY_OUTAGE_WINDOW"
+	EventInterval         = "EVENT_INTERVAL"
+	CloneCacheFile        = "CLONE_CACHE_FILE"
+	CloneCacheConfigMap   = "CLONE_CACHE_CONFIGMAP"
+	CloneCacheTTL         = "CLONE_CACHE_TTL"
+	CloneCacheMaxEntries  = "CLONE_CACHE_MAX_ENTRIES"
+	ResyncRules           = "RESYNC_RULES"
+	TagConflictPolicy     = "TAG_CONFLICT_POLICY"
+	VerifyBlobs           = "VERIFY_BLOBS"
+	AuditInterval         = "AUDIT_INTERVAL"
+	AuditEnabled          = "AUDIT_ENABLED"
+	RevertOnPullFailure   = "REVERT_ON_PULL_FAILURE"
+	GCInterval            = "GC_INTERVAL"
+	GCGracePeriod         = "GC_GRACE_PERIOD"
+	GCKeepLast            = "GC_KEEP_LAST"
+	GCDryRun              = "GC_DRY_RUN"
+	RoutesFile            = "ROUTES_FILE"
+	ReplicaRetryInterval  = "REPLICA_RETRY_INTERVAL"
+	TenantIsolation       = "TENANT_ISOLATION"
+	TenantSecret          = "TENANT_SECRET"
+	PrivateImagePolicy    = "PRIVATE_IMAGE_POLICY"
+	RepositoryProvisioner = "REPOSITORY_PROVISIONER"
+	ProvisionerURL        = "PROVISIONER_URL"
+	ScannerCommand        = "SCANNER_COMMAND"
+	ScanSeverityThreshold = "SCAN_SEVERITY_THRESHOLD"
+	StagingRepoURL        = "STAGING_REPO_URL"
+	CopyArtifacts         = "COPY_ARTIFACTS"
+
+	AWSRegion          = "AWS_REGION"
+	AWSAccessKeyID     = "AWS_ACCESS_KEY_ID"
+	AWSSecretAccessKey = "AWS_SECRET_ACCESS_KEY"
+	AWSSessionToken    = "AWS_SESSION_TOKEN"
 )
 
 var (
@@ -37,6 +81,82 @@ func splitCommaSeparatedString(str string) []string {
 	return res
 }
 
+// GetList returns the values of a comma separated env key
+func GetList(key string) []string {
+	return splitCommaSeparatedString(os.Getenv(key))
+}
+
+// GetDuration parses the env key as a duration, returning defaultValue when unset
+func GetDuration(key string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a duration e.g 10m: %w", key, err)
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("%s must be positive and greater than 0", key)
+	}
+
+	return duration, nil
+}
+
+// GetInt parses the env key as a positive integer, returning defaultValue when unset
+func GetInt(key string, defaultValue int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	res, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer: %w", key, err)
+	}
+	if res <= 0 {
+		return 0, fmt.Errorf("%s must be positive and greater than 0", key)
+	}
+
+	return res, nil
+}
+
+// IsRevertAllEnabled reports whether every workload should get its original images back
+func IsRevertAllEnabled() bool {
+	return strings.EqualFold(os.Getenv(RevertAll), "true")
+}
+
+// IsRegistryFallbackEnabled reports whether workloads switch back upstream during registry outages
+func IsRegistryFallbackEnabled() bool {
+	return !strings.EqualFold(os.Getenv(RegistryFallback), "false")
+}
+
+// IsAuditEnabled reports whether the cached images used by workloads are periodically audited
+func IsAuditEnabled() bool {
+	return !strings.EqualFold(os.Getenv(AuditEnabled), "false")
+}
+
+// IsBlobVerificationEnabled reports whether every blob of a written image is checked in the destination
+func IsBlobVerificationEnabled() bool {
+	return strings.EqualFold(os.Getenv(VerifyBlobs), "true")
+}
+
+// IsRevertOnPullFailureEnabled reports whether workloads failing to pull their cached images get reverted
+func IsRevertOnPullFailureEnabled() bool {
+	return strings.EqualFold(os.Getenv(RevertOnPullFailure), "true")
+}
+
+// IsGCDryRunEnabled reports whether the garbage collector only reports what it would delete
+func IsGCDryRunEnabled() bool {
+	return strings.EqualFold(os.Getenv(GCDryRun), "true")
+}
+
+// IsTenantIsolationEnabled reports whether every namespace gets its own destination and credentials
+func IsTenantIsolationEnabled() bool {
+	return strings.EqualFold(os.Getenv(TenantIsolation), "true")
+}
+
 func getSkippableNamespaces() []string {
 	// We can ignore duplicates as the sample set is too small to
 	// bring out any performance issues
@@ -57,10 +177,16 @@ func IsSkippableNamespace(workloadKind string, namespace string) bool {
 	return false
 }
 
-func MustValidateRequiredEnvsExist() {
+func ValidateRequiredEnvsExist() error {
 	for _, key := range RequiredEnvVariables {
+		// Routes may send every image somewhere else than REPO_URL
+		if key == RepoURL && os.Getenv(RoutesFile) != "" {
+			continue
+		}
 		if os.Getenv(key) == "" {
-			errors.HandleErr(fmt.Errorf("%s env key must be set", key))
+			return errors.ErrorMissingConfig(key)
 		}
 	}
+
+	return nil
 }
@@ -4,6 +4,7 @@ import (
 	"os"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestMain(m *testing.M) {
@@ -45,3 +46,29 @@ func TestSplitCommaSeparatedString(t *testing.T) {
 		}
 	}
 }
+
+func TestGetDuration(t *testing.T) {
+	key := "TEST_DURATION"
+	specs := []struct {
+		value    string
+		expected time.Duration
+		isErr    bool
+	}{
+		{value: "", expected: time.Minute},
+		{value: "10m", expected: 10 * time.Minute},
+		{value: "10", isErr: true},
+		{value: "-1m", isErr: true},
+	}
+
+	for _, spec := range specs {
+		os.Setenv(key, spec.value)
+		res, err := GetDuration(key, time.Minute)
+		if (err != nil) != spec.isErr {
+			t.Errorf("expected error %t for %q, got %v", spec.isErr, spec.value, err)
+		}
+		if res != spec.expected {
+			t.Errorf("expected %s, got %s", spec.expected, res)
+		}
+	}
+	os.Unsetenv(key)
+}
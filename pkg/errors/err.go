@@ -1,6 +1,9 @@
 package errors
 
-import "fmt"
+import (
+	stderrors "errors"
+	"fmt"
+)
 
 type ErrType string
 
@@ -10,22 +13,147 @@ const (
 	ImageWrite     ErrType = "IMAGE_WRITE"
 	SpecUpdate     ErrType = "SPEC_UPDATE"
 	SpecGet        ErrType = "SPEC_GET"
+	Config         ErrType = "CONFIG"
+	// ImageTagConflict is returned when the destination tag holds another image
+	ImageTagConflict ErrType = "IMAGE_TAG_CONFLICT"
+	// ImageVerify is returned when a written image cannot be read back from the destination
+	ImageVerify ErrType = "IMAGE_VERIFY"
+	// ImageDelete is returned when a cached image cannot be garbage collected
+	ImageDelete ErrType = "IMAGE_DELETE"
+	// RepositoryProvision is returned when the destination repository of an image cannot be created
+	RepositoryProvision ErrType = "REPOSITORY_PROVISION"
+	// ImageScan is returned when a staged image has findings at or above the severity threshold
+	ImageScan ErrType = "IMAGE_SCAN"
+	// ImageSignature is returned when the source image carries no signature accepted by its route
+	ImageSignature ErrType = "IMAGE_SIGNATURE"
 )
 
-func HandleErr(err error) {
-	if err != nil {
-		panic(err)
+// Error allows an ErrType to be used as a target for errors.Is
+func (t ErrType) Error() string {
+	return string(t)
+}
+
+// CloneError describes a failure while cloning images for a workload.
+// It wraps the underlying error so callers can inspect it with errors.Is and errors.As
+type CloneError struct {
+	Type      ErrType
+	Image     string
+	Workload  string
+	Retryable bool
+	Err       error
+}
+
+func (e *CloneError) Error() string {
+	msg := fmt.Sprintf("%s error", e.Type)
+	if e.Workload != "" {
+		msg = fmt.Sprintf("%s for %s", msg, e.Workload)
+	}
+	if e.Image != "" {
+		msg = fmt.Sprintf("%s on image %s", msg, e.Image)
 	}
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %v", msg, e.Err)
+	}
+
+	return msg
+}
+
+func (e *CloneError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches CloneErrors against their ErrType, e.g errors.Is(err, ImageWrite)
+func (e *CloneError) Is(target error) bool {
+	t, ok := target.(ErrType)
+	return ok && t == e.Type
+}
+
+// WithWorkload records the workload the error occurred on
+func (e *CloneError) WithWorkload(kind, namespace, name string) *CloneError {
+	e.Workload = fmt.Sprintf("%s %s/%s", kind, namespace, name)
+	return e
+}
+
+// isRetryable reports whether an error of the given type can succeed on a later attempt
+func isRetryable(errType ErrType) bool {
+	switch errType {
+	case ImageReference, Config, ImageTagConflict:
+		return false
+	default:
+		return true
+	}
+}
+
+// New returns a CloneError whose retryability is derived from its type
+func New(errType ErrType, image string, err error) *CloneError {
+	return &CloneError{
+		Type:      errType,
+		Image:     image,
+		Retryable: isRetryable(errType),
+		Err:       err,
+	}
+}
+
+// Permanent returns a CloneError that should never be retried
+func Permanent(errType ErrType, image string, err error) *CloneError {
+	e := New(errType, image, err)
+	e.Retryable = false
+	return e
+}
+
+// AsCloneError returns the first CloneError in the chain of err
+func AsCloneError(err error) (*CloneError, bool) {
+	var cloneErr *CloneError
+	if stderrors.As(err, &cloneErr) {
+		return cloneErr, true
+	}
+
+	return nil, false
+}
+
+// IsRetryable reports whether err is worth retrying.
+// Errors not produced by this package are assumed to be transient.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if cloneErr, ok := AsCloneError(err); ok {
+		return cloneErr.Retryable
+	}
+
+	return true
+}
+
+// TypeOf returns the ErrType of err or an empty string if it carries none
+func TypeOf(err error) ErrType {
+	if cloneErr, ok := AsCloneError(err); ok {
+		return cloneErr.Type
+	}
+
+	return ""
+}
+
+// ImageOf returns the image err occurred on or an empty string if it carries none
+func ImageOf(err error) string {
+	if cloneErr, ok := AsCloneError(err); ok {
+		return cloneErr.Image
+	}
+
+	return ""
 }
 
 func ErrorGettingResource(kind string, err error) error {
-	return fmt.Errorf("error occurred getting %s: %s", kind, err)
+	return New(SpecGet, "", fmt.Errorf("error occurred getting %s: %w", kind, err))
 }
 
 func ErrorUpdatingResource(name string, namespace string, kind string, err error) error {
-	return fmt.Errorf("error occurred updating %s %s in namespace %s: %s", kind, name, namespace, err)
+	return New(SpecUpdate, "", err).WithWorkload(kind, namespace, name)
+}
+
+func ErrorCloningImage(image string, errType ErrType, err error) error {
+	return New(errType, image, err)
 }
 
-func ErrorCloningImage(image string, errType ErrType) error {
-	return fmt.Errorf("error occurred cloning image %s, reason: %s", image, errType)
+func ErrorMissingConfig(key string) error {
+	return Permanent(Config, "", fmt.Errorf("%s env key must be set", key))
 }
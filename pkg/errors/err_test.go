@@ -0,0 +1,54 @@
+package errors
+
+import (
+	stderrors "errors"
+	"fmt"
+	"testing"
+)
+
+func TestCloneErrorIs(t *testing.T) {
+	cause := fmt.Errorf("connection refused")
+	err := fmt.Errorf("wrapped: %w", New(ImageWrite, "docker.io/kube123/test:123", cause))
+
+	if !stderrors.Is(err, ImageWrite) {
+		t.Errorf("expected error to match %s", ImageWrite)
+	}
+	if stderrors.Is(err, ImageManifest) {
+		t.Errorf("expected error not to match %s", ImageManifest)
+	}
+	if !stderrors.Is(err, cause) {
+		t.Errorf("expected error to wrap its cause")
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	specs := []struct {
+		err      error
+		expected bool
+	}{
+		{err: New(ImageReference, "", nil), expected: false},
+		{err: New(ImageManifest, "", nil), expected: true},
+		{err: New(ImageWrite, "", nil), expected: true},
+		{err: Permanent(ImageWrite, "", nil), expected: false},
+		{err: ErrorMissingConfig("REPO_URL"), expected: false},
+		{err: fmt.Errorf("unclassified"), expected: true},
+		{err: nil, expected: false},
+	}
+
+	for _, spec := range specs {
+		if res := IsRetryable(spec.err); res != spec.expected {
+			t.Errorf("expected %t for %v, got %t", spec.expected, spec.err, res)
+		}
+	}
+}
+
+func TestCloneErrorMessage(t *testing.T) {
+	err := New(ImageManifest, "nginx", fmt.Errorf("not found")).WithWorkload("Deployment", "default", "web")
+	expected := "IMAGE_MANIFEST error for Deployment default/web on image nginx: not found"
+	if err.Error() != expected {
+		t.Errorf("expected %s, got %s", expected, err.Error())
+	}
+	if TypeOf(err) != ImageManifest || ImageOf(err) != "nginx" {
+		t.Errorf("expected type and image to be recoverable from %v", err)
+	}
+}
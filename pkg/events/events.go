@@ -0,0 +1,74 @@
+package events
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// RateLimitedRecorder drops events repeating the reason and message
+// of an event emitted on the same object within the interval
+type RateLimitedRecorder struct {
+	record.EventRecorder
+	interval time.Duration
+
+	mu   sync.Mutex
+	last map[string]time.Time
+	now  func() time.Time
+}
+
+func NewRateLimitedRecorder(recorder record.EventRecorder, interval time.Duration) *RateLimitedRecorder {
+	return &RateLimitedRecorder{
+		EventRecorder: recorder,
+		interval:      interval,
+		last:          map[string]time.Time{},
+		now:           time.Now,
+	}
+}
+
+// allow reports whether the event can be emitted and records it if so
+func (r *RateLimitedRecorder) allow(object runtime.Object, reason, message string) bool {
+	accessor, err := meta.Accessor(object)
+	if err != nil {
+		return true
+	}
+	key := fmt.Sprintf("%s/%s/%s", accessor.GetUID(), reason, message)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	if last, ok := r.last[key]; ok && now.Sub(last) < r.interval {
+		return false
+	}
+	r.last[key] = now
+
+	// Forget expired entries so deleted objects do not accumulate
+	for k, t := range r.last {
+		if now.Sub(t) >= r.interval {
+			delete(r.last, k)
+		}
+	}
+
+	return true
+}
+
+func (r *RateLimitedRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	if r.allow(object, reason, message) {
+		r.EventRecorder.Event(object, eventtype, reason, message)
+	}
+}
+
+func (r *RateLimitedRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.Event(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func (r *RateLimitedRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	if message := fmt.Sprintf(messageFmt, args...); r.allow(object, reason, message) {
+		r.EventRecorder.AnnotatedEventf(object, annotations, eventtype, reason, "%s", message)
+	}
+}
@@ -0,0 +1,35 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestRateLimitedRecorder(t *testing.T) {
+	now := time.Now()
+	fake := record.NewFakeRecorder(10)
+	recorder := NewRateLimitedRecorder(fake, time.Minute)
+	recorder.now = func() time.Time { return now }
+
+	web := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{UID: "web"}}
+	api := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{UID: "api"}}
+
+	recorder.Event(web, corev1.EventTypeWarning, "CloneFailed", "IMAGE_WRITE")
+	recorder.Event(web, corev1.EventTypeWarning, "CloneFailed", "IMAGE_WRITE")
+	recorder.Eventf(web, corev1.EventTypeWarning, "CloneFailed", "%s", "IMAGE_MANIFEST")
+	recorder.Event(api, corev1.EventTypeWarning, "CloneFailed", "IMAGE_WRITE")
+	if len(fake.Events) != 3 {
+		t.Errorf("expected the repeated event to be dropped, got %d events", len(fake.Events))
+	}
+
+	now = now.Add(time.Minute)
+	recorder.Event(web, corev1.EventTypeWarning, "CloneFailed", "IMAGE_WRITE")
+	if len(fake.Events) != 4 {
+		t.Errorf("expected the event to be emitted again after the interval, got %d events", len(fake.Events))
+	}
+}
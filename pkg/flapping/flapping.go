@@ -0,0 +1,74 @@
+package flapping
+
+import (
+	"sync"
+	"time"
+)
+
+// Detector counts how often each workload gets rewritten and suppresses
+// further rewrites once another actor keeps reverting them
+type Detector struct {
+	window    time.Duration
+	threshold int
+	cooldown  time.Duration
+
+	mu              sync.Mutex
+	rewrites        map[string][]time.Time
+	suppressedUntil map[string]time.Time
+	now             func() time.Time
+}
+
+func NewDetector(window time.Duration, threshold int, cooldown time.Duration) *Detector {
+	return &Detector{
+		window:          window,
+		threshold:       threshold,
+		cooldown:        cooldown,
+		rewrites:        map[string][]time.Time{},
+		suppressedUntil: map[string]time.Time{},
+		now:             time.Now,
+	}
+}
+
+// RecordRewrite records a rewrite of key undoing a revert of an earlier rewrite and reports
+// whether it pushed key past the threshold, in which case key is suppressed for the cool-down
+func (d *Detector) RecordRewrite(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	var recent []time.Time
+	for _, t := range d.rewrites[key] {
+		if now.Sub(t) < d.window {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+
+	if len(recent) < d.threshold {
+		d.rewrites[key] = recent
+		return false
+	}
+
+	delete(d.rewrites, key)
+	d.suppressedUntil[key] = now.Add(d.cooldown)
+	return true
+}
+
+// Suppressed returns the remaining cool-down of key, if any
+func (d *Detector) Suppressed(key string) (time.Duration, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	until, ok := d.suppressedUntil[key]
+	if !ok {
+		return 0, false
+	}
+
+	remaining := until.Sub(d.now())
+	if remaining <= 0 {
+		delete(d.suppressedUntil, key)
+		return 0, false
+	}
+
+	return remaining, true
+}
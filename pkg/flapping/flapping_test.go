@@ -0,0 +1,41 @@
+package flapping
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDetector(t *testing.T) {
+	now := time.Now()
+	detector := NewDetector(10*time.Minute, 3, time.Hour)
+	detector.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if detector.RecordRewrite("web") {
+			t.Errorf("expected rewrite %d to stay under the threshold", i+1)
+		}
+		now = now.Add(time.Minute)
+	}
+
+	// Rewrites older than the window are forgotten
+	now = now.Add(20 * time.Minute)
+	if detector.RecordRewrite("web") {
+		t.Errorf("expected old rewrites to fall out of the window")
+	}
+
+	detector.RecordRewrite("web")
+	if !detector.RecordRewrite("web") {
+		t.Errorf("expected the third rewrite within the window to flap")
+	}
+	if remaining, ok := detector.Suppressed("web"); !ok || remaining != time.Hour {
+		t.Errorf("expected web to be suppressed for the cool-down, got %s", remaining)
+	}
+	if _, ok := detector.Suppressed("api"); ok {
+		t.Errorf("expected other workloads to be unaffected")
+	}
+
+	now = now.Add(time.Hour)
+	if _, ok := detector.Suppressed("web"); ok {
+		t.Errorf("expected web to resume after the cool-down")
+	}
+}
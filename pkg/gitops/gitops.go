@@ -0,0 +1,89 @@
+package gitops
+
+import (
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Policy decides what happens to workloads another tool keeps in sync
+type Policy string
+
+const (
+	// PolicySkip leaves managed workloads untouched
+	PolicySkip Policy = "skip"
+	// PolicyRewrite rewrites managed workloads like any other, risking a sync loop
+	PolicyRewrite Policy = "rewrite"
+	// PolicyPrecache caches the images and suggests the change to make at the source
+	PolicyPrecache Policy = "precache"
+
+	DefaultPolicy = PolicyPrecache
+
+	managedByLabel = "app.kubernetes.io/managed-by"
+)
+
+// marker is a label or annotation set by a GitOps tool on the resources it syncs
+type marker struct {
+	key     string
+	manager string
+}
+
+var (
+	// DefaultIgnoredManagers lists managed-by values that only apply changes on demand
+	DefaultIgnoredManagers = []string{"Helm"}
+
+	// Labels and annotations set by GitOps tools on the resources they sync, the first present wins.
+	// The app.kubernetes.io/instance label Argo CD tracks with by default is left out as plain Helm charts set it too
+	gitOpsLabels = []marker{
+		{key: "argocd.argoproj.io/instance", manager: "argocd"},
+		{key: "kustomize.toolkit.fluxcd.io/name", manager: "flux"},
+		{key: "helm.toolkit.fluxcd.io/name", manager: "flux"},
+	}
+	gitOpsAnnotations = []marker{
+		{key: "argocd.argoproj.io/tracking-id", manager: "argocd"},
+		{key: "fluxcd.io/sync-checksum", manager: "flux"},
+	}
+)
+
+func ParsePolicy(str string) (Policy, error) {
+	switch policy := Policy(str); policy {
+	case "":
+		return DefaultPolicy, nil
+	case PolicySkip, PolicyRewrite, PolicyPrecache:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("managed workload policy must be one of %s, %s or %s, got %q", PolicySkip, PolicyRewrite, PolicyPrecache, str)
+	}
+}
+
+// ManagerOf returns a description of whatever keeps obj in sync, or an empty string
+// when nothing but users is expected to modify it
+func ManagerOf(obj metav1.Object, ignoredManagers []string) string {
+	if owner := metav1.GetControllerOf(obj); owner != nil {
+		return fmt.Sprintf("%s %s", owner.Kind, owner.Name)
+	}
+
+	for _, label := range gitOpsLabels {
+		if _, ok := obj.GetLabels()[label.key]; ok {
+			return label.manager
+		}
+	}
+	for _, annotation := range gitOpsAnnotations {
+		if _, ok := obj.GetAnnotations()[annotation.key]; ok {
+			return annotation.manager
+		}
+	}
+
+	if manager := obj.GetLabels()[managedByLabel]; manager != "" {
+		for _, ignored := range ignoredManagers {
+			if strings.EqualFold(ignored, manager) {
+				return ""
+			}
+		}
+
+		return manager
+	}
+
+	return ""
+}
@@ -0,0 +1,54 @@
+package gitops
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestManagerOf(t *testing.T) {
+	isController := true
+	specs := []struct {
+		meta     metav1.ObjectMeta
+		expected string
+	}{
+		{meta: metav1.ObjectMeta{}, expected: ""},
+		{meta: metav1.ObjectMeta{Labels: map[string]string{"kustomize.toolkit.fluxcd.io/name": "apps"}}, expected: "flux"},
+		{meta: metav1.ObjectMeta{Annotations: map[string]string{"argocd.argoproj.io/tracking-id": "apps:apps/Deployment:default/web"}}, expected: "argocd"},
+		{meta: metav1.ObjectMeta{Labels: map[string]string{managedByLabel: "Helm"}}, expected: ""},
+		{meta: metav1.ObjectMeta{Labels: map[string]string{"app.kubernetes.io/instance": "web"}}, expected: ""},
+		{meta: metav1.ObjectMeta{Labels: map[string]string{"app.kubernetes.io/instance": "web", managedByLabel: "Helm"}}, expected: ""},
+		{
+			meta: metav1.ObjectMeta{
+				Labels:      map[string]string{"argocd.argoproj.io/instance": "apps"},
+				Annotations: map[string]string{"fluxcd.io/sync-checksum": "abc"},
+			},
+			expected: "argocd",
+		},
+		{meta: metav1.ObjectMeta{Labels: map[string]string{managedByLabel: "my-operator"}}, expected: "my-operator"},
+		{
+			meta: metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{
+				{Kind: "Prometheus", Name: "k8s", Controller: &isController},
+			}},
+			expected: "Prometheus k8s",
+		},
+	}
+
+	for _, spec := range specs {
+		if res := ManagerOf(&spec.meta, DefaultIgnoredManagers); res != spec.expected {
+			t.Errorf("expected %q for %v, got %q", spec.expected, spec.meta, res)
+		}
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	if policy, err := ParsePolicy(""); err != nil || policy != DefaultPolicy {
+		t.Errorf("expected default policy, got %s, %v", policy, err)
+	}
+	if policy, err := ParsePolicy("skip"); err != nil || policy != PolicySkip {
+		t.Errorf("expected skip policy, got %s, %v", policy, err)
+	}
+	if _, err := ParsePolicy("ignore"); err == nil {
+		t.Errorf("expected unknown policy to be rejected")
+	}
+}
@@ -0,0 +1,197 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+var logger = ctrl.Log.WithValues("pkg", "health")
+
+// registry is a probed registry and its health
+type registry struct {
+	url          string
+	healthy      bool
+	failingSince time.Time
+}
+
+// Checker probes the /v2/ endpoint of registries and reports each unhealthy
+// once its probes have kept failing for longer than the outage window
+type Checker struct {
+	interval     time.Duration
+	outageWindow time.Duration
+	client       *http.Client
+
+	mu sync.Mutex
+	// registries keyed by host, spelled like parsed references
+	registries  map[string]*registry
+	subscribers []chan bool
+	now         func() time.Time
+}
+
+// registryOf returns the registry hosting repoURL e.g docker.io/k8s
+func registryOf(repoURL string) (name.Registry, error) {
+	return name.NewRegistry(strings.SplitN(repoURL, "/", 2)[0])
+}
+
+// NewChecker builds a checker for the registries hosting repoURLs e.g docker.io/k8s,
+// registries shared by several repositories being probed once
+func NewChecker(repoURLs []string, interval, outageWindow time.Duration) (*Checker, error) {
+	c := &Checker{
+		interval:     interval,
+		outageWindow: outageWindow,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		registries:   map[string]*registry{},
+		now:          time.Now,
+	}
+	for _, repoURL := range repoURLs {
+		reg, err := registryOf(repoURL)
+		if err != nil {
+			return nil, fmt.Errorf("cannot determine registry of %s: %w", repoURL, err)
+		}
+		c.track(reg)
+	}
+
+	return c, nil
+}
+
+// track adds reg to the probed registries unless it already is, callers must hold the lock
+func (c *Checker) track(reg name.Registry) *registry {
+	if probed, ok := c.registries[reg.RegistryStr()]; ok {
+		return probed
+	}
+
+	probed := &registry{url: fmt.Sprintf("%s://%s/v2/", reg.Scheme(), reg.RegistryStr()), healthy: true}
+	c.registries[reg.RegistryStr()] = probed
+	return probed
+}
+
+// Healthy reports whether every registry is considered usable
+func (c *Checker) Healthy() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, reg := range c.registries {
+		if !reg.healthy {
+			return false
+		}
+	}
+
+	return true
+}
+
+// HealthyFor reports whether the registry hosting image is considered usable. Registries
+// that were not probed yet, such as tenant destinations, are probed from now on
+func (c *Checker) HealthyFor(image string) bool {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.track(ref.Context().Registry).healthy
+}
+
+// Subscribe returns a channel receiving the new health of a registry on every transition
+func (c *Checker) Subscribe() <-chan bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan bool, 1)
+	c.subscribers = append(c.subscribers, ch)
+	return ch
+}
+
+// Start probes the registries until ctx is done, implementing manager.Runnable
+func (c *Checker) Start(ctx context.Context) error {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		for _, reg := range c.probed() {
+			c.record(reg, c.probe(ctx, reg.url))
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// probed returns the registries to probe, which grow as new destinations get used
+func (c *Checker) probed() []*registry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	registries := make([]*registry, 0, len(c.registries))
+	for _, reg := range c.registries {
+		registries = append(registries, reg)
+	}
+
+	return registries
+}
+
+// probe succeeds on any response below 500 since registries answer 401 to anonymous requests
+func (c *Checker) probe(ctx context.Context, url string) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("registry returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// record updates the health of reg from a probe result and notifies subscribers on transitions
+func (c *Checker) record(reg *registry, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	healthy := reg.healthy
+	if err == nil {
+		reg.failingSince = time.Time{}
+		healthy = true
+	} else {
+		logger.Error(err, "registry health probe failed", "url", reg.url)
+		if reg.failingSince.IsZero() {
+			reg.failingSince = c.now()
+		}
+		if c.now().Sub(reg.failingSince) >= c.outageWindow {
+			healthy = false
+		}
+	}
+
+	if healthy == reg.healthy {
+		return
+	}
+	reg.healthy = healthy
+	logger.Info(fmt.Sprintf("Registry %s health changed", reg.url), "healthy", healthy)
+
+	for _, ch := range c.subscribers {
+		// Subscribers only care about the latest state so drop any unread one
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- healthy
+	}
+}
@@ -0,0 +1,73 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProbe(t *testing.T) {
+	status := http.StatusUnauthorized
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/" {
+			t.Errorf("expected /v2/ to be probed, got %s", r.URL.Path)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	checker, err := NewChecker([]string{host + "/k8s", host + "/other"}, time.Minute, time.Minute)
+	if err != nil {
+		t.Errorf("error occured creating checker: %s", err)
+	}
+	if len(checker.registries) != 1 {
+		t.Errorf("expected repositories of the same registry to be probed once, got %d registries", len(checker.registries))
+	}
+
+	if err := checker.probe(context.Background(), server.URL+"/v2/"); err != nil {
+		t.Errorf("expected unauthorized registry to be healthy, got %s", err)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := checker.probe(context.Background(), server.URL+"/v2/"); err == nil {
+		t.Errorf("expected unavailable registry to fail the probe")
+	}
+}
+
+func TestRecord(t *testing.T) {
+	now := time.Now()
+	checker, _ := NewChecker([]string{"docker.io/k8s", "harbor.example.com/dockerhub"}, time.Minute, 5*time.Minute)
+	checker.now = func() time.Time { return now }
+	changes := checker.Subscribe()
+	harbor := checker.registries["harbor.example.com"]
+
+	checker.record(harbor, fmt.Errorf("connection refused"))
+	if !checker.Healthy() {
+		t.Errorf("expected registry to stay healthy within the outage window")
+	}
+
+	now = now.Add(5 * time.Minute)
+	checker.record(harbor, fmt.Errorf("connection refused"))
+	if checker.Healthy() || checker.HealthyFor("harbor.example.com/dockerhub/nginx:1.21") {
+		t.Errorf("expected registry to be unhealthy after the outage window")
+	}
+	if !checker.HealthyFor("docker.io/k8s/nginx:1.21") || !checker.HealthyFor("quay.io/app:1") {
+		t.Errorf("expected other registries to stay healthy")
+	}
+	if _, ok := checker.registries["quay.io"]; !ok {
+		t.Errorf("expected registries in use to be probed from then on")
+	}
+	if healthy := <-changes; healthy {
+		t.Errorf("expected subscribers to be notified of the outage")
+	}
+
+	checker.record(harbor, nil)
+	if !checker.Healthy() || !<-changes {
+		t.Errorf("expected registry to recover on the first successful probe")
+	}
+}
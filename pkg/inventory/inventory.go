@@ -0,0 +1,220 @@
+package inventory
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+
+	cachev1alpha1 "github.com/Tiemma/image-clone-controller/api/v1alpha1"
+	"github.com/Tiemma/image-clone-controller/pkg/docker"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const maxNamePrefixLength = 52
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// Inventory records cloned images as ClonedImage resources
+type Inventory struct {
+	client client.Client
+}
+
+func New(c client.Client) *Inventory {
+	return &Inventory{client: c}
+}
+
+// Name returns the ClonedImage name of a destination reference. A hash suffix
+// keeps names unique when sanitising or truncating the reference collides
+func Name(destination string) string {
+	prefix := invalidNameChars.ReplaceAllString(strings.ToLower(destination), "-")
+	if len(prefix) > maxNamePrefixLength {
+		prefix = prefix[len(prefix)-maxNamePrefixLength:]
+	}
+	prefix = strings.Trim(prefix, "-.")
+
+	hash := sha256.Sum256([]byte(destination))
+	return prefix + "-" + hex.EncodeToString(hash[:])[:10]
+}
+
+// Record creates or updates the ClonedImage of record and adds workload to its users
+func (i *Inventory) Record(ctx context.Context, record docker.CloneRecord, workload docker.Workload) error {
+	key := types.NamespacedName{Name: Name(record.Destination)}
+	now := metav1.Now()
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		clonedImage := &cachev1alpha1.ClonedImage{}
+		err := i.client.Get(ctx, key, clonedImage)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+
+		exists := err == nil
+		if !exists {
+			clonedImage.Name = key.Name
+			clonedImage.Status.FirstSyncTime = &now
+		}
+		clonedImage.Spec = cachev1alpha1.ClonedImageSpec{Source: record.Source, Destination: record.Destination}
+		clonedImage.Status.SourceDigest = record.SourceDigest
+		clonedImage.Status.DestinationDigest = record.DestinationDigest
+		clonedImage.Status.MediaType = record.MediaType
+		clonedImage.Status.Size = record.Size
+		clonedImage.Status.Platforms = record.Platforms
+		clonedImage.Status.LastSyncTime = &now
+		if record.Access != "" {
+			clonedImage.Status.Access = record.Access
+		}
+		clonedImage.Status.Artifacts = record.Artifacts
+		clonedImage.Status.ArtifactsError = record.ArtifactsError
+		for _, replica := range record.Replicas {
+			AddReplica(clonedImage, replica)
+		}
+		// Resyncs are not done on behalf of a workload
+		if workload.Name != "" {
+			clonedImage.Status.UnreferencedSince = nil
+			AddWorkload(clonedImage, cachev1alpha1.WorkloadReference{Kind: workload.Kind, Namespace: workload.Namespace, Name: workload.Name})
+			if record.Authorized {
+				AddAuthorizedNamespace(clonedImage, workload.Namespace)
+			}
+			for _, secret := range record.PullSecrets {
+				AddAuthorizedPullSecret(clonedImage, workload.Namespace+"/"+secret)
+			}
+		}
+
+		if !exists {
+			return i.client.Create(ctx, clonedImage)
+		}
+		return i.client.Update(ctx, clonedImage)
+	})
+}
+
+// RecordUse adds workload to the users of the ClonedImage of use.Destination along with its
+// authorization. Images cloned before the inventory existed have no ClonedImage and are left unrecorded
+func (i *Inventory) RecordUse(ctx context.Context, use docker.ImageUse, workload docker.Workload) error {
+	key := types.NamespacedName{Name: Name(use.Destination)}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		clonedImage := &cachev1alpha1.ClonedImage{}
+		if err := i.client.Get(ctx, key, clonedImage); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+
+		changed := AddWorkload(clonedImage, cachev1alpha1.WorkloadReference{Kind: workload.Kind, Namespace: workload.Namespace, Name: workload.Name})
+		if clonedImage.Status.UnreferencedSince != nil {
+			clonedImage.Status.UnreferencedSince, changed = nil, true
+		}
+		if use.Authorized && AddAuthorizedNamespace(clonedImage, workload.Namespace) {
+			changed = true
+		}
+		for _, secret := range use.PullSecrets {
+			if AddAuthorizedPullSecret(clonedImage, workload.Namespace+"/"+secret) {
+				changed = true
+			}
+		}
+		// Every reconcile of a workload goes through here, unchanged images are not updated
+		if !changed {
+			return nil
+		}
+
+		return i.client.Update(ctx, clonedImage)
+	})
+}
+
+// AddWorkload adds ref to the users of clonedImage unless already listed, reporting whether it was added
+func AddWorkload(clonedImage *cachev1alpha1.ClonedImage, ref cachev1alpha1.WorkloadReference) bool {
+	for _, existing := range clonedImage.Status.Workloads {
+		if existing == ref {
+			return false
+		}
+	}
+
+	clonedImage.Status.Workloads = append(clonedImage.Status.Workloads, ref)
+	return true
+}
+
+// RecordReplicas updates the replication status of the ClonedImage of destination, replicas
+// not written this time keep their last status
+func (i *Inventory) RecordReplicas(ctx context.Context, destination string, replicas []docker.ReplicaRecord) error {
+	key := types.NamespacedName{Name: Name(destination)}
+	now := metav1.Now()
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		clonedImage := &cachev1alpha1.ClonedImage{}
+		if err := i.client.Get(ctx, key, clonedImage); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+
+		for _, replica := range replicas {
+			status := cachev1alpha1.ReplicaStatus{Destination: replica.Destination}
+			if replica.Err != nil {
+				status.Error = replica.Err.Error()
+			} else {
+				status.DestinationDigest = replica.DestinationDigest
+				status.LastSyncTime = &now
+			}
+			SetReplica(clonedImage, status)
+		}
+
+		return i.client.Update(ctx, clonedImage)
+	})
+}
+
+// AddReplica lists destination among the replicas of clonedImage unless already listed,
+// leaving it without digest until written
+func AddReplica(clonedImage *cachev1alpha1.ClonedImage, destination string) {
+	for _, existing := range clonedImage.Status.Replicas {
+		if existing.Destination == destination {
+			return
+		}
+	}
+
+	clonedImage.Status.Replicas = append(clonedImage.Status.Replicas, cachev1alpha1.ReplicaStatus{Destination: destination})
+}
+
+// SetReplica replaces the status of the replica of clonedImage at the same destination, or adds it.
+// A failed replication keeps the digest and time of the last successful one
+func SetReplica(clonedImage *cachev1alpha1.ClonedImage, status cachev1alpha1.ReplicaStatus) {
+	for idx, existing := range clonedImage.Status.Replicas {
+		if existing.Destination != status.Destination {
+			continue
+		}
+		if status.Error != "" {
+			status.DestinationDigest, status.LastSyncTime = existing.DestinationDigest, existing.LastSyncTime
+		}
+		clonedImage.Status.Replicas[idx] = status
+		return
+	}
+
+	clonedImage.Status.Replicas = append(clonedImage.Status.Replicas, status)
+}
+
+// AddAuthorizedNamespace adds namespace to the namespaces allowed to pull the source of clonedImage unless
+// already listed, reporting whether it was added
+func AddAuthorizedNamespace(clonedImage *cachev1alpha1.ClonedImage, namespace string) bool {
+	for _, existing := range clonedImage.Status.AuthorizedNamespaces {
+		if existing == namespace {
+			return false
+		}
+	}
+
+	clonedImage.Status.AuthorizedNamespaces = append(clonedImage.Status.AuthorizedNamespaces, namespace)
+	return true
+}
+
+// AddAuthorizedPullSecret adds the <namespace>/<name> of a pull secret found able to pull the source
+// of clonedImage unless already listed, reporting whether it was added
+func AddAuthorizedPullSecret(clonedImage *cachev1alpha1.ClonedImage, secret string) bool {
+	for _, existing := range clonedImage.Status.AuthorizedPullSecrets {
+		if existing == secret {
+			return false
+		}
+	}
+
+	clonedImage.Status.AuthorizedPullSecrets = append(clonedImage.Status.AuthorizedPullSecrets, secret)
+	return true
+}
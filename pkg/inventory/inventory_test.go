@@ -0,0 +1,179 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	cachev1alpha1 "github.com/Tiemma/image-clone-controller/api/v1alpha1"
+	"github.com/Tiemma/image-clone-controller/pkg/docker"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestName(t *testing.T) {
+	specs := []string{
+		"index.docker.io/kube456/nginx:1.21",
+		"registry.example.com/a/very/deeply/nested/repository/path/that/goes/on/and/on/image:tag",
+		"index.docker.io/kube456/nginx@sha256:0123456789abcdef",
+	}
+
+	for _, spec := range specs {
+		res := Name(spec)
+		if errs := validation.IsDNS1123Subdomain(res); len(errs) > 0 {
+			t.Errorf("expected %s to be a valid name, got %s", res, errs)
+		}
+	}
+
+	if Name("docker.io/a/b:1") == Name("docker.io/a-b:1") {
+		t.Errorf("expected distinct destinations to get distinct names")
+	}
+}
+
+func TestRecord(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = cachev1alpha1.AddToScheme(scheme)
+	c := fake.NewClientBuilder().WithScheme(scheme).Build()
+	inv := New(c)
+
+	record := docker.CloneRecord{
+		Source:            "index.docker.io/library/nginx:1.21",
+		Destination:       "index.docker.io/kube456/nginx:1.21",
+		SourceDigest:      "sha256:abc",
+		DestinationDigest: "sha256:abc",
+	}
+	workloads := []docker.Workload{
+		{Kind: "Deployment", Namespace: "default", Name: "web"},
+		{Kind: "DaemonSet", Namespace: "default", Name: "agent"},
+		{Kind: "Deployment", Namespace: "default", Name: "web"},
+	}
+	for _, workload := range workloads {
+		if err := inv.Record(context.Background(), record, workload); err != nil {
+			t.Errorf("error occured recording clone: %s", err)
+		}
+	}
+
+	clonedImage := &cachev1alpha1.ClonedImage{}
+	if err := c.Get(context.Background(), types.NamespacedName{Name: Name(record.Destination)}, clonedImage); err != nil {
+		t.Errorf("error occured getting cloned image: %s", err)
+	}
+	if !strings.HasPrefix(clonedImage.Name, "index.docker.io-kube456-nginx-1.21") {
+		t.Errorf("expected name to describe the destination, got %s", clonedImage.Name)
+	}
+	if len(clonedImage.Status.Workloads) != 2 {
+		t.Errorf("expected 2 distinct workloads, got %v", clonedImage.Status.Workloads)
+	}
+	if clonedImage.Status.FirstSyncTime == nil || clonedImage.Status.LastSyncTime == nil {
+		t.Errorf("expected sync times to be recorded")
+	}
+
+	record.Access, record.Authorized, record.PullSecrets = docker.AccessRestricted, true, []string{"regcred"}
+	for _, namespace := range []string{"team-a", "team-b", "team-a"} {
+		if err := inv.Record(context.Background(), record, docker.Workload{Kind: "Deployment", Namespace: namespace, Name: "web"}); err != nil {
+			t.Errorf("error occured recording clone: %s", err)
+		}
+	}
+	if err := c.Get(context.Background(), types.NamespacedName{Name: Name(record.Destination)}, clonedImage); err != nil {
+		t.Errorf("error occured getting cloned image: %s", err)
+	}
+	if clonedImage.Status.Access != docker.AccessRestricted || len(clonedImage.Status.AuthorizedNamespaces) != 2 {
+		t.Errorf("expected 2 authorized namespaces of a restricted source, got %s %v", clonedImage.Status.Access, clonedImage.Status.AuthorizedNamespaces)
+	}
+	if strings.Join(clonedImage.Status.AuthorizedPullSecrets, ",") != "team-a/regcred,team-b/regcred" {
+		t.Errorf("expected the pull secrets of both namespaces to be recorded, got %v", clonedImage.Status.AuthorizedPullSecrets)
+	}
+
+	record.Replicas = []string{"harbor-us.example.com/kube456/team-a/nginx:1.21"}
+	for i := 0; i < 2; i++ {
+		if err := inv.Record(context.Background(), record, docker.Workload{}); err != nil {
+			t.Errorf("error occured recording clone: %s", err)
+		}
+	}
+	if err := c.Get(context.Background(), types.NamespacedName{Name: Name(record.Destination)}, clonedImage); err != nil {
+		t.Errorf("error occured getting cloned image: %s", err)
+	}
+	if len(clonedImage.Status.Replicas) != 1 || clonedImage.Status.Replicas[0].Destination != record.Replicas[0] {
+		t.Errorf("expected the tenant replica to be recorded once, got %v", clonedImage.Status.Replicas)
+	}
+}
+
+func TestRecordReplicas(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = cachev1alpha1.AddToScheme(scheme)
+	c := fake.NewClientBuilder().WithScheme(scheme).Build()
+	inv := New(c)
+
+	record := docker.CloneRecord{
+		Source:            "index.docker.io/library/nginx:1.21",
+		Destination:       "index.docker.io/kube456/nginx:1.21",
+		DestinationDigest: "sha256:abc",
+	}
+	if err := inv.Record(context.Background(), record, docker.Workload{}); err != nil {
+		t.Errorf("error occured recording clone: %s", err)
+	}
+
+	replicas := [][]docker.ReplicaRecord{
+		{
+			{Destination: "eu.example.com/kube456/nginx:1.21", DestinationDigest: "sha256:abc"},
+			{Destination: "us.example.com/kube456/nginx:1.21", DestinationDigest: "sha256:abc"},
+		},
+		{
+			{Destination: "us.example.com/kube456/nginx:1.21", Err: errors.New("unreachable")},
+		},
+	}
+	for _, records := range replicas {
+		if err := inv.RecordReplicas(context.Background(), record.Destination, records); err != nil {
+			t.Errorf("error occured recording replicas: %s", err)
+		}
+	}
+
+	clonedImage := &cachev1alpha1.ClonedImage{}
+	if err := c.Get(context.Background(), types.NamespacedName{Name: Name(record.Destination)}, clonedImage); err != nil {
+		t.Errorf("error occured getting cloned image: %s", err)
+	}
+	if len(clonedImage.Status.Replicas) != 2 {
+		t.Fatalf("expected 2 replicas, got %v", clonedImage.Status.Replicas)
+	}
+	failed := clonedImage.Status.Replicas[1]
+	if failed.Error != "unreachable" || failed.DestinationDigest != "sha256:abc" || failed.LastSyncTime == nil {
+		t.Errorf("expected failed replica to keep its last sync, got %+v", failed)
+	}
+}
+
+func TestRecordUse(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = cachev1alpha1.AddToScheme(scheme)
+	c := fake.NewClientBuilder().WithScheme(scheme).Build()
+	inv := New(c)
+
+	record := docker.CloneRecord{Source: "index.docker.io/library/nginx:1.21", Destination: "index.docker.io/kube456/nginx:1.21"}
+	if err := inv.Record(context.Background(), record, docker.Workload{Kind: "Deployment", Namespace: "team-a", Name: "web"}); err != nil {
+		t.Errorf("error occured recording clone: %s", err)
+	}
+	use := docker.ImageUse{Destination: record.Destination, Authorized: true, PullSecrets: []string{"registry"}}
+	for i := 0; i < 2; i++ {
+		if err := inv.RecordUse(context.Background(), use, docker.Workload{Kind: "Deployment", Namespace: "team-b", Name: "web"}); err != nil {
+			t.Errorf("error occured recording use: %s", err)
+		}
+	}
+	if err := inv.RecordUse(context.Background(), docker.ImageUse{Destination: "index.docker.io/kube456/redis:6"}, docker.Workload{}); err != nil {
+		t.Errorf("expected uses of unrecorded images to be ignored, got %s", err)
+	}
+
+	clonedImage := &cachev1alpha1.ClonedImage{}
+	if err := c.Get(context.Background(), types.NamespacedName{Name: Name(record.Destination)}, clonedImage); err != nil {
+		t.Errorf("error occured getting cloned image: %s", err)
+	}
+	if len(clonedImage.Status.Workloads) != 2 || clonedImage.Status.Workloads[1].Namespace != "team-b" {
+		t.Errorf("expected the workload using the cached image to be recorded once, got %v", clonedImage.Status.Workloads)
+	}
+	if namespaces := clonedImage.Status.AuthorizedNamespaces; len(namespaces) != 1 || namespaces[0] != "team-b" {
+		t.Errorf("expected the namespace using the cached image to be authorized, got %v", namespaces)
+	}
+	if secrets := clonedImage.Status.AuthorizedPullSecrets; len(secrets) != 1 || secrets[0] != "team-b/registry" {
+		t.Errorf("expected the pull secret of the workload to be authorized, got %v", secrets)
+	}
+}
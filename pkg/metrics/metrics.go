@@ -21,13 +21,181 @@ var (
 		},
 		[]string{"name", "namespace", "kind", "image", "err_type"},
 	)
+
+	parkedWorkloads = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_clone_parked_total",
+			Help: "Number of times a workload exhausted its retries and was parked",
+		},
+		[]string{"name", "namespace", "kind", "err_type"},
+	)
+
+	skippedReconciles = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_clone_reconciles_skipped_total",
+			Help: "Number of reconciles that returned early without contacting a registry",
+		},
+		[]string{"kind", "reason"},
+	)
+
+	flappingWorkloads = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_clone_flapping_total",
+			Help: "Number of times a workload kept having its rewritten images reverted",
+		},
+		[]string{"name", "namespace", "kind", "last_writer"},
+	)
+
+	registrySwitches = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_clone_registry_switches_total",
+			Help: "Number of workloads switched between the cache and upstream images due to registry health",
+		},
+		[]string{"kind", "direction"},
+	)
+
+	upstreamDrift = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_clone_upstream_drift_total",
+			Help: "Number of times an upstream tag was found pointing at a different image than its cached copy",
+		},
+		[]string{"registry", "policy"},
+	)
+
+	tagConflicts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_clone_tag_conflicts_total",
+			Help: "Number of destination tags found holding a different image than the one being cloned",
+		},
+		[]string{"policy"},
+	)
+
+	auditedImages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_clone_audited_images_total",
+			Help: "Number of cached images checked by the auditor by result",
+		},
+		[]string{"result"},
+	)
+
+	pullFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_clone_pull_failures_total",
+			Help: "Number of pods found failing to pull a rewritten image by likely cause",
+		},
+		[]string{"kind", "cause"},
+	)
+
+	garbageCollectedImages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_clone_garbage_collected_total",
+			Help: "Number of unused cached images deleted, or that would be in a dry run, by the garbage collector",
+		},
+		[]string{"action"},
+	)
+
+	replications = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_clone_replications_total",
+			Help: "Number of images written to replica destinations by result",
+		},
+		[]string{"registry", "result"},
+	)
+
+	privateImages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_clone_private_images_total",
+			Help: "Number of private source images found while cloning by policy and decision",
+		},
+		[]string{"policy", "decision"},
+	)
+
+	scans = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_clone_scans_total",
+			Help: "Number of staged images scanned by result",
+		},
+		[]string{"result"},
+	)
+
+	copiedArtifacts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_clone_artifacts_total",
+			Help: "Number of signatures, attestations, SBOMs and referrers copied alongside images by kind and result",
+		},
+		[]string{"kind", "result"},
+	)
+
+	signatureVerifications = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_clone_signature_verifications_total",
+			Help: "Number of source image signature checks by result",
+		},
+		[]string{"result"},
+	)
 )
 
 func UpdateFailedImageClonesMetric(name, namespace, kind, image string, errType errors.ErrType) {
 	failedImageClones.WithLabelValues(name, namespace, kind, image, string(errType)).Add(1)
 }
 
+func UpdateParkedWorkloadsMetric(name, namespace, kind string, errType errors.ErrType) {
+	parkedWorkloads.WithLabelValues(name, namespace, kind, string(errType)).Add(1)
+}
+
+func UpdateSkippedReconcilesMetric(kind, reason string) {
+	skippedReconciles.WithLabelValues(kind, reason).Add(1)
+}
+
+func UpdateFlappingWorkloadsMetric(name, namespace, kind, lastWriter string) {
+	flappingWorkloads.WithLabelValues(name, namespace, kind, lastWriter).Add(1)
+}
+
+func UpdateRegistrySwitchesMetric(kind, direction string) {
+	registrySwitches.WithLabelValues(kind, direction).Add(1)
+}
+
+func UpdateUpstreamDriftMetric(registry, policy string) {
+	upstreamDrift.WithLabelValues(registry, policy).Add(1)
+}
+
+func UpdateTagConflictsMetric(policy string) {
+	tagConflicts.WithLabelValues(policy).Add(1)
+}
+
+func UpdateAuditedImagesMetric(result string) {
+	auditedImages.WithLabelValues(result).Add(1)
+}
+
+func UpdatePullFailuresMetric(kind, cause string) {
+	pullFailures.WithLabelValues(kind, cause).Add(1)
+}
+
+func UpdateGarbageCollectedImagesMetric(action string) {
+	garbageCollectedImages.WithLabelValues(action).Add(1)
+}
+
+func UpdateReplicationsMetric(registry, result string) {
+	replications.WithLabelValues(registry, result).Add(1)
+}
+
+func UpdatePrivateImagesMetric(policy, decision string) {
+	privateImages.WithLabelValues(policy, decision).Add(1)
+}
+
+func UpdateScansMetric(result string) {
+	scans.WithLabelValues(result).Add(1)
+}
+
+func UpdateCopiedArtifactsMetric(kind, result string) {
+	copiedArtifacts.WithLabelValues(kind, result).Add(1)
+}
+
+func UpdateSignatureVerificationsMetric(result string) {
+	signatureVerifications.WithLabelValues(result).Add(1)
+}
+
 func Init() {
 	// Register custom metrics with the global prometheus registry
-	ctrlMetrics.Registry.MustRegister(ImageCloneTotal, failedImageClones)
+	ctrlMetrics.Registry.MustRegister(ImageCloneTotal, failedImageClones, parkedWorkloads, skippedReconciles, flappingWorkloads, registrySwitches, upstreamDrift, tagConflicts, auditedImages, pullFailures, garbageCollectedImages, replications, privateImages, scans, copiedArtifacts, signatureVerifications)
 }
@@ -0,0 +1,150 @@
+package provisioner
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+const (
+	ecrService            = "ecr"
+	ecrTarget             = "AmazonEC2ContainerRegistry_V20150921.CreateRepository"
+	ecrContentType        = "application/x-amz-json-1.1"
+	ecrAlreadyExists      = "RepositoryAlreadyExistsException"
+	sigV4Algorithm        = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat       = "20060102T150405Z"
+	sigV4DateFormat       = "20060102"
+	ecrRegistryHostSuffix = ".amazonaws.com"
+)
+
+// ECR creates repositories through the CreateRepository action of the ECR API, signing
+// requests with AWS Signature Version 4
+type ECR struct {
+	// Endpoint of the API, https://api.ecr.<region>.amazonaws.com when empty
+	Endpoint string
+	// Region of the registry, parsed from <account>.dkr.ecr.<region>.amazonaws.com hosts when empty
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Client          *http.Client
+
+	now func() time.Time
+}
+
+type ecrCreateRepository struct {
+	RegistryID     string `json:"registryId,omitempty"`
+	RepositoryName string `json:"repositoryName"`
+}
+
+// Provision creates repository unless it exists, the registry credentials are not used
+func (e *ECR) Provision(ctx context.Context, repository name.Repository, _ authn.Authenticator) error {
+	account, region := parseECRHost(repository.RegistryStr())
+	if e.Region != "" {
+		region = e.Region
+	}
+	if region == "" {
+		return fmt.Errorf("cannot determine the region of registry %s", repository.RegistryStr())
+	}
+	endpoint := e.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://api.ecr.%s.amazonaws.com", region)
+	}
+
+	body := ecrCreateRepository{RegistryID: account, RepositoryName: repository.RepositoryStr()}
+	status, respBody, err := postJSON(ctx, e.Client, endpoint, body, func(req *http.Request, data []byte) error {
+		req.Header.Set("Content-Type", ecrContentType)
+		req.Header.Set("X-Amz-Target", ecrTarget)
+		e.sign(req, data, region)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if isSuccess(status) || strings.Contains(respBody, ecrAlreadyExists) {
+		return nil
+	}
+
+	return fmt.Errorf("ecr refused to create repository %s with status %d: %s", repository.RepositoryStr(), status, respBody)
+}
+
+// parseECRHost returns the account and region of <account>.dkr.ecr.<region>.amazonaws.com registries
+func parseECRHost(host string) (string, string) {
+	parts := strings.Split(strings.TrimSuffix(host, ecrRegistryHostSuffix), ".")
+	if len(parts) != 4 || parts[1] != "dkr" || parts[2] != "ecr" {
+		return "", ""
+	}
+
+	return parts[0], parts[3]
+}
+
+// sign adds the AWS Signature Version 4 headers of req with body data
+func (e *ECR) sign(req *http.Request, data []byte, region string) {
+	now := time.Now
+	if e.now != nil {
+		now = e.now
+	}
+	t := now().UTC()
+	amzDate, date := t.Format(sigV4TimeFormat), t.Format(sigV4DateFormat)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if e.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", e.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for key := range req.Header {
+		headers[strings.ToLower(key)] = strings.TrimSpace(req.Header.Get(key))
+	}
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var canonicalHeaders strings.Builder
+	for _, key := range keys {
+		canonicalHeaders.WriteString(key + ":" + headers[key] + "\n")
+	}
+	signedHeaders := strings.Join(keys, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, hexSHA256(data),
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, ecrService, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{sigV4Algorithm, amzDate, scope, hexSHA256([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+e.SecretAccessKey), date)
+	for _, part := range []string{region, ecrService, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, e.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,88 @@
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// Harbor creates the project of a repository through the Harbor v2 API, authenticating
+// with the registry credentials. Harbor creates repositories on push within existing projects
+type Harbor struct {
+	// URL of the Harbor API, the registry itself when empty
+	URL    string
+	Client *http.Client
+}
+
+type harborProject struct {
+	ProjectName string            `json:"project_name"`
+	Metadata    map[string]string `json:"metadata"`
+}
+
+// Provision creates the private project holding repository unless it exists. Existence is checked
+// first as robot accounts pushing to existing projects are usually not allowed to create projects
+func (h *Harbor) Provision(ctx context.Context, repository name.Repository, auth authn.Authenticator) error {
+	project := strings.SplitN(repository.RepositoryStr(), "/", 2)[0]
+	base := h.URL
+	if base == "" {
+		base = fmt.Sprintf("%s://%s", repository.Registry.Scheme(), repository.RegistryStr())
+	}
+	projectsURL := strings.TrimSuffix(base, "/") + "/api/v2.0/projects"
+
+	exists, err := h.projectExists(ctx, projectsURL, project, auth)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	body := harborProject{ProjectName: project, Metadata: map[string]string{"public": "false"}}
+	status, respBody, err := postJSON(ctx, h.Client, projectsURL, body, func(req *http.Request, _ []byte) error {
+		return setAuth(req, auth)
+	})
+	if err != nil {
+		return err
+	}
+	if isSuccess(status) || status == http.StatusConflict {
+		return nil
+	}
+
+	return fmt.Errorf("harbor refused to create project %s with status %d: %s", project, status, respBody)
+}
+
+// projectExists checks whether project exists through HEAD /projects, which answers 200 or 404
+func (h *Harbor) projectExists(ctx context.Context, projectsURL, project string, auth authn.Authenticator) (bool, error) {
+	req, err := http.NewRequest(http.MethodHead, projectsURL+"?project_name="+url.QueryEscape(project), nil)
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	if err := setAuth(req, auth); err != nil {
+		return false, err
+	}
+
+	client := h.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case isSuccess(resp.StatusCode):
+		return true, nil
+	case resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("harbor refused to check project %s with status %d", project, resp.StatusCode)
+	}
+}
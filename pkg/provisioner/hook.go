@@ -0,0 +1,37 @@
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// Hook asks an HTTP endpoint to create repositories, any 2xx answer meaning the repository exists
+type Hook struct {
+	URL    string
+	Client *http.Client
+}
+
+// hookRequest is the JSON body posted to the hook
+type hookRequest struct {
+	Registry   string `json:"registry"`
+	Repository string `json:"repository"`
+	Name       string `json:"name"`
+}
+
+// Provision posts repository to the hook, registry credentials are not forwarded
+func (h *Hook) Provision(ctx context.Context, repository name.Repository, _ authn.Authenticator) error {
+	body := hookRequest{Registry: repository.RegistryStr(), Repository: repository.RepositoryStr(), Name: repository.Name()}
+	status, respBody, err := postJSON(ctx, h.Client, h.URL, body, nil)
+	if err != nil {
+		return err
+	}
+	if !isSuccess(status) {
+		return fmt.Errorf("provisioning hook refused repository %s with status %d: %s", repository.Name(), status, respBody)
+	}
+
+	return nil
+}
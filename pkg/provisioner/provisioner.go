@@ -0,0 +1,99 @@
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+)
+
+// Kind names a provisioner implementation
+type Kind string
+
+const (
+	KindHarbor Kind = "harbor"
+	KindECR    Kind = "ecr"
+	KindHook   Kind = "hook"
+)
+
+// maxErrorBody is the number of bytes of an error response kept in the returned error
+const maxErrorBody = 512
+
+var defaultClient = &http.Client{Timeout: 30 * time.Second}
+
+// ParseKind parses a REPOSITORY_PROVISIONER value, an empty string disabling provisioning
+func ParseKind(str string) (Kind, error) {
+	switch kind := Kind(strings.ToLower(str)); kind {
+	case "", KindHarbor, KindECR, KindHook:
+		return kind, nil
+	default:
+		return "", fmt.Errorf("repository provisioner must be one of %s, %s or %s, got %q", KindHarbor, KindECR, KindHook, str)
+	}
+}
+
+// postJSON sends body as JSON to url, returning the response status and the start of its body
+func postJSON(ctx context.Context, client *http.Client, url string, body interface{}, prepare func(*http.Request, []byte) error) (int, string, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return 0, "", err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return 0, "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if prepare != nil {
+		if err := prepare(req, data); err != nil {
+			return 0, "", err
+		}
+	}
+
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if err != nil {
+		return resp.StatusCode, "", err
+	}
+
+	return resp.StatusCode, string(respBody), nil
+}
+
+// setAuth authenticates req with the registry credentials of auth, if any
+func setAuth(req *http.Request, auth authn.Authenticator) error {
+	if auth == nil {
+		return nil
+	}
+
+	config, err := auth.Authorization()
+	if err != nil {
+		return err
+	}
+	switch {
+	case config.Username != "" || config.Password != "":
+		req.SetBasicAuth(config.Username, config.Password)
+	case config.RegistryToken != "":
+		req.Header.Set("Authorization", "Bearer "+config.RegistryToken)
+	}
+
+	return nil
+}
+
+func isSuccess(status int) bool {
+	return status >= 200 && status < 300
+}
@@ -0,0 +1,132 @@
+package provisioner
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+func TestHook(t *testing.T) {
+	var received hookRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil || received.Repository == "kube456/refused" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	hook := &Hook{URL: server.URL}
+
+	repository, _ := name.NewRepository("harbor.example.com/kube456/nginx")
+	if err := hook.Provision(context.Background(), repository, authn.Anonymous); err != nil {
+		t.Errorf("error occured provisioning repository: %s", err)
+	}
+	if received.Registry != "harbor.example.com" || received.Repository != "kube456/nginx" {
+		t.Errorf("expected the hook to receive the repository, got %+v", received)
+	}
+
+	refused, _ := name.NewRepository("harbor.example.com/kube456/refused")
+	if err := hook.Provision(context.Background(), refused, authn.Anonymous); err == nil {
+		t.Errorf("expected non 2xx answers to fail provisioning")
+	}
+}
+
+func TestHarbor(t *testing.T) {
+	projects := map[string]bool{"existing": true}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "admin" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/api/v2.0/projects" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.Method == http.MethodHead {
+			if !projects[r.URL.Query().Get("project_name")] {
+				w.WriteHeader(http.StatusNotFound)
+			}
+			return
+		}
+		project := harborProject{}
+		if json.NewDecoder(r.Body).Decode(&project) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if projects[project.ProjectName] {
+			// Robot accounts may push to existing projects but not create any
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		projects[project.ProjectName] = true
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	harbor := &Harbor{URL: server.URL}
+	auth := authn.FromConfig(authn.AuthConfig{Username: "admin", Password: "secret"})
+
+	for _, repo := range []string{"harbor.example.com/team-a/nginx", "harbor.example.com/existing/nginx"} {
+		repository, _ := name.NewRepository(repo)
+		if err := harbor.Provision(context.Background(), repository, auth); err != nil {
+			t.Errorf("error occured provisioning %s: %s", repo, err)
+		}
+	}
+	if !projects["team-a"] {
+		t.Errorf("expected project team-a to be created")
+	}
+
+	repository, _ := name.NewRepository("harbor.example.com/team-b/nginx")
+	if err := harbor.Provision(context.Background(), repository, authn.Anonymous); err == nil {
+		t.Errorf("expected unauthenticated requests to fail provisioning")
+	}
+}
+
+func TestECR(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if r.Header.Get("X-Amz-Target") != ecrTarget || !strings.HasPrefix(auth, sigV4Algorithm+" Credential=AKID/20210102/eu-west-1/ecr/aws4_request") ||
+			!strings.Contains(auth, "SignedHeaders=content-type;host;x-amz-date;x-amz-target") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		body := ecrCreateRepository{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body.RegistryID != "123456789012" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if body.RepositoryName == "kube456/existing" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"__type":"RepositoryAlreadyExistsException"}`))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	ecr := &ECR{
+		Endpoint:        server.URL,
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "secret",
+		now:             func() time.Time { return time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC) },
+	}
+
+	for _, repo := range []string{"kube456/nginx", "kube456/existing"} {
+		repository, _ := name.NewRepository("123456789012.dkr.ecr.eu-west-1.amazonaws.com/" + repo)
+		if err := ecr.Provision(context.Background(), repository, authn.Anonymous); err != nil {
+			t.Errorf("error occured provisioning %s: %s", repo, err)
+		}
+	}
+
+	repository, _ := name.NewRepository("registry.example.com/kube456/nginx")
+	if err := ecr.Provision(context.Background(), repository, authn.Anonymous); err == nil {
+		t.Errorf("expected registries without a region to fail provisioning")
+	}
+}
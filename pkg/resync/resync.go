@@ -0,0 +1,147 @@
+package resync
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// Policy decides what happens to a cached image whose upstream tag moved
+type Policy string
+
+const (
+	// PolicyCopy clones the new upstream image over the cached one
+	PolicyCopy Policy = "copy"
+	// PolicyReport only reports the drift
+	PolicyReport Policy = "report"
+
+	// AnyRegistry is the registry of the rule applying to sources without a rule of their own
+	AnyRegistry = "*"
+)
+
+// Rule schedules resyncs of the images cloned from a source registry
+type Rule struct {
+	Registry string
+	Interval time.Duration
+	Policy   Policy
+}
+
+// ParseRules parses comma separated REGISTRY=interval:policy rules e.g "docker.io=6h:copy,*=24h:report"
+func ParseRules(str string) ([]Rule, error) {
+	var rules []Rule
+	for _, entry := range strings.Split(strings.ReplaceAll(str, " ", ""), ",") {
+		if entry == "" {
+			continue
+		}
+
+		kv := strings.SplitN(entry, "=", 2)
+		parts := strings.Split(kv[len(kv)-1], ":")
+		if len(kv) != 2 || len(parts) != 2 {
+			return nil, fmt.Errorf("resync rule %q must be of the form REGISTRY=interval:policy", entry)
+		}
+
+		interval, err := time.ParseDuration(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("resync interval for %s is not valid: %w", kv[0], err)
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("resync interval for %s must be positive, got %s", kv[0], interval)
+		}
+
+		policy := Policy(parts[1])
+		if policy != PolicyCopy && policy != PolicyReport {
+			return nil, fmt.Errorf("resync policy for %s must be one of %s or %s, got %q", kv[0], PolicyCopy, PolicyReport, policy)
+		}
+
+		rules = append(rules, Rule{Registry: kv[0], Interval: interval, Policy: policy})
+	}
+
+	return rules, nil
+}
+
+// Schedule tracks when each source was last resynced
+type Schedule struct {
+	rules map[string]Rule
+
+	mu   sync.Mutex
+	last map[string]time.Time
+	now  func() time.Time
+}
+
+func NewSchedule(rules []Rule) *Schedule {
+	s := &Schedule{
+		rules: map[string]Rule{},
+		last:  map[string]time.Time{},
+		now:   time.Now,
+	}
+	for _, rule := range rules {
+		s.rules[registryKey(rule.Registry)] = rule
+	}
+
+	return s
+}
+
+// registryKey normalises registry names so docker.io matches the index.docker.io of parsed references
+func registryKey(registry string) string {
+	if registry == AnyRegistry {
+		return registry
+	}
+	if reg, err := name.NewRegistry(registry); err == nil {
+		return reg.RegistryStr()
+	}
+
+	return registry
+}
+
+// Interval returns how often the schedule should be checked, the shortest interval of its rules
+func (s *Schedule) Interval() time.Duration {
+	var interval time.Duration
+	for _, rule := range s.rules {
+		if interval == 0 || rule.Interval < interval {
+			interval = rule.Interval
+		}
+	}
+
+	return interval
+}
+
+// RuleFor returns the rule applying to source
+func (s *Schedule) RuleFor(source string) (Rule, bool) {
+	ref, err := name.ParseReference(source)
+	if err != nil {
+		return Rule{}, false
+	}
+
+	if rule, ok := s.rules[ref.Context().RegistryStr()]; ok {
+		return rule, true
+	}
+	rule, ok := s.rules[AnyRegistry]
+	return rule, ok
+}
+
+// Due returns the rule of source when it has not been resynced within the rule interval,
+// recording the resync as done. Sources pinned by digest never drift and are never due
+func (s *Schedule) Due(source string) (Rule, bool) {
+	if strings.Contains(source, "@") {
+		return Rule{}, false
+	}
+
+	rule, ok := s.RuleFor(source)
+	if !ok {
+		return Rule{}, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if last, ok := s.last[source]; ok && now.Sub(last) < rule.Interval {
+		return Rule{}, false
+	}
+	s.last[source] = now
+
+	return rule, true
+}
@@ -0,0 +1,73 @@
+package resync
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRules(t *testing.T) {
+	tests := []struct {
+		str     string
+		rules   []Rule
+		wantErr bool
+	}{
+		{str: "", rules: nil},
+		{str: "docker.io=6h:copy, *=24h:report", rules: []Rule{
+			{Registry: "docker.io", Interval: 6 * time.Hour, Policy: PolicyCopy},
+			{Registry: AnyRegistry, Interval: 24 * time.Hour, Policy: PolicyReport},
+		}},
+		{str: "docker.io", wantErr: true},
+		{str: "docker.io=6h", wantErr: true},
+		{str: "docker.io=6h:delete", wantErr: true},
+		{str: "docker.io=0s:copy", wantErr: true},
+		{str: "docker.io=soon:copy", wantErr: true},
+	}
+
+	for _, test := range tests {
+		rules, err := ParseRules(test.str)
+		if (err != nil) != test.wantErr {
+			t.Errorf("expected error %v for %q, got %v", test.wantErr, test.str, err)
+			continue
+		}
+		if len(rules) != len(test.rules) {
+			t.Errorf("expected %v for %q, got %v", test.rules, test.str, rules)
+			continue
+		}
+		for i := range rules {
+			if rules[i] != test.rules[i] {
+				t.Errorf("expected %v for %q, got %v", test.rules[i], test.str, rules[i])
+			}
+		}
+	}
+}
+
+func TestScheduleDue(t *testing.T) {
+	now := time.Now()
+	schedule := NewSchedule([]Rule{
+		{Registry: "docker.io", Interval: time.Hour, Policy: PolicyCopy},
+		{Registry: "ghcr.io", Interval: 10 * time.Minute, Policy: PolicyReport},
+	})
+	schedule.now = func() time.Time { return now }
+
+	if interval := schedule.Interval(); interval != 10*time.Minute {
+		t.Errorf("expected the shortest interval 10m, got %s", interval)
+	}
+
+	if rule, ok := schedule.Due("nginx:1.21"); !ok || rule.Policy != PolicyCopy {
+		t.Errorf("expected nginx:1.21 to be due with the docker hub rule, got %v", rule)
+	}
+	if _, ok := schedule.Due("nginx:1.21"); ok {
+		t.Errorf("expected nginx:1.21 not to be due again within its interval")
+	}
+	if _, ok := schedule.Due("quay.io/coreos/etcd:v3.4"); ok {
+		t.Errorf("expected sources without a rule never to be due")
+	}
+	if _, ok := schedule.Due("nginx@sha256:b5bb9d8014a0f9b1d61e21e796d78dccdf1352f23cd32812f4850b878ae4944c"); ok {
+		t.Errorf("expected digest references never to be due")
+	}
+
+	now = now.Add(time.Hour)
+	if _, ok := schedule.Due("nginx:1.21"); !ok {
+		t.Errorf("expected nginx:1.21 to be due once its interval elapsed")
+	}
+}
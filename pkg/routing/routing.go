@@ -0,0 +1,363 @@
+package routing
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/Tiemma/image-clone-controller/pkg/signature"
+	"github.com/google/go-containerregistry/pkg/name"
+	"sigs.k8s.io/yaml"
+)
+
+// Selection decides which destination of a replicated route workloads are rewritten to
+type Selection string
+
+const (
+	// SelectPrimary always rewrites workloads to the route destination
+	SelectPrimary Selection = "primary"
+	// SelectNearest rewrites workloads to the destination in the region their pods are scheduled to
+	SelectNearest Selection = "nearest"
+)
+
+// Replica is an additional destination every image of a route is copied to
+type Replica struct {
+	// Destination repository e.g harbor-us.example.com/dockerhub
+	Destination string `json:"destination"`
+	// Region the destination is closest to, matched against the topology.kubernetes.io/region node label
+	Region string `json:"region,omitempty"`
+	// DockerConfig is the folder holding the config.json used to authenticate to the destination
+	DockerConfig string `json:"dockerConfig,omitempty"`
+
+	// registry and prefix of the destination, spelled like parsed references
+	destinationRegistry string
+	destinationPrefix   string
+}
+
+// Route sends the images matching all of its criteria to a destination repository.
+// Criteria left empty match everything
+type Route struct {
+	// Registry hosting the source image e.g docker.io
+	Registry string `json:"registry,omitempty"`
+	// Prefix of the source repository e.g library/ or myorg/
+	Prefix string `json:"prefix,omitempty"`
+	// Namespace of the workload using the image
+	Namespace string `json:"namespace,omitempty"`
+
+	// Destination repository images are cloned to e.g harbor.example.com/dockerhub
+	Destination string `json:"destination,omitempty"`
+	// Region the destination is closest to
+	Region string `json:"region,omitempty"`
+	// Skip leaves matching images where they are
+	Skip bool `json:"skip,omitempty"`
+	// DockerConfig is the folder holding the config.json used to authenticate to the destination,
+	// DOCKER_CONFIG is used when empty
+	DockerConfig string `json:"dockerConfig,omitempty"`
+
+	// Replicas every image is also copied to
+	Replicas []Replica `json:"replicas,omitempty"`
+	// Selection of the destination workloads are rewritten to, defaulting to primary
+	Selection Selection `json:"selection,omitempty"`
+
+	// Signatures source images must carry to be cloned, unsigned images being cloned when unset
+	Signatures *signature.Policy `json:"signatures,omitempty"`
+
+	destinationRegistry string
+	destinationPrefix   string
+	verifier            *signature.Verifier
+}
+
+// LastSegment returns the image name and tag of url without its repository
+func LastSegment(url string) string {
+	imageURLParts := strings.Split(url, "/")
+	return imageURLParts[len(imageURLParts)-1]
+}
+
+// URL returns where ref is cloned to following the route
+func (r Route) URL(ref name.Reference) string {
+	return fmt.Sprintf("%s/%s", r.Destination, LastSegment(ref.Name()))
+}
+
+// ReplicaURLs returns where the image cloned to url is replicated to
+func (r Route) ReplicaURLs(url string) []string {
+	var urls []string
+	for _, replica := range r.Replicas {
+		urls = append(urls, fmt.Sprintf("%s/%s", replica.Destination, LastSegment(url)))
+	}
+
+	return urls
+}
+
+// Select returns the destination of the image cloned to url that workloads running in region
+// are rewritten to. The primary destination is used when no replica is in that region
+func (r Route) Select(url, region string) string {
+	if r.Selection != SelectNearest || region == "" || r.Region == region {
+		return url
+	}
+
+	for _, replica := range r.Replicas {
+		if replica.Region == region {
+			return fmt.Sprintf("%s/%s", replica.Destination, LastSegment(url))
+		}
+	}
+
+	return url
+}
+
+// DestinationRegistry returns the registry hosting the destination, or an empty string for skip routes
+func (r Route) DestinationRegistry() string {
+	if r.Skip {
+		return ""
+	}
+
+	return r.destinationRegistry
+}
+
+// DestinationPrefixes returns the repositories of the destination and replicas, spelled like parsed references
+func (r Route) DestinationPrefixes() []string {
+	if r.Skip {
+		return nil
+	}
+
+	prefixes := []string{r.destinationPrefix}
+	for _, replica := range r.Replicas {
+		prefixes = append(prefixes, replica.destinationPrefix)
+	}
+
+	return prefixes
+}
+
+// Verifier returns the verifier of the signatures of source images, or nil when they are not checked
+func (r Route) Verifier() *signature.Verifier {
+	return r.verifier
+}
+
+// ForTenant returns the route used for the workloads of namespace. Its images go to destination
+// without replicas when set, otherwise to a namespace folder of the route destination and replicas
+func (r Route) ForTenant(namespace, destination string) (Route, error) {
+	if r.Skip {
+		return r, nil
+	}
+
+	var err error
+	if destination != "" {
+		r.Destination, r.Replicas = destination, nil
+	} else {
+		r.Destination = strings.TrimSuffix(r.Destination, "/") + "/" + namespace
+		replicas := make([]Replica, 0, len(r.Replicas))
+		for _, replica := range r.Replicas {
+			replica.Destination = strings.TrimSuffix(replica.Destination, "/") + "/" + namespace
+			if replica.destinationRegistry, replica.destinationPrefix, err = ParseDestination(replica.Destination); err != nil {
+				return r, err
+			}
+			replicas = append(replicas, replica)
+		}
+		r.Replicas = replicas
+	}
+
+	r.destinationRegistry, r.destinationPrefix, err = ParseDestination(r.Destination)
+	return r, err
+}
+
+func (r Route) matches(ref name.Reference, namespace string) bool {
+	if r.Registry != "" && normaliseRegistry(r.Registry) != ref.Context().RegistryStr() {
+		return false
+	}
+	if r.Prefix != "" && !strings.HasPrefix(ref.Context().RepositoryStr(), r.Prefix) {
+		return false
+	}
+
+	return r.Namespace == "" || r.Namespace == namespace
+}
+
+func normaliseRegistry(registry string) string {
+	if reg, err := name.NewRegistry(registry); err == nil {
+		return reg.RegistryStr()
+	}
+
+	return registry
+}
+
+// ParseDestination returns the registry and prefix of a destination repository spelled like parsed references
+func ParseDestination(destination string) (string, string, error) {
+	if _, err := name.NewRepository(destination); err != nil {
+		return "", "", err
+	}
+
+	parts := strings.SplitN(strings.TrimSuffix(destination, "/"), "/", 2)
+	registry := normaliseRegistry(parts[0])
+	prefix := registry
+	if len(parts) == 2 {
+		prefix += "/" + parts[1]
+	}
+
+	return registry, prefix, nil
+}
+
+// Table picks the route of every image, the first matching route wins
+type Table struct {
+	routes []Route
+}
+
+type file struct {
+	Routes []Route `json:"routes"`
+}
+
+// New validates routes and returns their table. A catch-all route to defaultDestination
+// is appended when it is set
+func New(routes []Route, defaultDestination string) (*Table, error) {
+	if defaultDestination != "" {
+		routes = append(routes, Route{Destination: defaultDestination})
+	}
+
+	t := &Table{}
+	for idx, route := range routes {
+		if route.Skip {
+			t.routes = append(t.routes, route)
+			continue
+		}
+
+		var err error
+		route.destinationRegistry, route.destinationPrefix, err = ParseDestination(route.Destination)
+		if err != nil {
+			return nil, fmt.Errorf("route %d destination %q is not valid: %w", idx, route.Destination, err)
+		}
+
+		switch route.Selection {
+		case "":
+			route.Selection = SelectPrimary
+		case SelectPrimary, SelectNearest:
+		default:
+			return nil, fmt.Errorf("route %d selection must be one of %s or %s, got %q", idx, SelectPrimary, SelectNearest, route.Selection)
+		}
+
+		replicas := make([]Replica, 0, len(route.Replicas))
+		for _, replica := range route.Replicas {
+			replica.destinationRegistry, replica.destinationPrefix, err = ParseDestination(replica.Destination)
+			if err != nil {
+				return nil, fmt.Errorf("route %d replica destination %q is not valid: %w", idx, replica.Destination, err)
+			}
+			replicas = append(replicas, replica)
+		}
+		route.Replicas = replicas
+
+		if route.Signatures != nil {
+			if route.verifier, err = signature.NewVerifier(*route.Signatures); err != nil {
+				return nil, fmt.Errorf("route %d signatures are not valid: %w", idx, err)
+			}
+		}
+
+		t.routes = append(t.routes, route)
+	}
+
+	return t, nil
+}
+
+// Load reads the routes of a YAML file of the form
+//
+//	routes:
+//	- registry: docker.io
+//	  destination: harbor.example.com/dockerhub
+func Load(path, defaultDestination string) (*Table, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f := file{}
+	if err := yaml.UnmarshalStrict(data, &f); err != nil {
+		return nil, fmt.Errorf("cannot parse routes file %s: %w", path, err)
+	}
+
+	return New(f.Routes, defaultDestination)
+}
+
+// Default returns a table cloning every image to destination, or an empty table when it is not valid
+func Default(destination string) *Table {
+	if t, err := New(nil, destination); err == nil {
+		return t
+	}
+
+	return &Table{}
+}
+
+// Match returns the route of ref for a workload of namespace
+func (t *Table) Match(ref name.Reference, namespace string) (Route, bool) {
+	for _, route := range t.routes {
+		if route.matches(ref, namespace) {
+			return route, true
+		}
+	}
+
+	return Route{}, false
+}
+
+// Destination returns the url ref is cloned to, or false when it stays where it is
+func (t *Table) Destination(ref name.Reference, namespace string) (string, bool) {
+	route, ok := t.Match(ref, namespace)
+	if !ok || route.Skip {
+		return "", false
+	}
+
+	return route.URL(ref), true
+}
+
+// IsDestination reports whether image lives in the destination or a replica of any route
+func (t *Table) IsDestination(image string) bool {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return false
+	}
+
+	for _, route := range t.routes {
+		if route.Skip {
+			continue
+		}
+		if Within(ref, route.destinationPrefix) {
+			return true
+		}
+		for _, replica := range route.Replicas {
+			if Within(ref, replica.destinationPrefix) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Within reports whether the repository of ref is prefix or one of its sub repositories
+func Within(ref name.Reference, prefix string) bool {
+	return RepositoryWithin(ref.Context(), prefix)
+}
+
+// RepositoryWithin reports whether repository is prefix or one of its sub repositories
+func RepositoryWithin(repository name.Repository, prefix string) bool {
+	path := repository.RegistryStr() + "/" + repository.RepositoryStr()
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// DockerConfigs maps destination prefixes to the docker config folder used to authenticate
+// to them, so routes sharing a registry may use different credentials. The first route or
+// replica using a prefix wins
+func (t *Table) DockerConfigs() map[string]string {
+	configs := map[string]string{}
+	add := func(prefix, dockerConfig string) {
+		if _, ok := configs[prefix]; !ok && prefix != "" && dockerConfig != "" {
+			configs[prefix] = dockerConfig
+		}
+	}
+	for _, route := range t.routes {
+		add(route.destinationPrefix, route.DockerConfig)
+		for _, replica := range route.Replicas {
+			add(replica.destinationPrefix, replica.DockerConfig)
+		}
+	}
+
+	return configs
+}
+
+// Routes returns the routes of the table in order
+func (t *Table) Routes() []Route {
+	return t.routes
+}
@@ -0,0 +1,182 @@
+package routing
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Tiemma/image-clone-controller/pkg/signature"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+func TestTableDestination(t *testing.T) {
+	table, err := New([]Route{
+		{Registry: "registry.internal.example.com", Skip: true},
+		{Registry: "docker.io", Namespace: "team-a", Destination: "harbor.example.com/team-a"},
+		{Registry: "docker.io", Destination: "harbor.example.com/dockerhub"},
+		{Registry: "gcr.io", Prefix: "distroless/", Destination: "europe-docker.pkg.dev/project/distroless"},
+	}, "docker.io/kube456")
+	if err != nil {
+		t.Errorf("error occured building routes: %s", err)
+		return
+	}
+
+	tests := []struct {
+		image     string
+		namespace string
+		expected  string
+		routed    bool
+	}{
+		{image: "nginx:1.21", namespace: "default", expected: "harbor.example.com/dockerhub/nginx:1.21", routed: true},
+		{image: "nginx:1.21", namespace: "team-a", expected: "harbor.example.com/team-a/nginx:1.21", routed: true},
+		{image: "gcr.io/distroless/static:nonroot", expected: "europe-docker.pkg.dev/project/distroless/static:nonroot", routed: true},
+		{image: "gcr.io/google-containers/pause:3.2", expected: "docker.io/kube456/pause:3.2", routed: true},
+		{image: "registry.internal.example.com/app:1", routed: false},
+	}
+
+	for _, test := range tests {
+		ref, _ := name.ParseReference(test.image)
+		url, routed := table.Destination(ref, test.namespace)
+		if url != test.expected || routed != test.routed {
+			t.Errorf("expected %s (routed %v) for %s, got %s (routed %v)", test.expected, test.routed, test.image, url, routed)
+		}
+	}
+
+	destinations := map[string]bool{
+		"harbor.example.com/dockerhub/nginx:1.21":                 true,
+		"harbor.example.com/team-a/nginx:1.21":                    true,
+		"index.docker.io/kube456/pause:3.2":                       true,
+		"europe-docker.pkg.dev/project/distroless/static:nonroot": true,
+		"harbor.example.com/dockerhub-other/nginx:1.21":           false,
+		"nginx:1.21":                          false,
+		"registry.internal.example.com/app:1": false,
+	}
+	for image, expected := range destinations {
+		if res := table.IsDestination(image); res != expected {
+			t.Errorf("expected IsDestination(%s) to be %v, got %v", image, expected, res)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "routes")
+	if err != nil {
+		t.Errorf("error occured creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "routes.yaml")
+	routes := []byte("routes:\n- registry: docker.io\n  destination: harbor.example.com/dockerhub\n  dockerConfig: /etc/harbor\n")
+	if err := ioutil.WriteFile(path, routes, 0600); err != nil {
+		t.Errorf("error occured writing routes: %s", err)
+	}
+
+	table, err := Load(path, "")
+	if err != nil {
+		t.Errorf("error occured loading routes: %s", err)
+		return
+	}
+	if len(table.Routes()) != 1 || table.Routes()[0].DockerConfig != "/etc/harbor" || table.Routes()[0].DestinationRegistry() != "harbor.example.com" {
+		t.Errorf("expected a single harbor route, got %v", table.Routes())
+	}
+
+	if err := ioutil.WriteFile(path, []byte("routes:\n- registry: docker.io\n  destinaton: typo\n"), 0600); err != nil {
+		t.Errorf("error occured writing routes: %s", err)
+	}
+	if _, err := Load(path, ""); err == nil {
+		t.Errorf("expected unknown fields to be rejected")
+	}
+}
+
+func TestReplicas(t *testing.T) {
+	table, err := New([]Route{{
+		Destination: "harbor-eu.example.com/dockerhub",
+		Region:      "eu-west-1",
+		Selection:   SelectNearest,
+		Replicas: []Replica{
+			{Destination: "harbor-us.example.com/dockerhub", Region: "us-east-1"},
+			{Destination: "harbor-ap.example.com/dockerhub"},
+		},
+	}}, "")
+	if err != nil {
+		t.Errorf("error occured building routes: %s", err)
+		return
+	}
+
+	route := table.Routes()[0]
+	url := "harbor-eu.example.com/dockerhub/nginx:1.21"
+	regions := map[string]string{
+		"":          url,
+		"eu-west-1": url,
+		"us-east-1": "harbor-us.example.com/dockerhub/nginx:1.21",
+		"sa-east-1": url,
+	}
+	for region, expected := range regions {
+		if res := route.Select(url, region); res != expected {
+			t.Errorf("expected %s to be selected in region %q, got %s", expected, region, res)
+		}
+	}
+
+	expected := []string{"harbor-us.example.com/dockerhub/nginx:1.21", "harbor-ap.example.com/dockerhub/nginx:1.21"}
+	if res := route.ReplicaURLs(url); len(res) != 2 || res[0] != expected[0] || res[1] != expected[1] {
+		t.Errorf("expected replicas %v, got %v", expected, res)
+	}
+	if !table.IsDestination("harbor-ap.example.com/dockerhub/nginx:1.21") {
+		t.Errorf("expected replicas to be destinations")
+	}
+
+	if _, err := New([]Route{{Destination: "harbor.example.com/dockerhub", Selection: "closest"}}, ""); err == nil {
+		t.Errorf("expected unknown selections to be rejected")
+	}
+}
+
+func TestForTenant(t *testing.T) {
+	table, _ := New([]Route{{
+		Destination: "harbor.example.com/dockerhub",
+		Replicas:    []Replica{{Destination: "harbor-us.example.com/dockerhub"}},
+	}}, "")
+	route := table.Routes()[0]
+	ref, _ := name.ParseReference("nginx:1.21")
+
+	derived, err := route.ForTenant("team-a", "")
+	if err != nil {
+		t.Errorf("error occured deriving tenant route: %s", err)
+		return
+	}
+	if url := derived.URL(ref); url != "harbor.example.com/dockerhub/team-a/nginx:1.21" {
+		t.Errorf("expected a namespace folder of the destination, got %s", url)
+	}
+	if replicas := derived.ReplicaURLs(derived.URL(ref)); len(replicas) != 1 || replicas[0] != "harbor-us.example.com/dockerhub/team-a/nginx:1.21" {
+		t.Errorf("expected a namespace folder of the replica, got %v", replicas)
+	}
+
+	overridden, err := route.ForTenant("team-a", "registry.team-a.example.com/images")
+	if err != nil {
+		t.Errorf("error occured overriding tenant route: %s", err)
+		return
+	}
+	if url := overridden.URL(ref); url != "registry.team-a.example.com/images/nginx:1.21" || len(overridden.Replicas) != 0 {
+		t.Errorf("expected the overridden destination without replicas, got %s and %v", url, overridden.Replicas)
+	}
+}
+
+func TestSignatures(t *testing.T) {
+	if _, err := New([]Route{{Destination: "harbor.example.com/dockerhub", Signatures: &signature.Policy{}}}, ""); err == nil {
+		t.Errorf("expected signature policies without keys or trust root to be rejected")
+	}
+
+	dir, _ := ioutil.TempDir("", "routing")
+	defer os.RemoveAll(dir)
+	trustRoot := filepath.Join(dir, "root.pem")
+	_ = ioutil.WriteFile(trustRoot, []byte("not a certificate"), 0600)
+	if _, err := New([]Route{{Destination: "harbor.example.com/dockerhub", Signatures: &signature.Policy{TrustRoot: trustRoot, Identities: []string{"release@example.com"}}}}, ""); err == nil {
+		t.Errorf("expected trust roots without certificates to be rejected")
+	}
+
+	table, _ := New(nil, "harbor.example.com/dockerhub")
+	ref, _ := name.ParseReference("nginx:1.21")
+	if route, _ := table.Match(ref, "default"); route.Verifier() != nil {
+		t.Errorf("expected routes without signature policy not to verify images")
+	}
+}
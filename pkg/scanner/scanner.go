@@ -0,0 +1,145 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/Tiemma/image-clone-controller/pkg/docker"
+	"github.com/google/go-containerregistry/pkg/authn"
+)
+
+// ImagePlaceholder is replaced by the scanned reference in the command arguments,
+// the reference is appended when no argument holds it
+const ImagePlaceholder = "{image}"
+
+// Environment variables the scanner reads the staging registry credentials from
+const (
+	usernameEnv      = "TRIVY_USERNAME"
+	passwordEnv      = "TRIVY_PASSWORD"
+	registryTokenEnv = "TRIVY_REGISTRY_TOKEN"
+)
+
+// Exec runs a local scanner binary printing a Trivy compatible JSON report on stdout
+// e.g trivy image --quiet --format json {image}
+type Exec struct {
+	Command []string
+}
+
+// NewExec splits command on spaces, it must name the scanner binary first
+func NewExec(command string) (*Exec, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("scanner command must not be empty")
+	}
+
+	return &Exec{Command: fields}, nil
+}
+
+// report is the subset of a Trivy JSON report read for findings
+type report struct {
+	Results []result `json:"Results"`
+}
+
+type result struct {
+	Target          string          `json:"Target"`
+	Vulnerabilities []vulnerability `json:"Vulnerabilities"`
+}
+
+type vulnerability struct {
+	VulnerabilityID string `json:"VulnerabilityID"`
+	PkgName         string `json:"PkgName"`
+	Severity        string `json:"Severity"`
+	Title           string `json:"Title"`
+}
+
+// Scan runs the scanner against ref and returns its findings, implementing docker.Scanner.
+// The credentials of auth are passed as TRIVY_USERNAME and TRIVY_PASSWORD, or TRIVY_REGISTRY_TOKEN,
+// unless the controller environment already sets them
+func (e *Exec) Scan(ctx context.Context, ref string, auth authn.Authenticator) ([]docker.Finding, error) {
+	args := make([]string, 0, len(e.Command))
+	substituted := false
+	for _, arg := range e.Command[1:] {
+		if strings.Contains(arg, ImagePlaceholder) {
+			arg, substituted = strings.ReplaceAll(arg, ImagePlaceholder, ref), true
+		}
+		args = append(args, arg)
+	}
+	if !substituted {
+		args = append(args, ref)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.Command[0], args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	env, err := credentialsEnv(auth)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read credentials of %s: %w", ref, err)
+	}
+	cmd.Env = append(os.Environ(), env...)
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("scanner %s failed: %w: %s", e.Command[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return parseReport(stdout.Bytes())
+}
+
+// credentialsEnv returns the environment variables holding the credentials of auth that are not set yet
+func credentialsEnv(auth authn.Authenticator) ([]string, error) {
+	if auth == nil {
+		return nil, nil
+	}
+	config, err := auth.Authorization()
+	if err != nil {
+		return nil, err
+	}
+
+	var env []string
+	for _, variable := range [][2]string{
+		{usernameEnv, config.Username},
+		{passwordEnv, config.Password},
+		{registryTokenEnv, config.RegistryToken},
+	} {
+		if _, set := os.LookupEnv(variable[0]); variable[1] != "" && !set {
+			env = append(env, variable[0]+"="+variable[1])
+		}
+	}
+
+	return env, nil
+}
+
+// parseReport reads the findings of a Trivy report, either an object holding
+// Results or the bare list of results printed by older versions
+func parseReport(data []byte) ([]docker.Finding, error) {
+	var results []result
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("[")) {
+		if err := json.Unmarshal(data, &results); err != nil {
+			return nil, fmt.Errorf("cannot parse scanner report: %w", err)
+		}
+	} else {
+		r := report{}
+		if err := json.Unmarshal(data, &r); err != nil {
+			return nil, fmt.Errorf("cannot parse scanner report: %w", err)
+		}
+		results = r.Results
+	}
+
+	var findings []docker.Finding
+	for _, res := range results {
+		for _, vuln := range res.Vulnerabilities {
+			findings = append(findings, docker.Finding{
+				ID:       vuln.VulnerabilityID,
+				Package:  vuln.PkgName,
+				Severity: docker.ParseSeverity(vuln.Severity),
+				Title:    vuln.Title,
+			})
+		}
+	}
+
+	return findings, nil
+}
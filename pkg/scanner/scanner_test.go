@@ -0,0 +1,65 @@
+package scanner
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Tiemma/image-clone-controller/pkg/docker"
+	"github.com/google/go-containerregistry/pkg/authn"
+)
+
+func TestExec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scanner")
+	if err != nil {
+		t.Errorf("error occured creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The fake scanner reports a finding on the image passed as its last argument
+	script := filepath.Join(dir, "scan.sh")
+	content := `#!/bin/sh
+for image; do :; done
+if [ "$image" != "harbor.example.com/staging/nginx:1.21" ]; then echo "unexpected image $image" >&2; exit 1; fi
+if [ "$TRIVY_USERNAME:$TRIVY_PASSWORD" != "robot:secret" ]; then echo "unexpected credentials" >&2; exit 1; fi
+echo '{"Results":[{"Target":"'$image'","Vulnerabilities":[{"VulnerabilityID":"CVE-2021-1","PkgName":"openssl","Severity":"CRITICAL"},{"VulnerabilityID":"CVE-2021-2","PkgName":"zlib","Severity":"LOW"}]}]}'
+`
+	if err := ioutil.WriteFile(script, []byte(content), 0700); err != nil {
+		t.Errorf("error occured writing scanner: %s", err)
+	}
+
+	auth := authn.FromConfig(authn.AuthConfig{Username: "robot", Password: "secret"})
+	for _, command := range []string{script + " --format json " + ImagePlaceholder, script + " --format json"} {
+		scanner, err := NewExec(command)
+		if err != nil {
+			t.Errorf("error occured building scanner: %s", err)
+			continue
+		}
+		findings, err := scanner.Scan(context.Background(), "harbor.example.com/staging/nginx:1.21", auth)
+		if err != nil {
+			t.Errorf("error occured scanning with %q: %s", command, err)
+			continue
+		}
+		if len(findings) != 2 || findings[0].ID != "CVE-2021-1" || findings[0].Severity != docker.SeverityCritical || findings[1].Severity != docker.SeverityLow {
+			t.Errorf("expected the findings of the report, got %v", findings)
+		}
+	}
+
+	scanner, _ := NewExec(script)
+	if _, err := scanner.Scan(context.Background(), "other:1", auth); err == nil {
+		t.Errorf("expected scanner failures to be returned")
+	}
+}
+
+func TestParseReport(t *testing.T) {
+	findings, err := parseReport([]byte(`[{"Target":"nginx","Vulnerabilities":[{"VulnerabilityID":"CVE-2021-3","Severity":"HIGH"}]}]`))
+	if err != nil || len(findings) != 1 || findings[0].Severity != docker.SeverityHigh {
+		t.Errorf("expected reports of older versions to be read, got %v (%v)", findings, err)
+	}
+
+	if findings, err := parseReport([]byte(`{"Results":[{"Target":"nginx"}]}`)); err != nil || len(findings) != 0 {
+		t.Errorf("expected no findings, got %v (%v)", findings, err)
+	}
+}
@@ -0,0 +1,224 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	stderrors "errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+)
+
+// ErrNoValidSignature is returned when none of the signatures of an image can be verified
+var ErrNoValidSignature = stderrors.New("no valid signature")
+
+// Policy configures the cosign signatures the source images of a route must carry
+type Policy struct {
+	// PublicKeys are PEM files holding the keys images may be signed with e.g cosign.pub
+	PublicKeys []string `json:"publicKeys,omitempty"`
+	// TrustRoot is a PEM bundle of the certificate authorities keyless signing certificates must chain to
+	TrustRoot string `json:"trustRoot,omitempty"`
+	// Identities are the emails or URIs keyless signing certificates may be issued to, required with a trust root
+	Identities []string `json:"identities,omitempty"`
+}
+
+// Signature is a cosign signature of a payload. Keyless signatures carry the signing certificate and its chain
+type Signature struct {
+	Payload     []byte
+	Signature   []byte
+	Certificate []byte
+	Chain       []byte
+}
+
+// payload is the cosign simple signing payload
+type payload struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+	} `json:"critical"`
+}
+
+// Verifier checks signatures against the public keys and trust root of a policy
+type Verifier struct {
+	keys       []crypto.PublicKey
+	roots      *x509.CertPool
+	identities []string
+}
+
+// NewVerifier loads the keys and trust root of policy
+func NewVerifier(policy Policy) (*Verifier, error) {
+	if len(policy.PublicKeys) == 0 && policy.TrustRoot == "" {
+		return nil, fmt.Errorf("signature policy needs public keys or a trust root")
+	}
+	if len(policy.Identities) > 0 && policy.TrustRoot == "" {
+		return nil, fmt.Errorf("signature policy identities need a trust root")
+	}
+	// Public certificate authorities such as Fulcio issue certificates to anyone, the root alone trusts every signer
+	if policy.TrustRoot != "" && len(policy.Identities) == 0 {
+		return nil, fmt.Errorf("signature policy trust root needs identities")
+	}
+
+	v := &Verifier{identities: policy.Identities}
+	for _, path := range policy.PublicKeys {
+		keys, err := loadPublicKeys(path)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = append(v.keys, keys...)
+	}
+
+	if policy.TrustRoot != "" {
+		data, err := ioutil.ReadFile(policy.TrustRoot)
+		if err != nil {
+			return nil, err
+		}
+		v.roots = x509.NewCertPool()
+		if !v.roots.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("trust root %s holds no certificate", policy.TrustRoot)
+		}
+	}
+
+	return v, nil
+}
+
+func loadPublicKeys(path string) ([]crypto.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []crypto.PublicKey
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse public key %s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("public key file %s holds no key", path)
+	}
+
+	return keys, nil
+}
+
+// Verify checks that one of signatures is valid for the manifest digest, returning
+// ErrNoValidSignature with the reason of the last rejected signature otherwise
+func (v *Verifier) Verify(digest string, signatures []Signature) error {
+	reason := fmt.Errorf("image is not signed")
+	for _, sig := range signatures {
+		if err := v.verify(digest, sig); err != nil {
+			reason = err
+			continue
+		}
+		return nil
+	}
+
+	return fmt.Errorf("%w for %s: %v", ErrNoValidSignature, digest, reason)
+}
+
+func (v *Verifier) verify(digest string, sig Signature) error {
+	p := payload{}
+	if err := json.Unmarshal(sig.Payload, &p); err != nil {
+		return fmt.Errorf("cannot parse signature payload: %w", err)
+	}
+	if p.Critical.Image.DockerManifestDigest != digest {
+		return fmt.Errorf("signature is for %s", p.Critical.Image.DockerManifestDigest)
+	}
+
+	if sig.Certificate != nil {
+		return v.verifyKeyless(sig)
+	}
+	for _, key := range v.keys {
+		if verifySignature(key, sig.Payload, sig.Signature) == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("signature does not match any public key")
+}
+
+// verifyKeyless checks the signing certificate chains to the trust root and is issued to an allowed identity.
+// Certificates are checked as of their issuance, transparency log entries not being looked up
+func (v *Verifier) verifyKeyless(sig Signature) error {
+	if v.roots == nil {
+		return fmt.Errorf("keyless signature needs a trust root")
+	}
+
+	block, _ := pem.Decode(sig.Certificate)
+	if block == nil {
+		return fmt.Errorf("signing certificate is not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("cannot parse signing certificate: %w", err)
+	}
+
+	intermediates := x509.NewCertPool()
+	intermediates.AppendCertsFromPEM(sig.Chain)
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         v.roots,
+		Intermediates: intermediates,
+		CurrentTime:   cert.NotBefore,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return fmt.Errorf("signing certificate is not trusted: %w", err)
+	}
+	if !v.allowedIdentity(cert) {
+		return fmt.Errorf("signing certificate identity is not allowed")
+	}
+
+	return verifySignature(cert.PublicKey, sig.Payload, sig.Signature)
+}
+
+func (v *Verifier) allowedIdentity(cert *x509.Certificate) bool {
+	identities := append([]string{}, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+	for _, identity := range identities {
+		for _, allowed := range v.identities {
+			if strings.EqualFold(identity, allowed) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// verifySignature checks sig is the signature of the SHA-256 of payload by key, ed25519 keys signing payload itself
+func verifySignature(key crypto.PublicKey, payload, sig []byte) error {
+	hash := sha256.Sum256(payload)
+	switch pub := key.(type) {
+	case *ecdsa.PublicKey:
+		var ecdsaSig struct {
+			R, S *big.Int
+		}
+		if _, err := asn1.Unmarshal(sig, &ecdsaSig); err != nil {
+			return fmt.Errorf("cannot parse ECDSA signature: %w", err)
+		}
+		if !ecdsa.Verify(pub, hash[:], ecdsaSig.R, ecdsaSig.S) {
+			return fmt.Errorf("invalid ECDSA signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash[:], sig)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, payload, sig) {
+			return fmt.Errorf("invalid ed25519 signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+}
@@ -0,0 +1,154 @@
+package signature
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const digest = "sha256:4c1e0b0b7d8f8fd2e6a31f47c9f0c5c8b2e0a4f9f5a2f3c1d6e7b8a9c0d1e2f3"
+
+func testPayload(digest string) []byte {
+	return []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"docker.io/library/nginx"},"image":{"docker-manifest-digest":%q},"type":"cosign container image signature"}}`, digest))
+}
+
+func sign(t *testing.T, key *ecdsa.PrivateKey, payload []byte) []byte {
+	hash := sha256.Sum256(payload)
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sig
+}
+
+func writePEM(t *testing.T, dir, file, blockType string, der []byte) string {
+	path := filepath.Join(dir, file)
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestVerifyPublicKey(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "signature")
+	defer os.RemoveAll(dir)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+
+	verifier, err := NewVerifier(Policy{PublicKeys: []string{writePEM(t, dir, "cosign.pub", "PUBLIC KEY", der)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := testPayload(digest)
+	tests := map[string]struct {
+		signatures []Signature
+		valid      bool
+	}{
+		"signed":           {signatures: []Signature{{Payload: payload, Signature: sign(t, key, payload)}}, valid: true},
+		"unsigned":         {},
+		"other key":        {signatures: []Signature{{Payload: payload, Signature: sign(t, other, payload)}}},
+		"other image":      {signatures: []Signature{{Payload: testPayload("sha256:0000"), Signature: sign(t, key, testPayload("sha256:0000"))}}},
+		"one valid of two": {signatures: []Signature{{Payload: payload, Signature: sign(t, other, payload)}, {Payload: payload, Signature: sign(t, key, payload)}}, valid: true},
+	}
+	for desc, test := range tests {
+		if err := verifier.Verify(digest, test.signatures); (err == nil) != test.valid {
+			t.Errorf("%s: expected valid %v, got %v", desc, test.valid, err)
+		}
+	}
+}
+
+func TestVerifyKeyless(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "signature")
+	defer os.RemoveAll(dir)
+
+	rootKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	rootDER, _ := x509.CreateCertificate(rand.Reader, rootTemplate, rootTemplate, &rootKey.PublicKey, rootKey)
+	root, _ := x509.ParseCertificate(rootDER)
+
+	// Keyless certificates only live for minutes, verification must not depend on the current time
+	signingKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	identity, _ := url.Parse("https://github.com/example/app/.github/workflows/release.yaml@refs/heads/main")
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(-50 * time.Minute),
+		URIs:         []*url.URL{identity},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+	leafDER, _ := x509.CreateCertificate(rand.Reader, leaf, root, &signingKey.PublicKey, rootKey)
+	trustRoot := writePEM(t, dir, "root.pem", "CERTIFICATE", rootDER)
+
+	payload := testPayload(digest)
+	signatures := []Signature{{
+		Payload:     payload,
+		Signature:   sign(t, signingKey, payload),
+		Certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER}),
+	}}
+
+	verifier, err := NewVerifier(Policy{TrustRoot: trustRoot, Identities: []string{identity.String()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.Verify(digest, signatures); err != nil {
+		t.Errorf("expected keyless signature to be valid, got %s", err)
+	}
+
+	verifier, _ = NewVerifier(Policy{TrustRoot: trustRoot, Identities: []string{"release@example.com"}})
+	if err := verifier.Verify(digest, signatures); err == nil {
+		t.Errorf("expected signature of another identity to be rejected")
+	}
+
+	untrustedKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	untrustedDER, _ := x509.CreateCertificate(rand.Reader, rootTemplate, rootTemplate, &untrustedKey.PublicKey, untrustedKey)
+	verifier, err = NewVerifier(Policy{TrustRoot: writePEM(t, dir, "other.pem", "CERTIFICATE", untrustedDER), Identities: []string{identity.String()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.Verify(digest, signatures); err == nil {
+		t.Errorf("expected certificate outside of the trust root to be rejected")
+	}
+}
+
+func TestNewVerifier(t *testing.T) {
+	if _, err := NewVerifier(Policy{}); err == nil {
+		t.Errorf("expected an empty policy to be rejected")
+	}
+	if _, err := NewVerifier(Policy{PublicKeys: []string{"/nonexistent/cosign.pub"}}); err == nil {
+		t.Errorf("expected a missing public key to be rejected")
+	}
+	if _, err := NewVerifier(Policy{TrustRoot: "/etc/cosign/fulcio.pem"}); err == nil {
+		t.Errorf("expected a trust root without identities to be rejected")
+	}
+}
@@ -0,0 +1,116 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Tiemma/image-clone-controller/pkg/annotations"
+	"github.com/Tiemma/image-clone-controller/pkg/docker"
+	"github.com/Tiemma/image-clone-controller/pkg/errors"
+	"github.com/google/go-containerregistry/pkg/authn"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DefaultSecretName is the Secret holding the destination credentials of a namespace
+const DefaultSecretName = "image-clone-credentials"
+
+// Tenants isolates every namespace in its own destination, pushing with the
+// credentials of a dockerconfigjson Secret of the namespace. It also reads the
+// image pull secrets of workloads to check their access to private sources
+type Tenants struct {
+	reader     client.Reader
+	secretName string
+}
+
+// New reads namespaces and their Secrets through reader, secretName being used
+// by namespaces without a credentials-secret annotation
+func New(reader client.Reader, secretName string) *Tenants {
+	if secretName == "" {
+		secretName = DefaultSecretName
+	}
+
+	return &Tenants{reader: reader, secretName: secretName}
+}
+
+// Tenant returns the destination and credentials of namespace
+func (t *Tenants) Tenant(ctx context.Context, namespace string) (docker.Tenant, error) {
+	ns := &corev1.Namespace{}
+	if err := t.reader.Get(ctx, types.NamespacedName{Name: namespace}, ns); err != nil {
+		return docker.Tenant{}, errors.ErrorGettingResource("namespace", err)
+	}
+
+	secretName := t.secretName
+	if name, ok := ns.Annotations[annotations.CredentialsSecret]; ok && name != "" {
+		secretName = name
+	}
+
+	keychain, err := t.secretKeychain(ctx, namespace, secretName)
+	if apierrors.IsNotFound(err) {
+		return docker.Tenant{}, errors.Permanent(errors.Config, "", fmt.Errorf("namespace %s has no credentials Secret %s", namespace, secretName))
+	}
+	if err != nil {
+		return docker.Tenant{}, err
+	}
+
+	return docker.Tenant{Destination: ns.Annotations[annotations.Destination], Keychain: keychain}, nil
+}
+
+// Namespaces lists the names of every namespace of the cluster
+func (t *Tenants) Namespaces(ctx context.Context) ([]string, error) {
+	list := &corev1.NamespaceList{}
+	if err := t.reader.List(ctx, list); err != nil {
+		return nil, errors.ErrorGettingResource("namespaces", err)
+	}
+
+	namespaces := make([]string, 0, len(list.Items))
+	for _, ns := range list.Items {
+		namespaces = append(namespaces, ns.Name)
+	}
+
+	return namespaces, nil
+}
+
+// Keychain returns the credentials of the image pull secrets names of namespace,
+// implementing docker.PullSecrets. Missing secrets are ignored like the kubelet does
+func (t *Tenants) Keychain(ctx context.Context, namespace string, names []string) (authn.Keychain, error) {
+	keychains := make([]authn.Keychain, 0, len(names))
+	for _, name := range names {
+		keychain, err := t.secretKeychain(ctx, namespace, name)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		keychains = append(keychains, keychain)
+	}
+
+	return authn.NewMultiKeychain(keychains...), nil
+}
+
+// secretKeychain returns the credentials of the dockerconfigjson Secret name of namespace,
+// passing NotFound errors through unwrapped
+func (t *Tenants) secretKeychain(ctx context.Context, namespace, name string) (authn.Keychain, error) {
+	secret := &corev1.Secret{}
+	err := t.reader.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, secret)
+	if apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.ErrorGettingResource("secret", err)
+	}
+
+	data, ok := secret.Data[corev1.DockerConfigJsonKey]
+	if !ok || secret.Type != corev1.SecretTypeDockerConfigJson {
+		return nil, errors.Permanent(errors.Config, "", fmt.Errorf("secret %s/%s must be of type %s", namespace, name, corev1.SecretTypeDockerConfigJson))
+	}
+	keychain, err := docker.NewConfigKeychain(data)
+	if err != nil {
+		return nil, errors.Permanent(errors.Config, "", fmt.Errorf("secret %s/%s is not a valid docker config: %w", namespace, name, err))
+	}
+
+	return keychain, nil
+}
@@ -0,0 +1,71 @@
+package tenant
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Tiemma/image-clone-controller/pkg/annotations"
+	"github.com/Tiemma/image-clone-controller/pkg/errors"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestTenant(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	dockerConfig := []byte(`{"auths":{"harbor.example.com":{"username":"team-a","password":"secret"}}}`)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Annotations: map[string]string{
+			annotations.Destination:       "harbor.example.com/team-a",
+			annotations.CredentialsSecret: "harbor",
+		}}},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "harbor"},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data:       map[string][]byte{corev1.DockerConfigJsonKey: dockerConfig},
+		},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b"}},
+	).Build()
+	tenants := New(c, "")
+
+	tenant, err := tenants.Tenant(context.Background(), "team-a")
+	if err != nil {
+		t.Errorf("error occured resolving tenant: %s", err)
+		return
+	}
+	if tenant.Destination != "harbor.example.com/team-a" {
+		t.Errorf("expected the annotated destination, got %s", tenant.Destination)
+	}
+	registry, _ := name.NewRegistry("harbor.example.com")
+	auth, err := tenant.Keychain.Resolve(registry)
+	if err != nil {
+		t.Errorf("error occured resolving credentials: %s", err)
+		return
+	}
+	if config, _ := auth.Authorization(); config == nil || config.Username != "team-a" {
+		t.Errorf("expected the credentials of the namespace secret, got %v", config)
+	}
+	other, _ := name.NewRegistry("docker.io")
+	if auth, _ := tenant.Keychain.Resolve(other); auth != authn.Anonymous {
+		t.Errorf("expected other registries to be anonymous")
+	}
+
+	if _, err := tenants.Tenant(context.Background(), "team-b"); errors.TypeOf(err) != errors.Config {
+		t.Errorf("expected a missing secret to be a config error, got %v", err)
+	}
+
+	keychain, err := tenants.Keychain(context.Background(), "team-a", []string{"missing", "harbor"})
+	if err != nil {
+		t.Errorf("error occured reading pull secrets: %s", err)
+		return
+	}
+	auth, _ = keychain.Resolve(registry)
+	if config, _ := auth.Authorization(); config == nil || config.Username != "team-a" {
+		t.Errorf("expected the credentials of the pull secret, got %v", config)
+	}
+}